@@ -1,9 +1,9 @@
 package common
 
 type CartCreationInput struct {
-	UserID    uint `json:"userID" binding:"required"`
-	ProductID uint `json:"productID" binding:"required"`
-	Quantity  uint `json:"quantity" binding:"required,min=1"` // min quantity is 1
+	ProductID uint  `json:"productID" binding:"required"`
+	VariantID *uint `json:"variantID"`                         // required for products with variants
+	Quantity  uint  `json:"quantity" binding:"required,min=1"` // min quantity is 1
 }
 
 func NewCartCreationInput() *CartCreationInput {
@@ -4,6 +4,9 @@ type CategoryCreationInput struct {
 	Name        string `json:"name" binding:"required"`
 	Description string `json:"description"`
 	ParentID    *uint  `json:"parentID"`
+	// Defaults for the category's products, inherited by subcategories without their own
+	HSNCode            string `json:"hsnCode"`
+	TaxRateBasisPoints *int   `json:"taxRateBasisPoints"`
 }
 
 func NewCategoryCreationInput() *CategoryCreationInput {
@@ -11,9 +14,14 @@ func NewCategoryCreationInput() *CategoryCreationInput {
 }
 
 type CategoryUpdationInput struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	ParentID    *uint  `json:"parentID,omitempty"`
+	Name               string `json:"name"`
+	Description        string `json:"description"`
+	ParentID           *uint  `json:"parentID,omitempty"`
+	HSNCode            string `json:"hsnCode"`
+	TaxRateBasisPoints *int   `json:"taxRateBasisPoints"`
+	// Drop the category's own HSN code or tax rate, so the parent category's apply again
+	ClearHSNCode bool `json:"clearHsnCode"`
+	ClearTaxRate bool `json:"clearTaxRate"`
 }
 
 func NewCategoryUpdationInput() *CategoryUpdationInput {
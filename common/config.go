@@ -0,0 +1,71 @@
+package common
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Read a duration such as "15m" or "720h" from the environment, falling back when unset or invalid
+func DurationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Invalid %s value is %s. Using default of %s.", key, value, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// Read a positive integer from the environment, falling back when unset or invalid
+func IntFromEnv(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		log.Printf("Invalid %s value is %s. Using default of %d.", key, value, fallback)
+		return fallback
+	}
+	return number
+}
+
+// Read a boolean such as "true" or "0" from the environment, falling back when unset or invalid
+func BoolFromEnv(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid %s value is %s. Using default of %t.", key, value, fallback)
+		return fallback
+	}
+	return enabled
+}
+
+// Address the API is reached at from outside, used for links in emails. Set with PUBLIC_BASE_URL.
+func PublicBaseURL() string {
+	baseURL := strings.TrimRight(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if baseURL == "" {
+		return "http://localhost:8080"
+	}
+	return baseURL
+}
+
+// Whether the server runs in production, as set with GIN_MODE=release.
+// Development-only providers refuse to start in it.
+func IsReleaseMode() bool {
+	return gin.Mode() == gin.ReleaseMode
+}
@@ -0,0 +1,43 @@
+package common
+
+type StockMovementInput struct {
+	ProductID uint   `json:"productID" binding:"required"`
+	VariantID *uint  `json:"variantID"`
+	Kind      string `json:"kind" binding:"required"`
+	Quantity  int    `json:"quantity" binding:"required"`
+	Note      string `json:"note" binding:"max=255"`
+}
+
+func NewStockMovementInput() *StockMovementInput {
+	return &StockMovementInput{}
+}
+
+type VariantCreationInput struct {
+	SKU               string `json:"sku" binding:"required,max=191"`
+	Name              string `json:"name" binding:"required"`
+	LowStockThreshold *int   `json:"lowStockThreshold" binding:"omitempty,min=0"`
+}
+
+func NewVariantCreationInput() *VariantCreationInput {
+	return &VariantCreationInput{}
+}
+
+type VariantUpdationInput struct {
+	SKU               string `json:"sku" binding:"max=191"`
+	Name              string `json:"name"`
+	LowStockThreshold *int   `json:"lowStockThreshold" binding:"omitempty,min=0"`
+}
+
+func NewVariantUpdationInput() *VariantUpdationInput {
+	return &VariantUpdationInput{}
+}
+
+// A product or variant in the low-stock report
+type LowStockItem struct {
+	ProductID uint   `json:"productID"`
+	VariantID *uint  `json:"variantID,omitempty"`
+	SKU       string `json:"sku"`
+	Name      string `json:"name"`
+	Stock     int    `json:"stock"`
+	Threshold int    `json:"threshold"`
+}
@@ -0,0 +1,16 @@
+package common
+
+import "time"
+
+// What a background job has done since the process started
+type JobStats struct {
+	Name         string     `json:"name"`
+	Interval     string     `json:"interval"`
+	Runs         int64      `json:"runs"`
+	Failures     int64      `json:"failures"`
+	RemovedTotal int64      `json:"removedTotal"`
+	LastRemoved  int64      `json:"lastRemoved"`
+	LastRunAt    *time.Time `json:"lastRunAt"`
+	LastDuration string     `json:"lastDuration"`
+	LastError    string     `json:"lastError,omitempty"`
+}
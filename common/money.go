@@ -0,0 +1,182 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidMoney     = errors.New("invalid amount of money")
+	ErrCurrencyMismatch = errors.New("amounts are in different currencies")
+)
+
+// Money is an amount in the minor unit of its currency, such as paise for INR, so sums are exact.
+// Embedded in a model with a prefix it is stored as <prefix>minor and <prefix>currency, and in
+// JSON it is written as {"amount": "499.00", "currency": "INR"}.
+type Money struct {
+	Minor    int64  `gorm:"not null;default:0"`
+	Currency string `gorm:"size:3;not null;default:''"`
+}
+
+// Currency of prices given without one, DEFAULT_CURRENCY or INR
+func DefaultCurrency() string {
+	currency := strings.ToUpper(strings.TrimSpace(os.Getenv("DEFAULT_CURRENCY")))
+	if currency == "" {
+		return "INR"
+	}
+	return currency
+}
+
+// Digits after the decimal point of the currencies that don't use two
+var currencyExponents = map[string]int{
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+}
+
+func currencyExponent(currency string) int {
+	if exponent, ok := currencyExponents[currency]; ok {
+		return exponent
+	}
+	return 2
+}
+
+func validCurrency(currency string) bool {
+	if len(currency) != 3 {
+		return false
+	}
+	for _, letter := range currency {
+		if letter < 'A' || letter > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func NewMoney(minor int64, currency string) Money {
+	return Money{Minor: minor, Currency: currency}
+}
+
+// Read a decimal amount such as "499", "1299.5" or "-10.25" in the given currency,
+// or in DefaultCurrency when it is empty
+func ParseMoney(amount string, currency string) (Money, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		currency = DefaultCurrency()
+	}
+	if !validCurrency(currency) {
+		return Money{}, fmt.Errorf("%w: unknown currency %q", ErrInvalidMoney, currency)
+	}
+
+	amount = strings.TrimSpace(amount)
+	negative := strings.HasPrefix(amount, "-")
+	units, fraction, _ := strings.Cut(strings.TrimPrefix(amount, "-"), ".")
+	exponent := currencyExponent(currency)
+	if units == "" || len(fraction) > exponent || strings.ContainsAny(units+fraction, "+-") {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, amount)
+	}
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	minor, err := strconv.ParseInt(units+fraction, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, amount)
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+// The amount as a decimal string with the currency's digits, such as "499.00"
+func (money Money) Decimal() string {
+	exponent := currencyExponent(money.Currency)
+	minor := money.Minor
+	sign := ""
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	if exponent == 0 {
+		return sign + strconv.FormatInt(minor, 10)
+	}
+
+	scale := int64(1)
+	for i := 0; i < exponent; i++ {
+		scale *= 10
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/scale, exponent, minor%scale)
+}
+
+func (money Money) String() string {
+	return money.Decimal() + " " + money.Currency
+}
+
+func (money Money) IsZero() bool {
+	return money.Minor == 0
+}
+
+func (money Money) IsNegative() bool {
+	return money.Minor < 0
+}
+
+// A zero amount adopts the other's currency, so sums can start from Money{}
+func (money Money) Add(other Money) (Money, error) {
+	if money.Currency != other.Currency && money.Currency != "" && other.Currency != "" {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, money.Currency, other.Currency)
+	}
+	currency := money.Currency
+	if currency == "" {
+		currency = other.Currency
+	}
+	return Money{Minor: money.Minor + other.Minor, Currency: currency}, nil
+}
+
+func (money Money) Sub(other Money) (Money, error) {
+	return money.Add(Money{Minor: -other.Minor, Currency: other.Currency})
+}
+
+func (money Money) Mul(quantity int64) Money {
+	return Money{Minor: money.Minor * quantity, Currency: money.Currency}
+}
+
+type moneyJSON struct {
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency"`
+}
+
+func (money Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{money.Decimal(), money.Currency})
+}
+
+// Accepts {"amount": "499.00", "currency": "INR"} with the amount as string or number, and a bare
+// amount such as "499.00" or 499 in DefaultCurrency, the form prices were sent in before
+func (money *Money) UnmarshalJSON(data []byte) error {
+	var value moneyJSON
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") {
+		decoder := json.NewDecoder(strings.NewReader(trimmed))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidMoney, err)
+		}
+	} else {
+		var amount string
+		if err := json.Unmarshal(data, &amount); err != nil {
+			amount = trimmed
+		}
+		value.Amount = json.Number(amount)
+	}
+
+	parsed, err := ParseMoney(value.Amount.String(), value.Currency)
+	if err != nil {
+		return err
+	}
+	*money = parsed
+	return nil
+}
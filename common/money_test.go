@@ -0,0 +1,55 @@
+package common
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	t.Setenv("DEFAULT_CURRENCY", "")
+
+	tests := []struct {
+		name     string
+		amount   string
+		currency string
+		want     Money
+		wantErr  bool
+	}{
+		{name: "whole amount", amount: "499", currency: "INR", want: NewMoney(49900, "INR")},
+		{name: "one decimal", amount: "1299.5", currency: "INR", want: NewMoney(129950, "INR")},
+		{name: "two decimals", amount: "0.05", currency: "INR", want: NewMoney(5, "INR")},
+		{name: "negative", amount: "-10.25", currency: "INR", want: NewMoney(-1025, "INR")},
+		{name: "spaces and lower case currency", amount: " 12.00 ", currency: "usd", want: NewMoney(1200, "USD")},
+		{name: "default currency", amount: "7", currency: "", want: NewMoney(700, "INR")},
+		{name: "zero decimal currency", amount: "100", currency: "JPY", want: NewMoney(100, "JPY")},
+		{name: "three decimal currency", amount: "1.234", currency: "KWD", want: NewMoney(1234, "KWD")},
+		{name: "too many decimals", amount: "1.005", currency: "INR", wantErr: true},
+		{name: "decimals in zero decimal currency", amount: "1.5", currency: "JPY", wantErr: true},
+		{name: "empty", amount: "", currency: "INR", wantErr: true},
+		{name: "no units", amount: ".5", currency: "INR", wantErr: true},
+		{name: "sign only", amount: "-", currency: "INR", wantErr: true},
+		{name: "plus sign", amount: "+5", currency: "INR", wantErr: true},
+		{name: "double minus", amount: "--5", currency: "INR", wantErr: true},
+		{name: "two points", amount: "1.2.3", currency: "INR", wantErr: true},
+		{name: "letters", amount: "abc", currency: "INR", wantErr: true},
+		{name: "unknown currency", amount: "5", currency: "RUPEE", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseMoney(test.amount, test.currency)
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidMoney) {
+					t.Fatalf("ParseMoney(%q, %q) error = %v, want ErrInvalidMoney", test.amount, test.currency, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMoney(%q, %q) error = %v", test.amount, test.currency, err)
+			}
+			if got != test.want {
+				t.Errorf("ParseMoney(%q, %q) = %+v, want %+v", test.amount, test.currency, got, test.want)
+			}
+		})
+	}
+}
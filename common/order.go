@@ -0,0 +1,18 @@
+package common
+
+type OrderStatusInput struct {
+	Status string `json:"status" binding:"required"`
+	Reason string `json:"reason" binding:"max=255"`
+}
+
+func NewOrderStatusInput() *OrderStatusInput {
+	return &OrderStatusInput{}
+}
+
+type OrderCancelInput struct {
+	Reason string `json:"reason" binding:"max=255"`
+}
+
+func NewOrderCancelInput() *OrderCancelInput {
+	return &OrderCancelInput{}
+}
@@ -1,16 +1,39 @@
 package common
 
 import (
-	"math/rand"
-	"time"
+	"crypto/rand"
+	"fmt"
+	"math/big"
 )
 
-func GenerateOTP(length int) string {
-	rand.Seed(time.Now().UnixNano())
+// A numeric code drawn uniformly with crypto/rand
+func GenerateOTP(length int) (string, error) {
 	const chars = "0123456789"
-	result := make([]byte,length)
-	for i:=0; i<length; i++ {
-		result[i] = chars[rand.Intn(len(chars))]
+	result := make([]byte, length)
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		if err != nil {
+			return "", fmt.Errorf("failed to generate OTP: %w", err)
+		}
+		result[i] = chars[n.Int64()]
 	}
-	return string(result)
-}
\ No newline at end of file
+	return string(result), nil
+}
+
+const (
+	OtpChannelSMS   = "sms"
+	OtpChannelEmail = "email"
+)
+
+// Destination is a phone number for the sms channel and an email address for the email channel
+type OtpLoginStartInput struct {
+	Channel     string `json:"channel" binding:"required,oneof=sms email"`
+	Destination string `json:"destination" binding:"required"`
+}
+
+type OtpLoginCompleteInput struct {
+	Channel     string `json:"channel" binding:"required,oneof=sms email"`
+	Destination string `json:"destination" binding:"required"`
+	Otp         string `json:"otp" binding:"required"`
+	DeviceLabel string `json:"device"`
+}
@@ -0,0 +1,10 @@
+package common
+
+type PaymentInput struct {
+	// Provider specific payment method, optional
+	Method string `json:"method" binding:"max=50"`
+}
+
+func NewPaymentInput() *PaymentInput {
+	return &PaymentInput{}
+}
@@ -0,0 +1,35 @@
+package common
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+var ErrInvalidPhoneNumber = errors.New("invalid phone number")
+
+// Region used for numbers written without a country code, DEFAULT_PHONE_REGION or India
+func DefaultPhoneRegion() string {
+	region := strings.ToUpper(strings.TrimSpace(os.Getenv("DEFAULT_PHONE_REGION")))
+	if region == "" {
+		return "IN"
+	}
+	return region
+}
+
+// Parse a phone number as typed by a user and return it in E.164 form, such as +919876543210.
+// Numbers without a leading + or international prefix are read as local to DefaultPhoneRegion.
+func NormalizePhoneNumber(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", ErrInvalidPhoneNumber
+	}
+
+	number, err := phonenumbers.Parse(raw, DefaultPhoneRegion())
+	if err != nil || !phonenumbers.IsValidNumber(number) {
+		return "", ErrInvalidPhoneNumber
+	}
+	return phonenumbers.Format(number, phonenumbers.E164), nil
+}
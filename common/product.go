@@ -4,18 +4,31 @@ type ProductCreationInput struct {
 	SKU         string `json:"sku" gorm:"uniqueIndex"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
-	Price       string `json:"price"`
+	Price       *Money `json:"price" binding:"required"`
 	Image       string `json:"image,omitempty"`
 	CategoryID  uint   `json:"categoryID"`
+	// Opening stock, recorded as a receipt
+	Stock             int  `json:"stock" binding:"min=0"`
+	LowStockThreshold *int `json:"lowStockThreshold" binding:"omitempty,min=0"`
+	// Overrides the category's, TAX_RATE applies when neither has one
+	HSNCode            string `json:"hsnCode"`
+	TaxRateBasisPoints *int   `json:"taxRateBasisPoints"`
 }
 
 type ProductUpdationInput struct {
 	SKU         string `json:"sku"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
-	Price       string `json:"price"`
+	Price       *Money `json:"price"`
 	Image       string `json:"image,omitempty"`
 	CategoryID  uint   `json:"categoryID"`
+	// Stock only changes through inventory movements
+	LowStockThreshold  *int   `json:"lowStockThreshold" binding:"omitempty,min=0"`
+	HSNCode            string `json:"hsnCode"`
+	TaxRateBasisPoints *int   `json:"taxRateBasisPoints"`
+	// Drop the product's own HSN code or tax rate, so the category's apply again
+	ClearHSNCode bool `json:"clearHsnCode"`
+	ClearTaxRate bool `json:"clearTaxRate"`
 }
 
 func NewProductCreationInput() *ProductCreationInput {
@@ -25,3 +38,16 @@ func NewProductCreationInput() *ProductCreationInput {
 func NewProductUpdationInput() *ProductUpdationInput {
 	return &ProductUpdationInput{}
 }
+
+// Sorting and price filter of the product list. Prices are decimals in the currency,
+// DefaultCurrency when not given, and only products priced in it are listed.
+type ProductListQuery struct {
+	Sort     string `form:"sort"`
+	MinPrice string `form:"minPrice"`
+	MaxPrice string `form:"maxPrice"`
+	Currency string `form:"currency"`
+}
+
+func NewProductListQuery() *ProductListQuery {
+	return &ProductListQuery{}
+}
@@ -0,0 +1,100 @@
+package common
+
+import "time"
+
+const (
+	CouponKindPercentage   = "percentage"
+	CouponKindFixed        = "fixed"
+	CouponKindFreeShipping = "free_shipping"
+
+	PromotionKindBuyXGetY = "buy_x_get_y"
+	PromotionKindTiered   = "tiered"
+)
+
+type CouponCreationInput struct {
+	Code         string     `json:"code" binding:"required,max=64"`
+	Description  string     `json:"description"`
+	Kind         string     `json:"kind" binding:"required,oneof=percentage fixed free_shipping"`
+	PercentOff   int        `json:"percentOff" binding:"min=0,max=100"`
+	AmountOff    *Money     `json:"amountOff"`
+	MinSubtotal  *Money     `json:"minSubtotal"`
+	StartsAt     *time.Time `json:"startsAt"`
+	EndsAt       *time.Time `json:"endsAt"`
+	UsageLimit   *int       `json:"usageLimit" binding:"omitempty,min=1"`
+	PerUserLimit *int       `json:"perUserLimit" binding:"omitempty,min=1"`
+	Active       *bool      `json:"active"`
+	ProductIDs   []uint     `json:"productIDs"`
+	CategoryIDs  []uint     `json:"categoryIDs"`
+}
+
+func NewCouponCreationInput() *CouponCreationInput {
+	return &CouponCreationInput{}
+}
+
+// Fields left out are unchanged. An empty productIDs or categoryIDs list removes the scope.
+type CouponUpdationInput struct {
+	Description  *string    `json:"description"`
+	PercentOff   *int       `json:"percentOff" binding:"omitempty,min=0,max=100"`
+	AmountOff    *Money     `json:"amountOff"`
+	MinSubtotal  *Money     `json:"minSubtotal"`
+	StartsAt     *time.Time `json:"startsAt"`
+	EndsAt       *time.Time `json:"endsAt"`
+	UsageLimit   *int       `json:"usageLimit" binding:"omitempty,min=1"`
+	PerUserLimit *int       `json:"perUserLimit" binding:"omitempty,min=1"`
+	Active       *bool      `json:"active"`
+	ProductIDs   []uint     `json:"productIDs"`
+	CategoryIDs  []uint     `json:"categoryIDs"`
+}
+
+func NewCouponUpdationInput() *CouponUpdationInput {
+	return &CouponUpdationInput{}
+}
+
+type CouponApplicationInput struct {
+	Code string `json:"code" binding:"required,max=64"`
+}
+
+func NewCouponApplicationInput() *CouponApplicationInput {
+	return &CouponApplicationInput{}
+}
+
+type PromotionTierInput struct {
+	MinSubtotal Money `json:"minSubtotal"`
+	PercentOff  int   `json:"percentOff" binding:"required,min=1,max=100"`
+}
+
+type PromotionCreationInput struct {
+	Name          string               `json:"name" binding:"required"`
+	Kind          string               `json:"kind" binding:"required,oneof=buy_x_get_y tiered"`
+	BuyQuantity   int                  `json:"buyQuantity" binding:"min=0"`
+	GetQuantity   int                  `json:"getQuantity" binding:"min=0"`
+	GetPercentOff *int                 `json:"getPercentOff" binding:"omitempty,min=1,max=100"`
+	Tiers         []PromotionTierInput `json:"tiers" binding:"dive"`
+	StartsAt      *time.Time           `json:"startsAt"`
+	EndsAt        *time.Time           `json:"endsAt"`
+	Active        *bool                `json:"active"`
+	ProductIDs    []uint               `json:"productIDs"`
+	CategoryIDs   []uint               `json:"categoryIDs"`
+}
+
+func NewPromotionCreationInput() *PromotionCreationInput {
+	return &PromotionCreationInput{}
+}
+
+// Fields left out are unchanged. Tiers, when given, replace the promotion's tiers.
+type PromotionUpdationInput struct {
+	Name          *string              `json:"name"`
+	BuyQuantity   *int                 `json:"buyQuantity" binding:"omitempty,min=0"`
+	GetQuantity   *int                 `json:"getQuantity" binding:"omitempty,min=0"`
+	GetPercentOff *int                 `json:"getPercentOff" binding:"omitempty,min=1,max=100"`
+	Tiers         []PromotionTierInput `json:"tiers" binding:"dive"`
+	StartsAt      *time.Time           `json:"startsAt"`
+	EndsAt        *time.Time           `json:"endsAt"`
+	Active        *bool                `json:"active"`
+	ProductIDs    []uint               `json:"productIDs"`
+	CategoryIDs   []uint               `json:"categoryIDs"`
+}
+
+func NewPromotionUpdationInput() *PromotionUpdationInput {
+	return &PromotionUpdationInput{}
+}
@@ -0,0 +1,67 @@
+package common
+
+const (
+	RoleCustomer = "customer"
+	RoleStaff    = "staff"
+	RoleAdmin    = "admin"
+)
+
+const (
+	PermissionCatalogWrite       = "catalog:write"
+	PermissionUsersManage        = "users:manage"
+	PermissionRolesManage        = "roles:manage"
+	PermissionCartsReadAll       = "carts:read_all"
+	PermissionCartsManageAll     = "carts:manage_all"
+	PermissionWishlistsReadAll   = "wishlists:read_all"
+	PermissionWishlistsManageAll = "wishlists:manage_all"
+	PermissionOrdersReadAll      = "orders:read_all"
+	PermissionOrdersManage       = "orders:manage"
+	PermissionInventoryManage    = "inventory:manage"
+	PermissionPromotionsManage   = "promotions:manage"
+	PermissionMaintenanceRead    = "maintenance:read"
+	PermissionMaintenanceWrite   = "maintenance:write"
+)
+
+// Every permission known to the API, with the description stored alongside it.
+var AllPermissions = map[string]string{
+	PermissionCatalogWrite:       "Create, update and delete products and categories",
+	PermissionUsersManage:        "List, create, update and delete user accounts",
+	PermissionRolesManage:        "Manage roles and assign them to users",
+	PermissionCartsReadAll:       "View every user's cart",
+	PermissionCartsManageAll:     "Update and remove items in any user's cart",
+	PermissionWishlistsReadAll:   "View every user's wishlist",
+	PermissionWishlistsManageAll: "Remove items from any user's wishlist",
+	PermissionOrdersReadAll:      "View every user's orders",
+	PermissionOrdersManage:       "Change the status of any order and refund payments",
+	PermissionInventoryManage:    "Record stock movements, manage variants and view low stock",
+	PermissionPromotionsManage:   "Create and change coupons and automatic promotions",
+	PermissionMaintenanceRead:    "View background job metrics and the email outbox",
+	PermissionMaintenanceWrite:   "Requeue failed emails",
+}
+
+type RoleCreationInput struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleUpdationInput struct {
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleAssignmentInput struct {
+	Role string `json:"role" binding:"required"`
+}
+
+func NewRoleCreationInput() *RoleCreationInput {
+	return &RoleCreationInput{}
+}
+
+func NewRoleUpdationInput() *RoleUpdationInput {
+	return &RoleUpdationInput{}
+}
+
+func NewRoleAssignmentInput() *RoleAssignmentInput {
+	return &RoleAssignmentInput{}
+}
@@ -0,0 +1,24 @@
+package common
+
+// Returned when a user starts enrolling an authenticator app
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpAuthURL string `json:"otpauthURL"`
+	QRCode     string `json:"qrCode"`
+}
+
+type TwoFactorCodeInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorDisableInput struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// Second login step, the code is either a TOTP code or an unused recovery code
+type TwoFactorLoginInput struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+	DeviceLabel    string `json:"device"`
+}
@@ -1,11 +1,16 @@
 package common
 
 import (
-	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
+	"errors"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type Address struct {
@@ -41,8 +46,22 @@ type UserUpdationInput struct {
 }
 
 type LoginInput struct {
-	Email    string `json:"email" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	Email       string `json:"email" binding:"required"`
+	Password    string `json:"password" binding:"required"`
+	DeviceLabel string `json:"device"`
+}
+
+type ForgotPasswordInput struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResendVerificationInput struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordInput struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
 }
 
 type AuthResponse struct {
@@ -51,7 +70,8 @@ type AuthResponse struct {
 }
 
 type LogoutInput struct {
-	Token string `json:"token" binding:"required"`
+	Token        string `json:"token" binding:"required"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 type requestResponse struct {
@@ -61,13 +81,15 @@ type requestResponse struct {
 }
 
 type ProfileResponse struct {
-	Id        uint    `json:"id"`
-	FirstName string  `json:"firstName"`
-	LastName  string  `json:"lastName"`
-	Email     string  `json:"email"`
-	Phone     string  `json:"phone"`
-	Address   Address `json:"address"`
-	Image     string  `json:"image,omitempty"`
+	Id            uint    `json:"id"`
+	FirstName     string  `json:"firstName"`
+	LastName      string  `json:"lastName"`
+	Email         string  `json:"email"`
+	Phone         string  `json:"phone"`
+	PhoneVerified bool    `json:"phoneVerified"`
+	PendingEmail  string  `json:"pendingEmail,omitempty"`
+	Address       Address `json:"address"`
+	Image         string  `json:"image,omitempty"`
 }
 
 func NewUserCreationInput() *UserCreationInput {
@@ -105,6 +127,49 @@ func BadResponse(ctx *gin.Context, msg string) {
 	ctx.JSON(http.StatusBadRequest, response)
 }
 
+func UnauthorizedResponse(ctx *gin.Context, msg string) {
+	response := requestResponse{
+		Message: msg,
+		Status:  http.StatusUnauthorized,
+	}
+	ctx.AbortWithStatusJSON(http.StatusUnauthorized, response)
+}
+
+func ForbiddenResponse(ctx *gin.Context, msg string) {
+	response := requestResponse{
+		Message: msg,
+		Status:  http.StatusForbidden,
+	}
+	ctx.AbortWithStatusJSON(http.StatusForbidden, response)
+}
+
+func NotFoundResponse(ctx *gin.Context, msg string) {
+	response := requestResponse{
+		Message: msg,
+		Status:  http.StatusNotFound,
+	}
+	ctx.JSON(http.StatusNotFound, response)
+}
+
+func ConflictResponse(ctx *gin.Context, msg string) {
+	response := requestResponse{
+		Message: msg,
+		Status:  http.StatusConflict,
+	}
+	ctx.JSON(http.StatusConflict, response)
+}
+
+// Respond 429 and tell the client how many seconds to wait before retrying
+func TooManyRequestsResponse(ctx *gin.Context, msg string, retryAfter time.Duration) {
+	response := requestResponse{
+		Message: msg,
+		Status:  http.StatusTooManyRequests,
+	}
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	ctx.Header("Retry-After", strconv.Itoa(seconds))
+	ctx.AbortWithStatusJSON(http.StatusTooManyRequests, response)
+}
+
 func InternalServerErrorResponse(ctx *gin.Context, msg string) {
 	response := requestResponse{
 		Message: msg,
@@ -113,13 +178,28 @@ func InternalServerErrorResponse(ctx *gin.Context, msg string) {
 	ctx.JSON(http.StatusInternalServerError, response)
 }
 
-func GenerateJWT(email string) (string, error) {
+// Lifetime of access tokens, short because refresh tokens renew them
+func AccessTokenTTL() time.Duration {
+	return DurationFromEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
+
+// Sign an access token for a login session, returning the token and its jti
+func GenerateJWT(email string, sessionID uint) (string, string, error) {
 	jwtKey := []byte(os.Getenv("JWT_SECRET_KEY"))
 
-	expirationTime := time.Now().Add(1 * time.Hour)
+	tokenID, err := uuid.NewRandom()
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	expirationTime := now.Add(AccessTokenTTL())
 	claims := &JWTClaim{
-		Email: email,
+		Email:     email,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
@@ -127,14 +207,36 @@ func GenerateJWT(email string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString(jwtKey)
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+
+	return tokenString, claims.ID, nil
+}
+
+// Parse and validate a signed access token
+func ParseJWT(tokenString string) (*JWTClaim, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &JWTClaim{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(os.Getenv("JWT_SECRET_KEY")), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*JWTClaim)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token claims")
+	}
+
+	if claims.ID == "" || claims.SessionID == 0 {
+		return nil, errors.New("token has no ID or session")
 	}
 
-	return tokenString, nil
+	return claims, nil
 }
 
 type JWTClaim struct {
-	Email string
+	Email     string
+	SessionID uint `json:"sid"`
 	jwt.RegisteredClaims
 }
 
@@ -1,7 +1,6 @@
 package common
 
 type WishlistCreationInput struct {
-	UserID    uint `json:"userID" binding:"required"`
 	ProductID uint `json:"productID" binding:"required"`
 }
 
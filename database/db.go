@@ -3,8 +3,11 @@ package database
 import (
 	"fmt"
 	"log"
+	"main/common"
 	"main/models"
 	"os"
+	"strconv"
+
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
@@ -32,12 +35,216 @@ func Initialize() {
 		panic("Failed to connect database")
 	}
 
-	err = DB.AutoMigrate(&models.User{},&models.Category{},&models.Product{}, &models.Wishlist{},&models.Cart{},&models.Otp{})
+	// Products were not stock tracked before the stock column was added
+	openingStock := 0
+	if DB.Migrator().HasTable(&models.Product{}) && !DB.Migrator().HasColumn(&models.Product{}, "stock") {
+		openingStock = openingStockFromEnv()
+	}
+
+	err = DB.AutoMigrate(Models()...)
 	if err != nil {
 		log.Fatalf("Failed to auto-migrate database: %v", err)
 		panic("Failed to Automigrate database")
 	}
 
+	// The single users.token column was replaced by the sessions table
+	if DB.Migrator().HasColumn(&models.User{}, "token") {
+		if DB.Migrator().HasIndex(&models.User{}, "idx_users_token") {
+			if err := DB.Migrator().DropIndex(&models.User{}, "idx_users_token"); err != nil {
+				log.Fatalf("Failed to drop users token index: %v", err)
+			}
+		}
+		if err := DB.Migrator().DropColumn(&models.User{}, "token"); err != nil {
+			log.Fatalf("Failed to drop users token column: %v", err)
+		}
+	}
+
+	// OTPs are stored as HMACs now, plaintext codes still in the table are dropped with their column
+	if DB.Migrator().HasColumn(&models.Otp{}, "otp") {
+		if err := DB.Where("1 = 1").Delete(&models.Otp{}).Error; err != nil {
+			log.Fatalf("Failed to clear plaintext OTPs: %v", err)
+		}
+		if err := DB.Migrator().DropColumn(&models.Otp{}, "otp"); err != nil {
+			log.Fatalf("Failed to drop otps otp column: %v", err)
+		}
+	}
+
+	// Orders placed before the status lifecycle existed wait for payment
+	if err := DB.Model(&models.Order{}).Where("status = ?", "placed").Update("status", "pending_payment").Error; err != nil {
+		log.Fatalf("Failed to migrate placed orders: %v", err)
+	}
+
+	// Prices and totals were decimal strings before they became Money columns
+	migrateMoneyColumn(&models.Product{}, "price", "price_", "")
+	migrateMoneyColumn(&models.Order{}, "total", "total_", "")
+	migrateMoneyColumn(&models.OrderItem{}, "unit_price", "unit_price_", "")
+	migrateMoneyColumn(&models.OrderItem{}, "line_total", "line_total_", "")
+	migrateMoneyColumn(&models.Payment{}, "amount", "amount_", "currency")
+
+	// Orders placed before the pricing breakdown existed were charged their item totals alone
+	result := DB.Model(&models.Order{}).Where("subtotal_currency = '' AND total_currency <> ''").UpdateColumns(map[string]interface{}{
+		"subtotal_minor":        gorm.Expr("total_minor"),
+		"subtotal_currency":     gorm.Expr("total_currency"),
+		"discount_currency":     gorm.Expr("total_currency"),
+		"tax_currency":          gorm.Expr("total_currency"),
+		"shipping_fee_currency": gorm.Expr("total_currency"),
+	})
+	if result.Error != nil {
+		log.Fatalf("Failed to backfill order subtotals: %v", result.Error)
+	}
+	result = DB.Model(&models.OrderItem{}).Where("discount_currency = '' AND line_total_currency <> ''").UpdateColumns(map[string]interface{}{
+		"discount_currency": gorm.Expr("line_total_currency"),
+		"tax_currency":      gorm.Expr("line_total_currency"),
+	})
+	if result.Error != nil {
+		log.Fatalf("Failed to backfill order item discounts: %v", result.Error)
+	}
+
+	// Tax was added on top of the discounted line total before the tax breakdown was recorded
+	result = DB.Model(&models.OrderItem{}).Where("taxable_value_currency = '' AND line_total_currency <> ''").UpdateColumns(map[string]interface{}{
+		"taxable_value_minor":    gorm.Expr("line_total_minor - discount_minor"),
+		"taxable_value_currency": gorm.Expr("line_total_currency"),
+	})
+	if result.Error != nil {
+		log.Fatalf("Failed to backfill order item taxable values: %v", result.Error)
+	}
+
+	if openingStock > 0 {
+		backfillOpeningStock(openingStock)
+	}
+
 	log.Println("Database connection established and auto-migration complete.")
 
 }
+
+// Every table owned by the application, in migration order
+func Models() []interface{} {
+	return []interface{}{
+		&models.Permission{}, &models.Role{}, &models.User{}, &models.Category{}, &models.Product{}, &models.ProductVariant{}, &models.StockMovement{},
+		&models.Wishlist{}, &models.Cart{}, &models.Otp{}, &models.Session{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.PasswordResetToken{},
+		&models.LoginAttempt{}, &models.RecoveryCode{}, &models.TwoFactorChallenge{}, &models.OtpSend{}, &models.EmailChange{}, &models.EmailSend{},
+		&models.OutboxEmail{}, &models.Order{}, &models.OrderItem{}, &models.OrderItemDiscount{}, &models.OrderItemTax{}, &models.OrderTransition{},
+		&models.Payment{}, &models.PaymentEvent{}, &models.Coupon{}, &models.CouponRedemption{}, &models.CartCoupon{}, &models.Promotion{}, &models.PromotionTier{},
+	}
+}
+
+// Parse a legacy decimal string column into the minor units and currency columns of a Money field,
+// reading the currency from currencyColumn or using the default currency. The legacy columns are
+// dropped once every row has been read. Rows that can't be read are logged and keep an empty
+// currency until they are fixed by hand.
+func migrateMoneyColumn(model interface{}, legacyColumn string, prefix string, currencyColumn string) {
+	if !DB.Migrator().HasColumn(model, legacyColumn) {
+		return
+	}
+
+	currency := "''"
+	if currencyColumn != "" {
+		currency = currencyColumn
+	}
+
+	var rows []struct {
+		Id       uint
+		Amount   *string
+		Currency *string
+	}
+	result := DB.Model(model).Unscoped().
+		Select("id, " + legacyColumn + " AS amount, " + currency + " AS currency").
+		Where(prefix + "currency = ''").
+		Scan(&rows)
+	if result.Error != nil {
+		log.Fatalf("Failed to read %s values: %v", legacyColumn, result.Error)
+	}
+
+	failed := 0
+	for _, row := range rows {
+		var amount, rowCurrency string
+		if row.Amount != nil {
+			amount = *row.Amount
+		}
+		if row.Currency != nil {
+			rowCurrency = *row.Currency
+		}
+
+		money, err := common.ParseMoney(amount, rowCurrency)
+		if err != nil {
+			log.Printf("Cannot migrate %s of row %d: %v", legacyColumn, row.Id, err)
+			failed++
+			continue
+		}
+		result := DB.Model(model).Unscoped().Where("id = ?", row.Id).UpdateColumns(map[string]interface{}{
+			prefix + "minor":    money.Minor,
+			prefix + "currency": money.Currency,
+		})
+		if result.Error != nil {
+			log.Fatalf("Failed to migrate %s of row %d: %v", legacyColumn, row.Id, result.Error)
+		}
+	}
+
+	if failed > 0 {
+		log.Printf("%d rows still have a %s that is not a valid amount, the column is kept until they are fixed", failed, legacyColumn)
+		return
+	}
+	for _, column := range []string{legacyColumn, currencyColumn} {
+		if column == "" {
+			continue
+		}
+		if err := DB.Migrator().DropColumn(model, column); err != nil {
+			log.Fatalf("Failed to drop %s column: %v", column, err)
+		}
+	}
+}
+
+// Stock given to every existing product when stock tracking is first enabled, read from OPENING_STOCK.
+// It is required when there are products, so the catalogue isn't left out of stock.
+func openingStockFromEnv() int {
+	var products int64
+	if err := DB.Model(&models.Product{}).Count(&products).Error; err != nil {
+		log.Fatalf("Failed to count products: %v", err)
+	}
+	if products == 0 {
+		return 0
+	}
+
+	value := os.Getenv("OPENING_STOCK")
+	quantity, err := strconv.Atoi(value)
+	if err != nil || quantity < 0 {
+		log.Fatalf("OPENING_STOCK must be set to the stock %d existing products start with, got %q", products, value)
+	}
+	return quantity
+}
+
+// Give every existing product its opening stock, recorded as a receipt like the opening stock
+// of new products
+func backfillOpeningStock(quantity int) {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var productIDs []uint
+		if err := tx.Model(&models.Product{}).Pluck("id", &productIDs).Error; err != nil {
+			return fmt.Errorf("failed to list products: %w", err)
+		}
+		if len(productIDs) == 0 {
+			return nil
+		}
+
+		if err := tx.Model(&models.Product{}).Where("id IN ?", productIDs).UpdateColumn("stock", quantity).Error; err != nil {
+			return fmt.Errorf("failed to set opening stock: %w", err)
+		}
+		movements := make([]models.StockMovement, 0, len(productIDs))
+		for _, productID := range productIDs {
+			movements = append(movements, models.StockMovement{
+				ProductID:  productID,
+				Kind:       "receipt",
+				Quantity:   quantity,
+				StockAfter: quantity,
+				Note:       "opening stock",
+			})
+		}
+		if err := tx.CreateInBatches(movements, 500).Error; err != nil {
+			return fmt.Errorf("failed to record opening stock: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Failed to backfill opening stock: %v", err)
+	}
+	log.Printf("Gave existing products an opening stock of %d", quantity)
+}
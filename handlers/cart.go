@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"main/common"
 	"main/managers"
 	"strconv"
@@ -21,12 +22,49 @@ func NewCartHandler(cartManager managers.CartManager) *CartHandler {
 }
 
 func (carthandler *CartHandler) RegisterCartApis(router *gin.Engine) {
-	cartGroup := router.Group(carthandler.groupName)
+	cartGroup := router.Group(carthandler.groupName, AuthMiddleware())
 	cartGroup.POST("", carthandler.Add)
-	cartGroup.GET(":userid", carthandler.View)
-	cartGroup.GET("", carthandler.ViewAll)
+	cartGroup.GET("me", carthandler.ViewMine)
+	cartGroup.POST("coupon", carthandler.ApplyCoupon)
+	cartGroup.DELETE("coupon", carthandler.RemoveCoupon)
 	cartGroup.PATCH(":cartid", carthandler.Update)
 	cartGroup.DELETE(":cartid", carthandler.Delete)
+
+	// Admin override: act on any user's cart
+	readAllGroup := cartGroup.Group("", RequirePermission(common.PermissionCartsReadAll))
+	readAllGroup.GET("", carthandler.ViewAll)
+	readAllGroup.GET("users/:userid", carthandler.View)
+
+	manageAllGroup := cartGroup.Group("admin", AdminOverride(common.PermissionCartsManageAll))
+	manageAllGroup.PATCH(":cartid", carthandler.Update)
+	manageAllGroup.DELETE(":cartid", carthandler.Delete)
+}
+
+// Resolve the cart item from the path, refusing items that belong to another user
+// outside the admin override routes
+func (carthandler *CartHandler) cartItemID(ctx *gin.Context) (uint, bool) {
+	cartID, err := strconv.Atoi(ctx.Param("cartid"))
+	if err != nil {
+		common.BadResponse(ctx, "Invalid Cart ID")
+		return 0, false
+	}
+
+	cartItem, err := carthandler.cartManager.Get(uint(cartID))
+	if err != nil {
+		if errors.Is(err, managers.ErrCartItemNotFound) {
+			common.NotFoundResponse(ctx, "Cart item not found")
+			return 0, false
+		}
+		common.InternalServerErrorResponse(ctx, "Failed to get cart item")
+		return 0, false
+	}
+
+	if !isAdminOverride(ctx) && cartItem.UserID != currentUser(ctx).Id {
+		common.ForbiddenResponse(ctx, "Cart item belongs to another user")
+		return 0, false
+	}
+
+	return cartItem.Id, true
 }
 
 func (carthandler *CartHandler) Add(ctx *gin.Context) {
@@ -36,8 +74,11 @@ func (carthandler *CartHandler) Add(ctx *gin.Context) {
 		return
 	}
 
-	newCartItem, err := carthandler.cartManager.Add(cartData)
+	newCartItem, err := carthandler.cartManager.Add(currentUser(ctx).Id, cartData)
 	if err != nil {
+		if respondStockError(ctx, err) {
+			return
+		}
 		common.InternalServerErrorResponse(ctx, "Failed to add product to cart")
 		return
 	}
@@ -45,6 +86,16 @@ func (carthandler *CartHandler) Add(ctx *gin.Context) {
 	common.SuccessResponseWithData(ctx, "Product added to cart successfully", newCartItem)
 }
 
+func (carthandler *CartHandler) ViewMine(ctx *gin.Context) {
+	summary, err := carthandler.cartManager.View(currentUser(ctx).Id)
+	if err != nil {
+		carthandler.respondViewError(ctx, err)
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Cart retrieved successfully", summary)
+}
+
 func (carthandler *CartHandler) View(ctx *gin.Context) {
 	userIDStr := ctx.Param("userid")
 	userID, err := strconv.Atoi(userIDStr)
@@ -53,13 +104,51 @@ func (carthandler *CartHandler) View(ctx *gin.Context) {
 		return
 	}
 
-	cartItems, err := carthandler.cartManager.View(uint(userID))
+	summary, err := carthandler.cartManager.View(uint(userID))
+	if err != nil {
+		carthandler.respondViewError(ctx, err)
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Cart retrieved successfully", summary)
+}
+
+// Apply a coupon code to your cart, replacing the one it had
+func (carthandler *CartHandler) ApplyCoupon(ctx *gin.Context) {
+	couponData := common.NewCouponApplicationInput()
+	if err := ctx.BindJSON(couponData); err != nil {
+		common.BadResponse(ctx, "Failed to bind coupon data")
+		return
+	}
+
+	summary, err := carthandler.cartManager.ApplyCoupon(currentUser(ctx).Id, couponData.Code)
 	if err != nil {
-		common.InternalServerErrorResponse(ctx, "Failed to view cart")
+		if respondCouponError(ctx, err) {
+			return
+		}
+		carthandler.respondViewError(ctx, err)
 		return
 	}
 
-	common.SuccessResponseWithData(ctx, "Cart retrieved successfully", cartItems)
+	common.SuccessResponseWithData(ctx, "Coupon applied successfully", summary)
+}
+
+func (carthandler *CartHandler) RemoveCoupon(ctx *gin.Context) {
+	summary, err := carthandler.cartManager.RemoveCoupon(currentUser(ctx).Id)
+	if err != nil {
+		carthandler.respondViewError(ctx, err)
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Coupon removed successfully", summary)
+}
+
+func (carthandler *CartHandler) respondViewError(ctx *gin.Context, err error) {
+	if errors.Is(err, common.ErrCurrencyMismatch) {
+		common.BadResponse(ctx, "Cart has products priced in different currencies")
+		return
+	}
+	common.InternalServerErrorResponse(ctx, "Failed to view cart")
 }
 
 func (carthandler *CartHandler) ViewAll(ctx *gin.Context) {
@@ -73,10 +162,8 @@ func (carthandler *CartHandler) ViewAll(ctx *gin.Context) {
 }
 
 func (carthandler *CartHandler) Update(ctx *gin.Context) {
-	cartIDStr := ctx.Param("cartid")
-	cartID, err := strconv.Atoi(cartIDStr)
-	if err != nil {
-		common.BadResponse(ctx, "Invalid Cart ID")
+	cartID, ok := carthandler.cartItemID(ctx)
+	if !ok {
 		return
 	}
 
@@ -86,8 +173,11 @@ func (carthandler *CartHandler) Update(ctx *gin.Context) {
 		return
 	}
 
-	updatedCartItem, err := carthandler.cartManager.Update(uint(cartID), updateData)
+	updatedCartItem, err := carthandler.cartManager.Update(cartID, updateData)
 	if err != nil {
+		if respondStockError(ctx, err) {
+			return
+		}
 		common.InternalServerErrorResponse(ctx, "Failed to update cart item")
 		return
 	}
@@ -96,14 +186,12 @@ func (carthandler *CartHandler) Update(ctx *gin.Context) {
 }
 
 func (carthandler *CartHandler) Delete(ctx *gin.Context) {
-	cartIDStr := ctx.Param("cartid")
-	cartID, err := strconv.Atoi(cartIDStr)
-	if err != nil {
-		common.BadResponse(ctx, "Invalid Cart ID")
+	cartID, ok := carthandler.cartItemID(ctx)
+	if !ok {
 		return
 	}
 
-	err = carthandler.cartManager.Delete(uint(cartID))
+	err := carthandler.cartManager.Delete(cartID)
 	if err != nil {
 		common.InternalServerErrorResponse(ctx, "Failed to delete the cart item")
 		return
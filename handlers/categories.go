@@ -23,9 +23,11 @@ func (categoryhandler *CategoryHandler) RegisterCategoryApis(router *gin.Engine)
 	categoryGroup := router.Group(categoryhandler.groupName)
 	categoryGroup.GET("", categoryhandler.List)
 	categoryGroup.GET(":categoryid", categoryhandler.Get)
-	categoryGroup.POST("", categoryhandler.Create)
-	categoryGroup.PATCH(":categoryid", categoryhandler.Update)
-	categoryGroup.DELETE(":categoryid", categoryhandler.Delete)
+
+	adminGroup := categoryGroup.Group("", AuthMiddleware(), RequirePermission(common.PermissionCatalogWrite))
+	adminGroup.POST("", categoryhandler.Create)
+	adminGroup.PATCH(":categoryid", categoryhandler.Update)
+	adminGroup.DELETE(":categoryid", categoryhandler.Delete)
 }
 
 func (categoryhandler *CategoryHandler) List(ctx *gin.Context) {
@@ -67,6 +69,9 @@ func (categoryhandler *CategoryHandler) Create(ctx *gin.Context) {
 
 	newCategory, err := categoryhandler.categoryManager.Create(categoryData)
 	if err != nil {
+		if respondTaxFieldError(ctx, err) {
+			return
+		}
 		common.InternalServerErrorResponse(ctx, "Failed to create category")
 		return
 	}
@@ -88,6 +93,9 @@ func (categoryhandler *CategoryHandler) Update(ctx *gin.Context) {
 
 	updatedCategory, err := categoryhandler.categoryManager.Update(categoryID, categoryUpdateData)
 	if err != nil {
+		if respondTaxFieldError(ctx, err) {
+			return
+		}
 		common.InternalServerErrorResponse(ctx, "Failed to update category")
 		return
 	}
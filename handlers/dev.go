@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"main/common"
+	"main/managers"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DevHandler serves development helpers and is not registered in release mode
+type DevHandler struct {
+	groupName string
+}
+
+func NewDevHandler() *DevHandler {
+	return &DevHandler{
+		"api/dev",
+	}
+}
+
+func (devHandler *DevHandler) RegisterDevApis(router *gin.Engine) {
+	devGroup := router.Group(devHandler.groupName)
+	devGroup.GET("/emails", devHandler.ListEmailTemplates)
+	devGroup.GET("/emails/:name", devHandler.PreviewEmail)
+}
+
+func (devHandler *DevHandler) ListEmailTemplates(ctx *gin.Context) {
+	common.SuccessResponseWithData(ctx, "Email templates retrieved successfully", managers.EmailTemplateNames())
+}
+
+// Render an email with sample data, as HTML by default or with format=text or format=json
+func (devHandler *DevHandler) PreviewEmail(ctx *gin.Context) {
+	message, err := managers.PreviewEmail(ctx.Param("name"))
+	if err != nil {
+		if errors.Is(err, managers.ErrUnknownEmailTemplate) {
+			common.NotFoundResponse(ctx, "Email template not found")
+			return
+		}
+		log.Printf("Failed to render email preview: %v", err)
+		common.InternalServerErrorResponse(ctx, "Failed to render email")
+		return
+	}
+
+	switch ctx.Query("format") {
+	case "text":
+		ctx.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(message.Text))
+	case "json":
+		common.SuccessResponseWithData(ctx, "Email rendered successfully", message)
+	default:
+		ctx.Data(http.StatusOK, "text/html; charset=utf-8", []byte(message.HTML))
+	}
+}
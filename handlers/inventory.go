@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"main/common"
+	"main/managers"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type InventoryHandler struct {
+	groupName        string
+	inventoryManager managers.InventoryManager
+}
+
+func NewInventoryHandler(inventoryManager managers.InventoryManager) *InventoryHandler {
+	return &InventoryHandler{
+		"api/inventory",
+		inventoryManager,
+	}
+}
+
+func (inventoryHandler *InventoryHandler) RegisterInventoryApis(router *gin.Engine) {
+	inventoryGroup := router.Group(inventoryHandler.groupName, AuthMiddleware(), RequirePermission(common.PermissionInventoryManage))
+	inventoryGroup.POST("movements", inventoryHandler.RecordMovement)
+	inventoryGroup.GET("products/:productid/movements", inventoryHandler.Movements)
+	inventoryGroup.GET("low-stock", inventoryHandler.LowStock)
+	inventoryGroup.POST("products/:productid/variants", inventoryHandler.CreateVariant)
+	inventoryGroup.PATCH("variants/:variantid", inventoryHandler.UpdateVariant)
+}
+
+// Record a receipt, a return or a manual adjustment of a product's or variant's stock
+func (inventoryHandler *InventoryHandler) RecordMovement(ctx *gin.Context) {
+	movementData := common.NewStockMovementInput()
+	if err := ctx.BindJSON(movementData); err != nil {
+		common.BadResponse(ctx, "Failed to bind stock movement data")
+		return
+	}
+
+	movement, err := inventoryHandler.inventoryManager.RecordMovement(movementData, currentUser(ctx).Id)
+	if err != nil {
+		if errors.Is(err, managers.ErrInvalidStockMovement) {
+			common.BadResponse(ctx, err.Error())
+			return
+		}
+		if respondStockError(ctx, err) {
+			return
+		}
+		common.InternalServerErrorResponse(ctx, "Failed to record stock movement")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Stock movement recorded successfully", movement)
+}
+
+func (inventoryHandler *InventoryHandler) Movements(ctx *gin.Context) {
+	productID, err := strconv.Atoi(ctx.Param("productid"))
+	if err != nil {
+		common.BadResponse(ctx, "Invalid Product ID")
+		return
+	}
+
+	movements, err := inventoryHandler.inventoryManager.Movements(uint(productID))
+	if err != nil {
+		common.InternalServerErrorResponse(ctx, "Failed to list stock movements")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Stock movements retrieved successfully", movements)
+}
+
+// Products and variants at or below their low-stock threshold
+func (inventoryHandler *InventoryHandler) LowStock(ctx *gin.Context) {
+	items, err := inventoryHandler.inventoryManager.LowStock()
+	if err != nil {
+		common.InternalServerErrorResponse(ctx, "Failed to build low stock report")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Low stock report retrieved successfully", items)
+}
+
+func (inventoryHandler *InventoryHandler) CreateVariant(ctx *gin.Context) {
+	productID, err := strconv.Atoi(ctx.Param("productid"))
+	if err != nil {
+		common.BadResponse(ctx, "Invalid Product ID")
+		return
+	}
+
+	variantData := common.NewVariantCreationInput()
+	if err := ctx.BindJSON(variantData); err != nil {
+		common.BadResponse(ctx, "Failed to bind variant data")
+		return
+	}
+
+	variant, err := inventoryHandler.inventoryManager.CreateVariant(uint(productID), variantData)
+	if err != nil {
+		respondVariantError(ctx, err, "Failed to create variant")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Variant created successfully", variant)
+}
+
+func (inventoryHandler *InventoryHandler) UpdateVariant(ctx *gin.Context) {
+	variantID, err := strconv.Atoi(ctx.Param("variantid"))
+	if err != nil {
+		common.BadResponse(ctx, "Invalid Variant ID")
+		return
+	}
+
+	variantData := common.NewVariantUpdationInput()
+	if err := ctx.BindJSON(variantData); err != nil {
+		common.BadResponse(ctx, "Failed to bind variant data")
+		return
+	}
+
+	variant, err := inventoryHandler.inventoryManager.UpdateVariant(uint(variantID), variantData)
+	if err != nil {
+		respondVariantError(ctx, err, "Failed to update variant")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Variant updated successfully", variant)
+}
+
+func respondVariantError(ctx *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, managers.ErrProductUnavailable):
+		common.NotFoundResponse(ctx, "Product not found")
+	case errors.Is(err, managers.ErrVariantNotFound):
+		common.NotFoundResponse(ctx, "Variant not found")
+	case errors.Is(err, managers.ErrVariantSKUExists):
+		common.ConflictResponse(ctx, "A variant with this SKU already exists")
+	case errors.Is(err, managers.ErrProductHasStock):
+		common.ConflictResponse(ctx, "Adjust the product's stock to zero before adding variants")
+	default:
+		common.InternalServerErrorResponse(ctx, fallback)
+	}
+}
+
+// Respond to the stock and variant errors shared by the cart, checkout and inventory routes,
+// reporting whether err was one of them
+func respondStockError(ctx *gin.Context, err error) bool {
+	var stockErr *managers.InsufficientStockError
+	switch {
+	case errors.As(err, &stockErr):
+		common.ConflictResponse(ctx, fmt.Sprintf("Only %d left in stock", stockErr.Available))
+	case errors.Is(err, managers.ErrVariantRequired):
+		common.BadResponse(ctx, "Choose a variant of this product")
+	case errors.Is(err, managers.ErrVariantNotFound):
+		common.BadResponse(ctx, "Variant not found for this product")
+	case errors.Is(err, managers.ErrProductUnavailable):
+		common.BadResponse(ctx, "Product not found")
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"main/common"
+	"main/managers"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MaintenanceHandler struct {
+	groupName          string
+	scheduler          managers.Scheduler
+	emailOutboxManager managers.EmailOutboxManager
+}
+
+func NewMaintenanceHandler(scheduler managers.Scheduler, emailOutboxManager managers.EmailOutboxManager) *MaintenanceHandler {
+	return &MaintenanceHandler{
+		"api/maintenance",
+		scheduler,
+		emailOutboxManager,
+	}
+}
+
+func (maintenanceHandler *MaintenanceHandler) RegisterMaintenanceApis(router *gin.Engine) {
+	maintenanceGroup := router.Group(maintenanceHandler.groupName, AuthMiddleware(), RequirePermission(common.PermissionMaintenanceRead))
+	maintenanceGroup.GET("/jobs", maintenanceHandler.ListJobs)
+	maintenanceGroup.GET("/emails", maintenanceHandler.ListEmails)
+	maintenanceGroup.GET("/emails/:id", maintenanceHandler.GetEmail)
+	maintenanceGroup.POST("/emails/:id/requeue", RequirePermission(common.PermissionMaintenanceWrite), maintenanceHandler.RequeueEmail)
+}
+
+// Runs, failures and removed rows of every background job since the process started
+func (maintenanceHandler *MaintenanceHandler) ListJobs(ctx *gin.Context) {
+	common.SuccessResponseWithData(ctx, "Jobs retrieved successfully", maintenanceHandler.scheduler.Stats())
+}
+
+// The latest outbox emails, optionally filtered with ?status=pending|sent|dead
+func (maintenanceHandler *MaintenanceHandler) ListEmails(ctx *gin.Context) {
+	emails, err := maintenanceHandler.emailOutboxManager.List(ctx.Query("status"))
+	if err != nil {
+		if errors.Is(err, managers.ErrUnknownOutboxStatus) {
+			common.BadResponse(ctx, "Status must be pending, sent or dead")
+			return
+		}
+		common.InternalServerErrorResponse(ctx, "Failed to list emails")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Emails retrieved successfully", emails)
+}
+
+func (maintenanceHandler *MaintenanceHandler) GetEmail(ctx *gin.Context) {
+	email, err := maintenanceHandler.emailOutboxManager.Get(ctx.Param("id"))
+	if err != nil {
+		respondOutboxError(ctx, err, "Failed to get email")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Email retrieved successfully", email)
+}
+
+// Retry a dead email with a fresh set of attempts
+func (maintenanceHandler *MaintenanceHandler) RequeueEmail(ctx *gin.Context) {
+	email, err := maintenanceHandler.emailOutboxManager.Requeue(ctx.Param("id"))
+	if err != nil {
+		respondOutboxError(ctx, err, "Failed to requeue email")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Email requeued successfully", email)
+}
+
+func respondOutboxError(ctx *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, managers.ErrOutboxEmailNotFound):
+		common.NotFoundResponse(ctx, "Email not found")
+	case errors.Is(err, managers.ErrOutboxEmailSent):
+		common.BadResponse(ctx, "Email has already been sent")
+	default:
+		common.InternalServerErrorResponse(ctx, fallback)
+	}
+}
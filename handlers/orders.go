@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"main/common"
+	"main/managers"
+	"main/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OrderHandler struct {
+	groupName    string
+	orderManager managers.OrderManager
+}
+
+func NewOrderHandler(orderManager managers.OrderManager) *OrderHandler {
+	return &OrderHandler{
+		"api/orders",
+		orderManager,
+	}
+}
+
+func (orderHandler *OrderHandler) RegisterOrderApis(router *gin.Engine) {
+	orderGroup := router.Group(orderHandler.groupName, AuthMiddleware())
+	orderGroup.POST("checkout", orderHandler.Checkout)
+	orderGroup.GET("me", orderHandler.ListMine)
+	orderGroup.GET(":orderid", orderHandler.Get)
+	orderGroup.POST(":orderid/cancel", orderHandler.Cancel)
+
+	// Admin view across every user's orders
+	readAllGroup := orderGroup.Group("", RequirePermission(common.PermissionOrdersReadAll))
+	readAllGroup.GET("", orderHandler.ListAll)
+	readAllGroup.GET("users/:userid", orderHandler.List)
+
+	adminGroup := orderGroup.Group("admin", AdminOverride(common.PermissionOrdersReadAll))
+	adminGroup.GET(":orderid", orderHandler.Get)
+
+	manageGroup := orderGroup.Group("admin", AdminOverride(common.PermissionOrdersManage))
+	manageGroup.PATCH(":orderid/status", orderHandler.UpdateStatus)
+}
+
+// Resolve the order from the path, refusing orders of other users outside the admin routes
+func (orderHandler *OrderHandler) order(ctx *gin.Context) (*models.Order, bool) {
+	orderID, err := strconv.Atoi(ctx.Param("orderid"))
+	if err != nil {
+		common.BadResponse(ctx, "Invalid Order ID")
+		return nil, false
+	}
+
+	order, err := orderHandler.orderManager.Get(uint(orderID))
+	if err != nil {
+		if errors.Is(err, managers.ErrOrderNotFound) {
+			common.NotFoundResponse(ctx, "Order not found")
+			return nil, false
+		}
+		common.InternalServerErrorResponse(ctx, "Failed to get order")
+		return nil, false
+	}
+
+	if !isAdminOverride(ctx) && order.UserID != currentUser(ctx).Id {
+		common.ForbiddenResponse(ctx, "Order belongs to another user")
+		return nil, false
+	}
+
+	return order, true
+}
+
+func (orderHandler *OrderHandler) Checkout(ctx *gin.Context) {
+	order, err := orderHandler.orderManager.Checkout(currentUser(ctx).Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, managers.ErrCartEmpty):
+			common.BadResponse(ctx, "Your cart is empty")
+		case errors.Is(err, managers.ErrProductUnavailable):
+			common.BadResponse(ctx, "A product in your cart is no longer available")
+		case errors.Is(err, common.ErrCurrencyMismatch):
+			common.BadResponse(ctx, "Products in your cart are priced in different currencies")
+		case errors.Is(err, managers.ErrInvalidPrice):
+			common.BadResponse(ctx, "A product in your cart has no valid price")
+		default:
+			if !respondStockError(ctx, err) && !respondCouponError(ctx, err) {
+				common.InternalServerErrorResponse(ctx, "Failed to place the order")
+			}
+		}
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Order placed successfully", order)
+}
+
+func (orderHandler *OrderHandler) ListMine(ctx *gin.Context) {
+	orders, err := orderHandler.orderManager.List(currentUser(ctx).Id)
+	if err != nil {
+		common.InternalServerErrorResponse(ctx, "Failed to list orders")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Orders retrieved successfully", orders)
+}
+
+func (orderHandler *OrderHandler) List(ctx *gin.Context) {
+	userID, err := strconv.Atoi(ctx.Param("userid"))
+	if err != nil {
+		common.BadResponse(ctx, "Invalid User ID")
+		return
+	}
+
+	orders, err := orderHandler.orderManager.List(uint(userID))
+	if err != nil {
+		common.InternalServerErrorResponse(ctx, "Failed to list orders")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Orders retrieved successfully", orders)
+}
+
+func (orderHandler *OrderHandler) ListAll(ctx *gin.Context) {
+	orders, err := orderHandler.orderManager.ListAll()
+	if err != nil {
+		common.InternalServerErrorResponse(ctx, "Failed to list all orders")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "All orders retrieved successfully", orders)
+}
+
+// A single order with its items and status history
+func (orderHandler *OrderHandler) Get(ctx *gin.Context) {
+	order, ok := orderHandler.order(ctx)
+	if !ok {
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Order retrieved successfully", order)
+}
+
+// Cancel one of your orders before it has been shipped. The body with a reason is optional.
+func (orderHandler *OrderHandler) Cancel(ctx *gin.Context) {
+	order, ok := orderHandler.order(ctx)
+	if !ok {
+		return
+	}
+
+	cancelData := common.NewOrderCancelInput()
+	if err := ctx.ShouldBindJSON(cancelData); err != nil && !errors.Is(err, io.EOF) {
+		common.BadResponse(ctx, "Failed to bind cancel data")
+		return
+	}
+
+	cancelledOrder, err := orderHandler.orderManager.Cancel(order.Id, currentUser(ctx).Id, cancelData.Reason)
+	if err != nil {
+		var transitionErr *managers.InvalidTransitionError
+		if errors.As(err, &transitionErr) {
+			common.ConflictResponse(ctx, "Order can no longer be cancelled, it is "+transitionErr.From)
+			return
+		}
+		respondOrderStatusError(ctx, err)
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Order cancelled successfully", cancelledOrder)
+}
+
+func (orderHandler *OrderHandler) UpdateStatus(ctx *gin.Context) {
+	order, ok := orderHandler.order(ctx)
+	if !ok {
+		return
+	}
+
+	statusData := common.NewOrderStatusInput()
+	if err := ctx.BindJSON(statusData); err != nil {
+		common.BadResponse(ctx, "Failed to bind status data")
+		return
+	}
+
+	updatedOrder, err := orderHandler.orderManager.UpdateStatus(order.Id, statusData, currentUser(ctx).Id)
+	if err != nil {
+		respondOrderStatusError(ctx, err)
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Order status updated successfully", updatedOrder)
+}
+
+func respondOrderStatusError(ctx *gin.Context, err error) {
+	var transitionErr *managers.InvalidTransitionError
+	switch {
+	case errors.As(err, &transitionErr):
+		common.ConflictResponse(ctx, "Order cannot move from "+transitionErr.From+" to "+transitionErr.To)
+	case errors.Is(err, managers.ErrUnknownOrderStatus):
+		common.BadResponse(ctx, "Unknown order status")
+	case errors.Is(err, managers.ErrOrderNotFound):
+		common.NotFoundResponse(ctx, "Order not found")
+	default:
+		common.InternalServerErrorResponse(ctx, "Failed to update order status")
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"log"
 	"main/common"
 	"main/managers"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,56 +23,124 @@ func NewOtpHandler(otpManager managers.OtpManager) *OtpHandler {
 
 func (otpHandler *OtpHandler) RegisterOtpApis(router *gin.Engine) {
 	otpGroup := router.Group(otpHandler.groupName)
-	otpGroup.POST("/send", otpHandler.SendOTP)
-	otpGroup.POST("/verify", otpHandler.VerifyOTP)
+	otpGroup.POST("/send", AuthMiddleware(), otpHandler.SendOTP)
+	otpGroup.POST("/verify", AuthMiddleware(), otpHandler.VerifyOTP)
+	otpGroup.POST("/login/start", otpHandler.StartLogin)
+	otpGroup.POST("/login/complete", otpHandler.CompleteLogin)
 }
 
+// Send a verification code to the phone number on the logged in user's profile
 func (otpHandler *OtpHandler) SendOTP(ctx *gin.Context) {
-	userIDStr := ctx.Query("user_id")
-	phoneNumber := ctx.Query("phone_number")
+	err := otpHandler.otpManager.SendOTP(currentUser(ctx).Id)
+	if err != nil {
+		var rateLimited *managers.OtpRateLimitError
+		if errors.As(err, &rateLimited) {
+			common.TooManyRequestsResponse(ctx, "Too many OTP requests. Please wait before requesting another code.", rateLimited.RetryAfter)
+			return
+		}
+		if respondPhoneError(ctx, err) {
+			return
+		}
+		log.Printf("Failed to send the OTP: %v", err)
+		common.InternalServerErrorResponse(ctx, "Failed to send OTP")
+		return
+	}
+
+	common.SuccessResponse(ctx, "OTP sent successfully")
+}
 
-	if userIDStr == "" || phoneNumber == "" {
-		common.BadResponse(ctx, "User ID and phone number are required")
+// Mark the logged in user's phone number as verified with the code from SendOTP
+func (otpHandler *OtpHandler) VerifyOTP(ctx *gin.Context) {
+	otp := ctx.Query("otp")
+
+	if otp == "" {
+		common.BadResponse(ctx, "OTP is required")
 		return
 	}
 
-	userID, err := strconv.ParseUint(userIDStr, 10, 32)
+	err := otpHandler.otpManager.VerifyOTP(currentUser(ctx).Id, otp)
 	if err != nil {
-		common.BadResponse(ctx, "Invalid user ID")
+		if respondPhoneError(ctx, err) {
+			return
+		}
+		if errors.Is(err, managers.ErrInvalidOTP) {
+			common.BadResponse(ctx, "Invalid OTP")
+		} else if errors.Is(err, managers.ErrOTPExpired) {
+			common.BadResponse(ctx, "OTP Expired")
+		} else if errors.Is(err, managers.ErrOTPAttemptsExceeded) {
+			common.BadResponse(ctx, "Too many wrong attempts. Please request a new OTP.")
+		} else {
+			log.Printf("Failed to verify OTP: %v", err)
+			common.InternalServerErrorResponse(ctx, "Failed to verify OTP")
+		}
 		return
 	}
 
-	err = otpHandler.otpManager.SendOTP(uint(userID), phoneNumber)
+	common.SuccessResponse(ctx, "Phone number verified successfully")
+}
+
+func respondPhoneError(ctx *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, managers.ErrPhoneMissing):
+		common.BadResponse(ctx, "Add a phone number to your profile first")
+	case errors.Is(err, managers.ErrPhoneAlreadyVerified):
+		common.BadResponse(ctx, "Phone number is already verified")
+	case errors.Is(err, managers.ErrPhoneInUse):
+		common.BadResponse(ctx, "Phone number is already verified on another account")
+	case errors.Is(err, common.ErrInvalidPhoneNumber):
+		common.BadResponse(ctx, "Invalid phone number")
+	default:
+		return false
+	}
+	return true
+}
+
+// Send a passwordless login code by SMS or email
+func (otpHandler *OtpHandler) StartLogin(ctx *gin.Context) {
+	var startInput common.OtpLoginStartInput
+
+	if err := ctx.BindJSON(&startInput); err != nil {
+		common.BadResponse(ctx, "Channel must be sms or email and a destination is required")
+		return
+	}
+
+	err := otpHandler.otpManager.StartLogin(startInput.Channel, startInput.Destination)
 	if err != nil {
-		log.Printf("Failed to send the OTP: %v", err)
+		log.Printf("Failed to send the login OTP: %v", err)
 		common.InternalServerErrorResponse(ctx, "Failed to send OTP")
 		return
 	}
 
-	common.SuccessResponse(ctx, "OTP sent successfully")
+	common.SuccessResponse(ctx, "If an account matches, a login code has been sent")
 }
 
-func (otpHandler *OtpHandler) VerifyOTP(ctx *gin.Context) {
-	phoneNumber := ctx.Query("phone_number")
-	otp := ctx.Query("otp")
+// Log in with a code from StartLogin, responding like the password login
+func (otpHandler *OtpHandler) CompleteLogin(ctx *gin.Context) {
+	var completeInput common.OtpLoginCompleteInput
 
-	if phoneNumber == "" || otp == "" {
-		common.BadResponse(ctx, "Phone Number and OTP are required")
+	if err := ctx.BindJSON(&completeInput); err != nil {
+		common.BadResponse(ctx, "Channel, destination and OTP are required")
 		return
 	}
 
-	err := otpHandler.otpManager.VerifyOTP(phoneNumber, otp)
+	client := clientInfo(ctx, completeInput.DeviceLabel)
+	user, tokens, err := otpHandler.otpManager.CompleteLogin(completeInput.Channel, completeInput.Destination, completeInput.Otp, client)
 	if err != nil {
+		if respondLoginError(ctx, err) {
+			return
+		}
 		if errors.Is(err, managers.ErrInvalidOTP) {
 			common.BadResponse(ctx, "Invalid OTP")
 		} else if errors.Is(err, managers.ErrOTPExpired) {
 			common.BadResponse(ctx, "OTP Expired")
+		} else if errors.Is(err, managers.ErrOTPAttemptsExceeded) {
+			common.BadResponse(ctx, "Too many wrong attempts. Please request a new OTP.")
 		} else {
-			log.Printf("Failed to verify OTP: %v", err)
-			common.InternalServerErrorResponse(ctx, "Failed to verify OTP")
+			log.Printf("Failed to complete OTP login: %v", err)
+			common.InternalServerErrorResponse(ctx, "Failed to login")
 		}
 		return
 	}
 
-	common.SuccessResponse(ctx, "OTP verified successfully")
+	loginResponse(ctx, user, tokens)
 }
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"main/common"
+	"main/managers"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PaymentHandler struct {
+	groupName      string
+	paymentManager managers.PaymentManager
+	orderManager   managers.OrderManager
+}
+
+func NewPaymentHandler(paymentManager managers.PaymentManager, orderManager managers.OrderManager) *PaymentHandler {
+	return &PaymentHandler{
+		"api/payments",
+		paymentManager,
+		orderManager,
+	}
+}
+
+func (paymentHandler *PaymentHandler) RegisterPaymentApis(router *gin.Engine) {
+	paymentGroup := router.Group(paymentHandler.groupName)
+	// Called by the payment provider, authenticated by the webhook signature
+	paymentGroup.POST("webhook", paymentHandler.Webhook)
+
+	userGroup := paymentGroup.Group("", AuthMiddleware())
+	userGroup.POST("orders/:orderid", paymentHandler.Pay)
+
+	adminGroup := paymentGroup.Group("admin", AuthMiddleware(), RequirePermission(common.PermissionOrdersManage))
+	adminGroup.POST(":paymentid/refund", paymentHandler.Refund)
+}
+
+// Pay for one of your orders. The body with a provider payment method is optional.
+func (paymentHandler *PaymentHandler) Pay(ctx *gin.Context) {
+	orderID, err := strconv.Atoi(ctx.Param("orderid"))
+	if err != nil {
+		common.BadResponse(ctx, "Invalid Order ID")
+		return
+	}
+
+	order, err := paymentHandler.orderManager.Get(uint(orderID))
+	if err != nil {
+		if errors.Is(err, managers.ErrOrderNotFound) {
+			common.NotFoundResponse(ctx, "Order not found")
+			return
+		}
+		common.InternalServerErrorResponse(ctx, "Failed to get order")
+		return
+	}
+	if order.UserID != currentUser(ctx).Id {
+		common.ForbiddenResponse(ctx, "Order belongs to another user")
+		return
+	}
+
+	paymentData := common.NewPaymentInput()
+	if err := ctx.ShouldBindJSON(paymentData); err != nil && !errors.Is(err, io.EOF) {
+		common.BadResponse(ctx, "Failed to bind payment data")
+		return
+	}
+
+	payment, err := paymentHandler.paymentManager.Pay(order.Id, paymentData)
+	if err != nil {
+		switch {
+		case errors.Is(err, managers.ErrOrderNotPayable):
+			common.ConflictResponse(ctx, "Order is not awaiting payment")
+		case errors.Is(err, managers.ErrPaymentInProgress):
+			common.ConflictResponse(ctx, "Order already has a payment in progress")
+		case errors.Is(err, managers.ErrPaymentDeclined):
+			common.BadResponse(ctx, "Payment was declined")
+		case errors.Is(err, managers.ErrUnknownPaymentMethod):
+			common.BadResponse(ctx, "Unknown payment method")
+		case errors.Is(err, managers.ErrPaymentTimeout):
+			common.InternalServerErrorResponse(ctx, "Payment provider did not respond, check the order before paying again")
+		default:
+			log.Printf("Payment for order %d failed: %v", order.Id, err)
+			common.InternalServerErrorResponse(ctx, "Failed to take the payment")
+		}
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Payment "+payment.Status, payment)
+}
+
+func (paymentHandler *PaymentHandler) Refund(ctx *gin.Context) {
+	paymentID, err := strconv.Atoi(ctx.Param("paymentid"))
+	if err != nil {
+		common.BadResponse(ctx, "Invalid Payment ID")
+		return
+	}
+
+	payment, err := paymentHandler.paymentManager.Refund(uint(paymentID), currentUser(ctx).Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, managers.ErrPaymentNotFound):
+			common.NotFoundResponse(ctx, "Payment not found")
+		case errors.Is(err, managers.ErrPaymentNotRefundable):
+			common.ConflictResponse(ctx, "Only captured payments can be refunded")
+		case errors.Is(err, managers.ErrOrderNotRefundable):
+			common.ConflictResponse(ctx, "Order can't be refunded until it is delivered or cancelled")
+		default:
+			log.Printf("Refund of payment %d failed: %v", paymentID, err)
+			common.InternalServerErrorResponse(ctx, "Failed to refund the payment")
+		}
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Payment refunded successfully", payment)
+}
+
+// Payment provider webhooks. Anything but a 2xx makes the provider deliver the event again.
+func (paymentHandler *PaymentHandler) Webhook(ctx *gin.Context) {
+	payload, err := io.ReadAll(io.LimitReader(ctx.Request.Body, 1<<20))
+	if err != nil {
+		common.BadResponse(ctx, "Failed to read webhook")
+		return
+	}
+
+	if err := paymentHandler.paymentManager.HandleWebhook(payload, ctx.Request.Header); err != nil {
+		if errors.Is(err, managers.ErrInvalidWebhookSignature) {
+			common.BadResponse(ctx, "Invalid webhook signature")
+			return
+		}
+		log.Printf("Payment webhook failed: %v", err)
+		common.InternalServerErrorResponse(ctx, "Failed to process webhook")
+		return
+	}
+
+	common.SuccessResponse(ctx, "Webhook processed")
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"main/common"
 	"main/managers"
 
@@ -21,12 +22,14 @@ func NewProductHandler(productManager managers.ProductManager) *ProductHandler {
 
 func (productHandler *ProductHandler) RegisterUserApis(router *gin.Engine) {
 	productGroup := router.Group(productHandler.groupName)
-	productGroup.POST("", productHandler.Create)
 	productGroup.GET("", productHandler.List)
 	productGroup.GET(":productid", productHandler.Get)
-	productGroup.PATCH(":productid", productHandler.Update)
-	productGroup.DELETE(":productid", productHandler.Delete)
 	productGroup.GET("search/", productHandler.Search)
+
+	adminGroup := productGroup.Group("", AuthMiddleware(), RequirePermission(common.PermissionCatalogWrite))
+	adminGroup.POST("", productHandler.Create)
+	adminGroup.PATCH(":productid", productHandler.Update)
+	adminGroup.DELETE(":productid", productHandler.Delete)
 }
 
 func (productHandler *ProductHandler) Create(ctx *gin.Context) {
@@ -41,6 +44,13 @@ func (productHandler *ProductHandler) Create(ctx *gin.Context) {
 
 	newProduct, err := productHandler.productManager.Create(productData)
 	if err != nil {
+		if errors.Is(err, managers.ErrInvalidPrice) {
+			common.BadResponse(ctx, "Price must not be negative")
+			return
+		}
+		if respondTaxFieldError(ctx, err) {
+			return
+		}
 		common.InternalServerErrorResponse(ctx, "Failed to create product")
 		return
 	}
@@ -49,11 +59,22 @@ func (productHandler *ProductHandler) Create(ctx *gin.Context) {
 
 }
 
-// List all products
+// List all products, sorted with ?sort=price_asc|price_desc|newest|name and filtered
+// with ?minPrice=, ?maxPrice= and ?currency=
 func (productHandler *ProductHandler) List(ctx *gin.Context) {
-	products, err := productHandler.productManager.List()
+	query := common.NewProductListQuery()
+	if err := ctx.ShouldBindQuery(query); err != nil {
+		common.BadResponse(ctx, "Invalid product query")
+		return
+	}
+
+	products, err := productHandler.productManager.List(query)
 
 	if err != nil {
+		if errors.Is(err, managers.ErrInvalidProductQuery) {
+			common.BadResponse(ctx, "Sort must be price_asc, price_desc, newest or name, and prices decimal amounts")
+			return
+		}
 		common.InternalServerErrorResponse(ctx, "Failed to list products")
 		return
 	}
@@ -94,12 +115,19 @@ func (productHandler *ProductHandler) Update(ctx *gin.Context) {
 
 	productUpdateData := common.NewProductUpdationInput()
 	if err := ctx.BindJSON(&productUpdateData); err != nil {
-		common.InternalServerErrorResponse(ctx, "Failed to update Product")
+		common.BadResponse(ctx, "Failed to bind data for product")
 		return
 	}
 
 	updatedProduct, err := productHandler.productManager.Update(productID, productUpdateData)
 	if err != nil {
+		if errors.Is(err, managers.ErrInvalidPrice) {
+			common.BadResponse(ctx, "Price must not be negative")
+			return
+		}
+		if respondTaxFieldError(ctx, err) {
+			return
+		}
 		common.InternalServerErrorResponse(ctx, "Failed to update product")
 		return
 	}
@@ -141,3 +169,13 @@ func (productHandler *ProductHandler) Search(ctx *gin.Context) {
 
 	common.SuccessResponseWithData(ctx, "Products retrieved Successfully", products)
 }
+
+// Respond to a tax rate or HSN code the tax rules refuse, shared by the product and
+// category routes, reporting whether err was one
+func respondTaxFieldError(ctx *gin.Context, err error) bool {
+	if errors.Is(err, managers.ErrInvalidTaxRate) || errors.Is(err, managers.ErrInvalidHSNCode) {
+		common.BadResponse(ctx, err.Error())
+		return true
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"errors"
+	"main/common"
+	"main/managers"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PromotionHandler struct {
+	groupName        string
+	promotionManager managers.PromotionManager
+}
+
+func NewPromotionHandler(promotionManager managers.PromotionManager) *PromotionHandler {
+	return &PromotionHandler{
+		"api/promotions",
+		promotionManager,
+	}
+}
+
+func (promotionHandler *PromotionHandler) RegisterPromotionApis(router *gin.Engine) {
+	promotionGroup := router.Group(promotionHandler.groupName, AuthMiddleware(), RequirePermission(common.PermissionPromotionsManage))
+	promotionGroup.POST("coupons", promotionHandler.CreateCoupon)
+	promotionGroup.GET("coupons", promotionHandler.ListCoupons)
+	promotionGroup.GET("coupons/:couponid", promotionHandler.GetCoupon)
+	promotionGroup.PATCH("coupons/:couponid", promotionHandler.UpdateCoupon)
+	promotionGroup.POST("automatic", promotionHandler.CreatePromotion)
+	promotionGroup.GET("automatic", promotionHandler.ListPromotions)
+	promotionGroup.GET("automatic/:promotionid", promotionHandler.GetPromotion)
+	promotionGroup.PATCH("automatic/:promotionid", promotionHandler.UpdatePromotion)
+}
+
+func (promotionHandler *PromotionHandler) CreateCoupon(ctx *gin.Context) {
+	couponData := common.NewCouponCreationInput()
+	if err := ctx.BindJSON(couponData); err != nil {
+		common.BadResponse(ctx, "Failed to bind coupon data")
+		return
+	}
+
+	coupon, err := promotionHandler.promotionManager.CreateCoupon(couponData)
+	if err != nil {
+		respondPromotionError(ctx, err, "Failed to create coupon")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Coupon created successfully", coupon)
+}
+
+func (promotionHandler *PromotionHandler) ListCoupons(ctx *gin.Context) {
+	coupons, err := promotionHandler.promotionManager.ListCoupons()
+	if err != nil {
+		common.InternalServerErrorResponse(ctx, "Failed to list coupons")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Coupons retrieved successfully", coupons)
+}
+
+func (promotionHandler *PromotionHandler) GetCoupon(ctx *gin.Context) {
+	couponID, err := strconv.Atoi(ctx.Param("couponid"))
+	if err != nil {
+		common.BadResponse(ctx, "Invalid Coupon ID")
+		return
+	}
+
+	coupon, err := promotionHandler.promotionManager.GetCoupon(uint(couponID))
+	if err != nil {
+		respondPromotionError(ctx, err, "Failed to get coupon")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Coupon retrieved successfully", coupon)
+}
+
+func (promotionHandler *PromotionHandler) UpdateCoupon(ctx *gin.Context) {
+	couponID, err := strconv.Atoi(ctx.Param("couponid"))
+	if err != nil {
+		common.BadResponse(ctx, "Invalid Coupon ID")
+		return
+	}
+
+	couponData := common.NewCouponUpdationInput()
+	if err := ctx.BindJSON(couponData); err != nil {
+		common.BadResponse(ctx, "Failed to bind coupon data")
+		return
+	}
+
+	coupon, err := promotionHandler.promotionManager.UpdateCoupon(uint(couponID), couponData)
+	if err != nil {
+		respondPromotionError(ctx, err, "Failed to update coupon")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Coupon updated successfully", coupon)
+}
+
+func (promotionHandler *PromotionHandler) CreatePromotion(ctx *gin.Context) {
+	promotionData := common.NewPromotionCreationInput()
+	if err := ctx.BindJSON(promotionData); err != nil {
+		common.BadResponse(ctx, "Failed to bind promotion data")
+		return
+	}
+
+	promotion, err := promotionHandler.promotionManager.CreatePromotion(promotionData)
+	if err != nil {
+		respondPromotionError(ctx, err, "Failed to create promotion")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Promotion created successfully", promotion)
+}
+
+func (promotionHandler *PromotionHandler) ListPromotions(ctx *gin.Context) {
+	promotions, err := promotionHandler.promotionManager.ListPromotions()
+	if err != nil {
+		common.InternalServerErrorResponse(ctx, "Failed to list promotions")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Promotions retrieved successfully", promotions)
+}
+
+func (promotionHandler *PromotionHandler) GetPromotion(ctx *gin.Context) {
+	promotionID, err := strconv.Atoi(ctx.Param("promotionid"))
+	if err != nil {
+		common.BadResponse(ctx, "Invalid Promotion ID")
+		return
+	}
+
+	promotion, err := promotionHandler.promotionManager.GetPromotion(uint(promotionID))
+	if err != nil {
+		respondPromotionError(ctx, err, "Failed to get promotion")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Promotion retrieved successfully", promotion)
+}
+
+func (promotionHandler *PromotionHandler) UpdatePromotion(ctx *gin.Context) {
+	promotionID, err := strconv.Atoi(ctx.Param("promotionid"))
+	if err != nil {
+		common.BadResponse(ctx, "Invalid Promotion ID")
+		return
+	}
+
+	promotionData := common.NewPromotionUpdationInput()
+	if err := ctx.BindJSON(promotionData); err != nil {
+		common.BadResponse(ctx, "Failed to bind promotion data")
+		return
+	}
+
+	promotion, err := promotionHandler.promotionManager.UpdatePromotion(uint(promotionID), promotionData)
+	if err != nil {
+		respondPromotionError(ctx, err, "Failed to update promotion")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Promotion updated successfully", promotion)
+}
+
+func respondPromotionError(ctx *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, managers.ErrCouponNotFound):
+		common.NotFoundResponse(ctx, "Coupon not found")
+	case errors.Is(err, managers.ErrPromotionNotFound):
+		common.NotFoundResponse(ctx, "Promotion not found")
+	case errors.Is(err, managers.ErrCouponCodeExists):
+		common.ConflictResponse(ctx, "A coupon with this code already exists")
+	case errors.Is(err, managers.ErrInvalidCoupon), errors.Is(err, managers.ErrInvalidPromotion), errors.Is(err, managers.ErrUnknownPromoScope):
+		common.BadResponse(ctx, err.Error())
+	default:
+		common.InternalServerErrorResponse(ctx, fallback)
+	}
+}
+
+// Respond to a coupon the cart can't use, shared by the cart and checkout routes,
+// reporting whether err was one
+func respondCouponError(ctx *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, managers.ErrCouponNotFound):
+		common.NotFoundResponse(ctx, "Coupon not found")
+	case errors.Is(err, managers.ErrCouponNotActive),
+		errors.Is(err, managers.ErrCouponUsedUp),
+		errors.Is(err, managers.ErrCouponUserLimit),
+		errors.Is(err, managers.ErrCouponNotApplicable),
+		errors.Is(err, managers.ErrCouponMinimum):
+		common.BadResponse(ctx, err.Error())
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"errors"
+	"main/common"
+	"main/managers"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RoleHandler struct {
+	groupName   string
+	roleManager managers.RoleManager
+}
+
+func NewRoleHandler(roleManager managers.RoleManager) *RoleHandler {
+	return &RoleHandler{
+		"api/roles",
+		roleManager,
+	}
+}
+
+func (roleHandler *RoleHandler) RegisterRoleApis(router *gin.Engine) {
+	roleGroup := router.Group(roleHandler.groupName, AuthMiddleware(), RequirePermission(common.PermissionRolesManage))
+	roleGroup.GET("", roleHandler.List)
+	roleGroup.POST("", roleHandler.Create)
+	roleGroup.GET("permissions", roleHandler.ListPermissions)
+	roleGroup.GET(":roleid", roleHandler.Get)
+	roleGroup.PATCH(":roleid", roleHandler.Update)
+	roleGroup.DELETE(":roleid", roleHandler.Delete)
+	roleGroup.PUT("users/:userid", roleHandler.Assign)
+}
+
+func (roleHandler *RoleHandler) List(ctx *gin.Context) {
+	roles, err := roleHandler.roleManager.List()
+	if err != nil {
+		common.InternalServerErrorResponse(ctx, "Failed to list roles")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Roles retrieved successfully", roles)
+}
+
+func (roleHandler *RoleHandler) Create(ctx *gin.Context) {
+	roleData := common.NewRoleCreationInput()
+	if err := ctx.BindJSON(&roleData); err != nil {
+		common.BadResponse(ctx, "Failed to bind role data")
+		return
+	}
+
+	newRole, err := roleHandler.roleManager.Create(roleData)
+	if err != nil {
+		if errors.Is(err, managers.ErrRoleAlreadyExists) {
+			common.BadResponse(ctx, "Role already exists")
+			return
+		}
+		if errors.Is(err, managers.ErrUnknownPermission) {
+			common.BadResponse(ctx, "Unknown permission")
+			return
+		}
+		common.InternalServerErrorResponse(ctx, "Failed to create role")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Role created successfully", newRole)
+}
+
+func (roleHandler *RoleHandler) Get(ctx *gin.Context) {
+	roleID := ctx.Param("roleid")
+
+	role, err := roleHandler.roleManager.Get(roleID)
+	if err != nil {
+		if errors.Is(err, managers.ErrRoleNotFound) {
+			common.NotFoundResponse(ctx, "Role not found")
+			return
+		}
+		common.InternalServerErrorResponse(ctx, "Failed to get role")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Role retrieved successfully", role)
+}
+
+func (roleHandler *RoleHandler) Update(ctx *gin.Context) {
+	roleID := ctx.Param("roleid")
+
+	roleData := common.NewRoleUpdationInput()
+	if err := ctx.BindJSON(&roleData); err != nil {
+		common.BadResponse(ctx, "Failed to bind role data")
+		return
+	}
+
+	role, err := roleHandler.roleManager.Update(roleID, roleData)
+	if err != nil {
+		if errors.Is(err, managers.ErrRoleNotFound) {
+			common.NotFoundResponse(ctx, "Role not found")
+			return
+		}
+		if errors.Is(err, managers.ErrUnknownPermission) {
+			common.BadResponse(ctx, "Unknown permission")
+			return
+		}
+		common.InternalServerErrorResponse(ctx, "Failed to update role")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Role updated successfully", role)
+}
+
+func (roleHandler *RoleHandler) Delete(ctx *gin.Context) {
+	roleID := ctx.Param("roleid")
+
+	err := roleHandler.roleManager.Delete(roleID)
+	if err != nil {
+		if errors.Is(err, managers.ErrRoleNotFound) {
+			common.NotFoundResponse(ctx, "Role not found")
+			return
+		}
+		if errors.Is(err, managers.ErrSystemRole) {
+			common.BadResponse(ctx, "Built-in roles cannot be deleted")
+			return
+		}
+		common.InternalServerErrorResponse(ctx, "Failed to delete role")
+		return
+	}
+
+	common.SuccessResponse(ctx, "Role deleted successfully")
+}
+
+func (roleHandler *RoleHandler) ListPermissions(ctx *gin.Context) {
+	permissions, err := roleHandler.roleManager.ListPermissions()
+	if err != nil {
+		common.InternalServerErrorResponse(ctx, "Failed to list permissions")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Permissions retrieved successfully", permissions)
+}
+
+// Assign a role to a user
+func (roleHandler *RoleHandler) Assign(ctx *gin.Context) {
+	userID := ctx.Param("userid")
+
+	assignment := common.NewRoleAssignmentInput()
+	if err := ctx.BindJSON(&assignment); err != nil {
+		common.BadResponse(ctx, "Failed to bind role assignment")
+		return
+	}
+
+	user, err := roleHandler.roleManager.AssignRole(userID, assignment.Role)
+	if err != nil {
+		if errors.Is(err, managers.ErrRoleNotFound) {
+			common.BadResponse(ctx, "Role not found")
+			return
+		}
+		if errors.Is(err, managers.ErrUserNotFound) {
+			common.NotFoundResponse(ctx, "User not found")
+			return
+		}
+		common.InternalServerErrorResponse(ctx, "Failed to assign role")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Role assigned successfully", gin.H{
+		"user_id": user.Id,
+		"email":   user.Email,
+		"role":    user.Role.Name,
+	})
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"main/common"
+	"main/managers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Second login step for accounts with 2FA, exchanges the challenge token for the session tokens
+func (userHandler *UserHandler) CompleteTwoFactorLogin(ctx *gin.Context) {
+	var loginInput common.TwoFactorLoginInput
+
+	if err := ctx.BindJSON(&loginInput); err != nil {
+		common.BadResponse(ctx, "Invalid two-factor login data")
+		return
+	}
+
+	client := clientInfo(ctx, loginInput.DeviceLabel)
+	user, tokens, err := userHandler.twoFactorManager.CompleteLogin(loginInput.ChallengeToken, loginInput.Code, client)
+	if err != nil {
+		if respondLoginError(ctx, err) {
+			return
+		}
+		if errors.Is(err, managers.ErrInvalidChallenge) {
+			common.UnauthorizedResponse(ctx, "Login challenge is invalid or expired. Please log in again.")
+			return
+		}
+		if errors.Is(err, managers.ErrInvalidTwoFactorCode) {
+			common.BadResponse(ctx, "Invalid two-factor code")
+			return
+		}
+		common.InternalServerErrorResponse(ctx, "Failed to login")
+		return
+	}
+
+	loginResponse(ctx, user, tokens)
+}
+
+// Start enrolling an authenticator app, returns the secret as an otpauth:// URI and a QR code
+func (userHandler *UserHandler) EnrollTwoFactor(ctx *gin.Context) {
+	enrollment, err := userHandler.twoFactorManager.Enroll(currentUser(ctx))
+	if err != nil {
+		if errors.Is(err, managers.ErrTwoFactorAlreadyEnabled) {
+			common.BadResponse(ctx, "Two-factor authentication is already enabled")
+			return
+		}
+		common.InternalServerErrorResponse(ctx, "Failed to start two-factor enrollment")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Scan the QR code and confirm with a code from your app", enrollment)
+}
+
+func (userHandler *UserHandler) ConfirmTwoFactor(ctx *gin.Context) {
+	var codeInput common.TwoFactorCodeInput
+
+	if err := ctx.BindJSON(&codeInput); err != nil {
+		common.BadResponse(ctx, "Invalid two-factor data")
+		return
+	}
+
+	recoveryCodes, err := userHandler.twoFactorManager.Confirm(currentUser(ctx), codeInput.Code)
+	if err != nil {
+		if !respondTwoFactorError(ctx, err) {
+			common.InternalServerErrorResponse(ctx, "Failed to enable two-factor authentication")
+		}
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Two-factor authentication enabled. Store these recovery codes somewhere safe, they are shown only once.", gin.H{
+		"recoveryCodes": recoveryCodes,
+	})
+}
+
+func (userHandler *UserHandler) DisableTwoFactor(ctx *gin.Context) {
+	var disableInput common.TwoFactorDisableInput
+
+	if err := ctx.BindJSON(&disableInput); err != nil {
+		common.BadResponse(ctx, "Invalid two-factor data")
+		return
+	}
+
+	err := userHandler.twoFactorManager.Disable(currentUser(ctx), disableInput.Password, disableInput.Code)
+	if err != nil {
+		if errors.Is(err, managers.ErrInvalidCredentials) {
+			common.BadResponse(ctx, "Invalid password")
+			return
+		}
+		if !respondTwoFactorError(ctx, err) {
+			common.InternalServerErrorResponse(ctx, "Failed to disable two-factor authentication")
+		}
+		return
+	}
+
+	common.SuccessResponse(ctx, "Two-factor authentication disabled")
+}
+
+func (userHandler *UserHandler) RegenerateRecoveryCodes(ctx *gin.Context) {
+	var codeInput common.TwoFactorCodeInput
+
+	if err := ctx.BindJSON(&codeInput); err != nil {
+		common.BadResponse(ctx, "Invalid two-factor data")
+		return
+	}
+
+	recoveryCodes, err := userHandler.twoFactorManager.RegenerateRecoveryCodes(currentUser(ctx), codeInput.Code)
+	if err != nil {
+		if !respondTwoFactorError(ctx, err) {
+			common.InternalServerErrorResponse(ctx, "Failed to regenerate recovery codes")
+		}
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "New recovery codes generated, the previous ones no longer work", gin.H{
+		"recoveryCodes": recoveryCodes,
+	})
+}
+
+// Map the errors shared by the 2FA management endpoints, reporting whether a response was written
+func respondTwoFactorError(ctx *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, managers.ErrInvalidTwoFactorCode):
+		common.BadResponse(ctx, "Invalid two-factor code")
+	case errors.Is(err, managers.ErrTwoFactorAlreadyEnabled):
+		common.BadResponse(ctx, "Two-factor authentication is already enabled")
+	case errors.Is(err, managers.ErrTwoFactorNotEnabled):
+		common.BadResponse(ctx, "Two-factor authentication is not enabled")
+	case errors.Is(err, managers.ErrTwoFactorNotEnrolled):
+		common.BadResponse(ctx, "Start two-factor enrollment first")
+	default:
+		return false
+	}
+	return true
+}
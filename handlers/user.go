@@ -6,67 +6,28 @@ import (
 	"log"
 	"main/common"
 	"main/managers"
+	"main/models"
 	"net/http"
-	"os"
-	"strings"
-	"sync"
-
+	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
 type UserHandler struct {
-	groupName   string
-	userManager managers.UserManager
+	groupName        string
+	userManager      managers.UserManager
+	tokenManager     managers.TokenManager
+	sessionManager   managers.SessionManager
+	twoFactorManager managers.TwoFactorManager
 }
 
-func NewUserHandlerFrom(userManager managers.UserManager) *UserHandler {
+func NewUserHandlerFrom(userManager managers.UserManager, tokenManager managers.TokenManager, sessionManager managers.SessionManager, twoFactorManager managers.TwoFactorManager) *UserHandler {
 	return &UserHandler{
 		"api/user",
 		userManager,
-	}
-}
-
-var tokenBlacklist sync.Map
-
-// Middleware to extract user email from JWT
-func AuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Authorization header required"})
-			return
-
-		}
-
-		tokenString := strings.Replace(authHeader, "Bearer", "", 1)
-		tokenString = strings.TrimSpace(tokenString)
-		log.Println("Token String:", tokenString)
-
-		if _, exists := tokenBlacklist.Load(tokenString); exists {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Token has been logged out"})
-			return
-		}
-
-		token, err := jwt.ParseWithClaims(tokenString, &common.JWTClaim{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET_KEY")), nil
-		})
-
-		if err != nil {
-			log.Println("Token Parsing Error:", err) // Log the error
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid Token"})
-			return
-		}
-
-		claims, ok := token.Claims.(*common.JWTClaim)
-		if !ok || !token.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid token class"})
-			return
-		}
-
-		c.Set("email", claims.Email)
-		c.Next()
+		tokenManager,
+		sessionManager,
+		twoFactorManager,
 	}
 }
 
@@ -74,16 +35,33 @@ func AuthMiddleware() gin.HandlerFunc {
 func (userHandler *UserHandler) RegisterUserApis(router *gin.Engine) {
 	userGroup := router.Group(userHandler.groupName)
 	userGroup.POST("/signup", userHandler.SignUp)
-	userGroup.POST("", userHandler.Create)
-	userGroup.GET("", userHandler.List)
-	userGroup.GET(":userid", userHandler.Get)
-	userGroup.DELETE(":userid", userHandler.Delete)
-	userGroup.PATCH(":userid", userHandler.Update)
 	userGroup.POST("/login", userHandler.Login)
+	userGroup.POST("/login/2fa", userHandler.CompleteTwoFactorLogin)
 	userGroup.POST("/logout", userHandler.Logout)
+	userGroup.POST("/token/refresh", userHandler.RefreshToken)
+	userGroup.POST("/password/forgot", userHandler.ForgotPassword)
+	userGroup.POST("/password/reset", userHandler.ResetPassword)
 	userGroup.GET("/profile", AuthMiddleware(), userHandler.ViewProfile)
+	userGroup.PATCH("/profile", AuthMiddleware(), userHandler.UpdateProfile)
 	userGroup.GET("/verify", userHandler.VerifyEmail)
-
+	userGroup.POST("/verify/resend", userHandler.ResendVerification)
+	userGroup.GET("/email/confirm", userHandler.ConfirmEmailChange)
+	userGroup.GET("/sessions", AuthMiddleware(), userHandler.ListSessions)
+	userGroup.DELETE("/sessions/:sessionid", AuthMiddleware(), userHandler.RevokeSession)
+	userGroup.POST("/sessions/logout-others", AuthMiddleware(), userHandler.RevokeOtherSessions)
+	userGroup.POST("/2fa/enroll", AuthMiddleware(), userHandler.EnrollTwoFactor)
+	userGroup.POST("/2fa/confirm", AuthMiddleware(), userHandler.ConfirmTwoFactor)
+	userGroup.POST("/2fa/disable", AuthMiddleware(), userHandler.DisableTwoFactor)
+	userGroup.POST("/2fa/recovery-codes", AuthMiddleware(), userHandler.RegenerateRecoveryCodes)
+
+	adminGroup := userGroup.Group("", AuthMiddleware(), RequirePermission(common.PermissionUsersManage))
+	adminGroup.POST("", userHandler.Create)
+	adminGroup.GET("", userHandler.List)
+	adminGroup.GET(":userid", userHandler.Get)
+	adminGroup.DELETE(":userid", userHandler.Delete)
+	adminGroup.PATCH(":userid", userHandler.Update)
+	adminGroup.GET(":userid/sessions", userHandler.ListUserSessions)
+	adminGroup.POST(":userid/unlock", userHandler.Unlock)
 }
 
 func (userHandler *UserHandler) SignUp(ctx *gin.Context) {
@@ -94,13 +72,18 @@ func (userHandler *UserHandler) SignUp(ctx *gin.Context) {
 		return
 	}
 
-	newUser, verificationToken, err := userHandler.userManager.Create(userData)
+	// The verification token only goes out by email, so the address has to be reached to verify it
+	newUser, _, err := userHandler.userManager.Create(userData)
 
 	if err != nil {
 		if errors.Is(err, managers.ErrEmailAlreadyExists) {
 			common.BadResponse(ctx, "Email Already Exists")
 			return
 		}
+		if errors.Is(err, common.ErrInvalidPhoneNumber) {
+			common.BadResponse(ctx, "Invalid phone number")
+			return
+		}
 
 		common.InternalServerErrorResponse(ctx, "Failed to create a message")
 		return
@@ -113,17 +96,10 @@ func (userHandler *UserHandler) SignUp(ctx *gin.Context) {
 	// 	return
 	// }
 
-	if err := userHandler.userManager.SenderVerificationEmail(newUser.Email, verificationToken); err != nil {
-		log.Printf("Error sending verification email: %v", err)
-		common.InternalServerErrorResponse(ctx, "Failed to send verification email")
-		return
-	}
-
 	common.SuccessResponseWithData(ctx, "Signup Successfull.Please check you email for verification", gin.H{
 		"user_id":  newUser.Id,
 		"email":    newUser.Email,
 		"username": newUser.FirstName,
-		"token":    verificationToken,
 		// "address":  newUser.Address,
 		// "image":    newUser.Image,
 	})
@@ -150,6 +126,10 @@ func (userHandler *UserHandler) Create(ctx *gin.Context) {
 			common.BadResponse(ctx, "Email already exists")
 			return
 		}
+		if errors.Is(err, common.ErrInvalidPhoneNumber) {
+			common.BadResponse(ctx, "Invalid phone number")
+			return
+		}
 		common.BadResponse(ctx, "failed to create a user")
 	}
 
@@ -213,6 +193,9 @@ func (userHandler *UserHandler) Update(ctx *gin.Context) {
 	user, err := userHandler.userManager.Update(userId, userUpdate)
 
 	if err != nil {
+		if respondUpdateError(ctx, err) {
+			return
+		}
 		common.BadResponse(ctx, "failed to Update a user")
 		return
 	}
@@ -249,27 +232,93 @@ func (userHandler *UserHandler) Login(ctx *gin.Context) {
 		return
 	}
 
-	user, token, err := userHandler.userManager.Login(loginInput.Email, loginInput.Password)
+	client := clientInfo(ctx, loginInput.DeviceLabel)
+	user, tokens, err := userHandler.userManager.Login(loginInput.Email, loginInput.Password, client)
 
 	if err != nil {
-		fmt.Println(err.Error())
-		common.BadResponse(ctx, "Invalid Credentials")
+		if respondLoginError(ctx, err) {
+			return
+		}
+		if errors.Is(err, managers.ErrEmailNotVerified) {
+			common.BadResponse(ctx, "Email is not verified. Please check your inbox.")
+			return
+		}
+		if errors.Is(err, managers.ErrInvalidCredentials) {
+			common.BadResponse(ctx, "Invalid Credentials")
+			return
+		}
+		common.InternalServerErrorResponse(ctx, "Failed to login")
 		return
 	}
 
-	if !user.IsVerified {
-		common.BadResponse(ctx, "Email is not verified. Please check your inbox.")
+	loginResponse(ctx, user, tokens)
+}
+
+// The response of every successful login, whichever way the user authenticated
+func loginResponse(ctx *gin.Context, user *models.User, tokens *common.TokenPair) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":       "Login successful",
+		"user_id":       user.Id,
+		"email":         user.Email,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"username":      user.FirstName,
+	})
+}
+
+// Map the login outcomes shared by every login flow, reporting whether a response was written
+func respondLoginError(ctx *gin.Context, err error) bool {
+	var throttled *managers.LoginThrottledError
+	if errors.As(err, &throttled) {
+		common.TooManyRequestsResponse(ctx, "Too many failed login attempts. Please try again later.", throttled.RetryAfter)
+		return true
+	}
+
+	var challenge *managers.TwoFactorChallengeError
+	if errors.As(err, &challenge) {
+		ctx.JSON(http.StatusOK, gin.H{
+			"message":             "Two-factor authentication required",
+			"two_factor_required": true,
+			"challenge_token":     challenge.ChallengeToken,
+			"expires_in":          challenge.ExpiresIn,
+		})
+		return true
+	}
+	return false
+}
+
+// Rotate a refresh token into a new access and refresh token pair
+func (userHandler *UserHandler) RefreshToken(ctx *gin.Context) {
+	var refreshInput common.RefreshTokenInput
+
+	if err := ctx.BindJSON(&refreshInput); err != nil {
+		common.BadResponse(ctx, "Invalid refresh data")
 		return
 	}
 
-	fmt.Println("Login: Token from login API:", token)
+	user, tokens, err := userHandler.tokenManager.Refresh(refreshInput.RefreshToken, clientInfo(ctx, ""))
+	if err != nil {
+		switch {
+		case errors.Is(err, managers.ErrRefreshTokenReused):
+			common.UnauthorizedResponse(ctx, "Refresh token was already used. Please log in again.")
+		case errors.Is(err, managers.ErrRefreshTokenExpired):
+			common.UnauthorizedResponse(ctx, "Refresh token expired. Please log in again.")
+		case errors.Is(err, managers.ErrInvalidRefreshToken):
+			common.UnauthorizedResponse(ctx, "Invalid refresh token")
+		default:
+			log.Printf("Error refreshing token: %v", err)
+			common.InternalServerErrorResponse(ctx, "Failed to refresh token")
+		}
+		return
+	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message":  "Login successful",
-		"user_id":  user.Id,
-		"email":    user.Email,
-		"token":    token,
-		"username": user.FirstName,
+		"message":       "Token refreshed",
+		"user_id":       user.Id,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 	})
 }
 
@@ -282,14 +331,21 @@ func (userHandler *UserHandler) Logout(ctx *gin.Context) {
 		return
 	}
 
-	tokenBlacklist.Store(logoutInput.Token, true)
+	if logoutInput.RefreshToken != "" {
+		if err := userHandler.tokenManager.RevokeRefreshToken(logoutInput.RefreshToken); err != nil && !errors.Is(err, managers.ErrInvalidRefreshToken) {
+			log.Printf("Error revoking refresh token: %v", err)
+		}
+	}
 
-	fmt.Println("Logout :Token received by Logout API:", logoutInput.Token)
 	err := userHandler.userManager.Logout(logoutInput.Token)
 
 	if err != nil {
 		fmt.Println(err.Error())
-		common.BadResponse(ctx, "Logout Failed")
+		if errors.Is(err, managers.ErrInvalidToken) {
+			common.BadResponse(ctx, "Invalid token")
+			return
+		}
+		common.InternalServerErrorResponse(ctx, "Logout Failed")
 		return
 	}
 
@@ -297,6 +353,48 @@ func (userHandler *UserHandler) Logout(ctx *gin.Context) {
 
 }
 
+// Request a password reset email
+func (userHandler *UserHandler) ForgotPassword(ctx *gin.Context) {
+	var forgotInput common.ForgotPasswordInput
+
+	if err := ctx.BindJSON(&forgotInput); err != nil {
+		common.BadResponse(ctx, "Invalid email")
+		return
+	}
+
+	// Sent in the background so the response time doesn't reveal whether the account exists
+	go func(email string) {
+		if err := userHandler.userManager.ForgotPassword(email); err != nil {
+			log.Printf("Error sending password reset email: %v", err)
+		}
+	}(forgotInput.Email)
+
+	common.SuccessResponse(ctx, "If an account exists for this email, a password reset link has been sent")
+}
+
+// Set a new password using the token from the reset email
+func (userHandler *UserHandler) ResetPassword(ctx *gin.Context) {
+	var resetInput common.ResetPasswordInput
+
+	if err := ctx.BindJSON(&resetInput); err != nil {
+		common.BadResponse(ctx, "Token and a password of at least 8 characters are required")
+		return
+	}
+
+	err := userHandler.userManager.ResetPassword(resetInput.Token, resetInput.Password)
+	if err != nil {
+		if errors.Is(err, managers.ErrInvalidResetToken) {
+			common.BadResponse(ctx, "Invalid or expired reset token")
+			return
+		}
+		log.Printf("Error resetting password: %v", err)
+		common.InternalServerErrorResponse(ctx, "Failed to reset password")
+		return
+	}
+
+	common.SuccessResponse(ctx, "Password has been reset. Please log in again.")
+}
+
 // View Profile function
 func (userHandler *UserHandler) ViewProfile(ctx *gin.Context) {
 
@@ -312,6 +410,53 @@ func (userHandler *UserHandler) ViewProfile(ctx *gin.Context) {
 	common.SuccessResponseWithData(ctx, "Profile retrieved successfully", profile)
 }
 
+// Update the profile of the logged in user
+func (userHandler *UserHandler) UpdateProfile(ctx *gin.Context) {
+
+	userUpdate := common.NewUserUpdationInput()
+	if err := ctx.BindJSON(&userUpdate); err != nil {
+		common.BadResponse(ctx, "Failed to bind data")
+		return
+	}
+
+	user, err := userHandler.userManager.UpdateProfile(strconv.Itoa(int(currentUser(ctx).Id)), userUpdate)
+	if err != nil {
+		if respondUpdateError(ctx, err) {
+			return
+		}
+		common.BadResponse(ctx, "Failed to update profile")
+		return
+	}
+
+	profile, err := userHandler.userManager.ViewProfile(user.Email)
+	if err != nil {
+		common.InternalServerErrorResponse(ctx, "Failed to fetch profile")
+		return
+	}
+
+	message := "Profile updated successfully"
+	if userUpdate.Email != "" && profile.PendingEmail == userUpdate.Email {
+		message = "Profile updated. Please confirm your new email address with the link sent to it."
+	}
+	common.SuccessResponseWithData(ctx, message, profile)
+}
+
+// Errors from userManager.Update and UpdateProfile caused by the submitted data
+func respondUpdateError(ctx *gin.Context, err error) bool {
+	var rateLimited *managers.EmailRateLimitError
+	switch {
+	case errors.Is(err, common.ErrInvalidPhoneNumber):
+		common.BadResponse(ctx, "Invalid phone number")
+	case errors.Is(err, managers.ErrEmailAlreadyExists):
+		common.BadResponse(ctx, "Email already exists")
+	case errors.As(err, &rateLimited):
+		common.TooManyRequestsResponse(ctx, "Too many email change requests. Please wait before trying again.", rateLimited.RetryAfter)
+	default:
+		return false
+	}
+	return true
+}
+
 func (userHandler *UserHandler) VerifyEmail(ctx *gin.Context) {
 	token := ctx.Query("token")
 
@@ -324,6 +469,8 @@ func (userHandler *UserHandler) VerifyEmail(ctx *gin.Context) {
 	if err != nil {
 		if errors.Is(err, managers.ErrInvalidToken) {
 			common.BadResponse(ctx, "Invalid or expired verification token")
+		} else if errors.Is(err, managers.ErrVerificationTokenExpired) {
+			common.BadResponse(ctx, "Verification link has expired. Please request a new one.")
 		} else {
 			log.Printf("Error during email verification for token %s: %v", token, err)
 			common.InternalServerErrorResponse(ctx, "Failed to verify email")
@@ -331,4 +478,140 @@ func (userHandler *UserHandler) VerifyEmail(ctx *gin.Context) {
 		return
 	}
 	common.SuccessResponse(ctx, "Email verified successfully")
-}
\ No newline at end of file
+}
+
+// Send a new verification link to an account that is not verified yet
+func (userHandler *UserHandler) ResendVerification(ctx *gin.Context) {
+	var resendInput common.ResendVerificationInput
+
+	if err := ctx.BindJSON(&resendInput); err != nil {
+		common.BadResponse(ctx, "Invalid email")
+		return
+	}
+
+	// Sent in the background so neither the response nor its timing reveals whether the account exists
+	go func(email string) {
+		if err := userHandler.userManager.ResendVerification(email); err != nil {
+			log.Printf("Error resending verification email: %v", err)
+		}
+	}(resendInput.Email)
+
+	common.SuccessResponse(ctx, "If an unverified account exists for this email, a new verification link has been sent")
+}
+
+// Apply an email change using the token from the confirmation email
+func (userHandler *UserHandler) ConfirmEmailChange(ctx *gin.Context) {
+	token := ctx.Query("token")
+
+	if token == "" {
+		common.BadResponse(ctx, "Confirmation token is missing")
+		return
+	}
+
+	err := userHandler.userManager.ConfirmEmailChange(token)
+	if err != nil {
+		if errors.Is(err, managers.ErrInvalidEmailChangeToken) {
+			common.BadResponse(ctx, "Invalid or expired confirmation token")
+		} else if errors.Is(err, managers.ErrEmailAlreadyExists) {
+			common.BadResponse(ctx, "Email already exists")
+		} else {
+			log.Printf("Error confirming email change: %v", err)
+			common.InternalServerErrorResponse(ctx, "Failed to change email")
+		}
+		return
+	}
+	common.SuccessResponse(ctx, "Email changed successfully. Please log in again.")
+}
+
+// List the active sessions of the logged in user
+func (userHandler *UserHandler) ListSessions(ctx *gin.Context) {
+	sessions, err := userHandler.sessionManager.List(currentUser(ctx).Id)
+	if err != nil {
+		common.InternalServerErrorResponse(ctx, "Failed to list sessions")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Sessions retrieved successfully", sessionResponses(sessions, currentClaims(ctx).SessionID))
+}
+
+// Log out a single session of the logged in user
+func (userHandler *UserHandler) RevokeSession(ctx *gin.Context) {
+	sessionID, err := strconv.Atoi(ctx.Param("sessionid"))
+	if err != nil {
+		common.BadResponse(ctx, "Invalid session ID")
+		return
+	}
+
+	err = userHandler.sessionManager.Revoke(currentUser(ctx).Id, uint(sessionID))
+	if err != nil {
+		if errors.Is(err, managers.ErrSessionNotFound) {
+			common.NotFoundResponse(ctx, "Session not found")
+			return
+		}
+		log.Printf("Error revoking session %d: %v", sessionID, err)
+		common.InternalServerErrorResponse(ctx, "Failed to revoke session")
+		return
+	}
+
+	common.SuccessResponse(ctx, "Session revoked successfully")
+}
+
+// Log out every session of the logged in user except the current one
+func (userHandler *UserHandler) RevokeOtherSessions(ctx *gin.Context) {
+	revoked, err := userHandler.sessionManager.RevokeOthers(currentUser(ctx).Id, currentClaims(ctx).SessionID)
+	if err != nil {
+		log.Printf("Error revoking other sessions: %v", err)
+		common.InternalServerErrorResponse(ctx, "Failed to revoke sessions")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Logged out of all other sessions", gin.H{"revoked": revoked})
+}
+
+// List the active sessions of any user, for support staff
+func (userHandler *UserHandler) ListUserSessions(ctx *gin.Context) {
+	userID, err := strconv.Atoi(ctx.Param("userid"))
+	if err != nil {
+		common.BadResponse(ctx, "Invalid user ID")
+		return
+	}
+
+	sessions, err := userHandler.sessionManager.List(uint(userID))
+	if err != nil {
+		common.InternalServerErrorResponse(ctx, "Failed to list sessions")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Sessions retrieved successfully", sessionResponses(sessions, 0))
+}
+
+// Lift a login lockout on behalf of a user
+func (userHandler *UserHandler) Unlock(ctx *gin.Context) {
+	user, err := userHandler.userManager.Unlock(ctx.Param("userid"))
+	if err != nil {
+		if errors.Is(err, managers.ErrUserNotFound) {
+			common.NotFoundResponse(ctx, "User not found")
+			return
+		}
+		common.InternalServerErrorResponse(ctx, "Failed to unlock user")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "User unlocked", user)
+}
+
+func sessionResponses(sessions []models.Session, currentSessionID uint) []common.SessionResponse {
+	responses := make([]common.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		responses = append(responses, common.SessionResponse{
+			Id:          session.Id,
+			DeviceLabel: session.DeviceLabel,
+			IPAddress:   session.IPAddress,
+			UserAgent:   session.UserAgent,
+			CreatedAt:   session.CreatedAt,
+			LastSeenAt:  session.LastSeenAt,
+			Current:     session.Id == currentSessionID,
+		})
+	}
+	return responses
+}
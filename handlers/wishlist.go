@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"main/common"
 	"main/managers"
 	"strconv"
@@ -21,11 +22,18 @@ func NewWishlistHandler(wishlistManager managers.WishlistManager) *WishlistHandl
 }
 
 func (wishlisthandler *WishlistHandler) RegisterWishlistApis(router *gin.Engine) {
-	wishlistGroup := router.Group(wishlisthandler.groupName)
+	wishlistGroup := router.Group(wishlisthandler.groupName, AuthMiddleware())
 	wishlistGroup.POST("", wishlisthandler.Add)
-	wishlistGroup.GET(":userid", wishlisthandler.View)
-	wishlistGroup.GET("", wishlisthandler.ViewAll)
+	wishlistGroup.GET("me", wishlisthandler.ViewMine)
 	wishlistGroup.DELETE(":wishlistid", wishlisthandler.Delete)
+
+	// Admin override: act on any user's wishlist
+	readAllGroup := wishlistGroup.Group("", RequirePermission(common.PermissionWishlistsReadAll))
+	readAllGroup.GET("", wishlisthandler.ViewAll)
+	readAllGroup.GET("users/:userid", wishlisthandler.View)
+
+	manageAllGroup := wishlistGroup.Group("admin", AdminOverride(common.PermissionWishlistsManageAll))
+	manageAllGroup.DELETE(":wishlistid", wishlisthandler.Delete)
 }
 
 func (wishlisthandler *WishlistHandler) Add(ctx *gin.Context) {
@@ -35,7 +43,7 @@ func (wishlisthandler *WishlistHandler) Add(ctx *gin.Context) {
 		return
 	}
 
-	newWishlist, err := wishlisthandler.wishlistManager.Add(wishlistData)
+	newWishlist, err := wishlisthandler.wishlistManager.Add(currentUser(ctx).Id, wishlistData)
 	if err != nil {
 		common.InternalServerErrorResponse(ctx, "Failed to add product to wishlist")
 		return
@@ -44,6 +52,16 @@ func (wishlisthandler *WishlistHandler) Add(ctx *gin.Context) {
 	common.SuccessResponseWithData(ctx, "Product added to wishlist successfully", newWishlist)
 }
 
+func (wishlisthandler *WishlistHandler) ViewMine(ctx *gin.Context) {
+	wishlistItems, err := wishlisthandler.wishlistManager.View(currentUser(ctx).Id)
+	if err != nil {
+		common.InternalServerErrorResponse(ctx, "Failed to view wishlist")
+		return
+	}
+
+	common.SuccessResponseWithData(ctx, "Wishlist Successfully retrieved", wishlistItems)
+}
+
 func (wishlisthandler *WishlistHandler) View(ctx *gin.Context) {
 	userIDStr, ok := ctx.Params.Get("userid")
 	if !ok {
@@ -78,7 +96,22 @@ func (wishlisthandler *WishlistHandler) Delete(ctx *gin.Context) {
 		return
 	}
 
-	err = wishlisthandler.wishlistManager.Delete(uint(wishlistID))
+	wishlistItem, err := wishlisthandler.wishlistManager.Get(uint(wishlistID))
+	if err != nil {
+		if errors.Is(err, managers.ErrWishlistItemNotFound) {
+			common.NotFoundResponse(ctx, "Wishlist item not found")
+			return
+		}
+		common.InternalServerErrorResponse(ctx, "Failed to get wishlist item")
+		return
+	}
+
+	if !isAdminOverride(ctx) && wishlistItem.UserID != currentUser(ctx).Id {
+		common.ForbiddenResponse(ctx, "Wishlist item belongs to another user")
+		return
+	}
+
+	err = wishlisthandler.wishlistManager.Delete(wishlistItem.Id)
 	if err != nil {
 		common.InternalServerErrorResponse(ctx, "Failed to delete wishlist item")
 		return
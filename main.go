@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"main/database"
 	"main/handlers"
 	"main/managers"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -25,8 +31,43 @@ func main() {
 	database.Initialize()
 	log.Println("Database Initializing ended...")
 
+	if err := managers.InitializeRevocationStore(); err != nil {
+		log.Fatalf("Failed to initialize token revocation store: %v", err)
+	}
+
+	if err := managers.CheckOtpHMACKey(); err != nil {
+		log.Fatalf("Failed to configure OTPs: %v", err)
+	}
+	if err := managers.InitializeMailer(); err != nil {
+		log.Fatalf("Failed to configure mailer: %v", err)
+	}
+	if err := managers.InitializeTaxCalculator(); err != nil {
+		log.Fatalf("Failed to configure taxes: %v", err)
+	}
+
+	scheduler := managers.NewScheduler()
+	managers.RegisterCleanupJobs(scheduler)
+	managers.RegisterOutboxJobs(scheduler)
+	managers.RegisterReservationJobs(scheduler)
+	scheduler.Start()
+	maintenanceHandler := handlers.NewMaintenanceHandler(scheduler, managers.NewEmailOutboxManager())
+	maintenanceHandler.RegisterMaintenanceApis(router)
+
+	roleManager := managers.NewRoleManager()
+	if err := roleManager.SeedRoles(); err != nil {
+		log.Fatalf("Failed to seed roles: %v", err)
+	}
+	if err := roleManager.EnsureAdmin(os.Getenv("ADMIN_EMAIL")); err != nil {
+		log.Fatalf("Failed to promote admin account: %v", err)
+	}
+	roleHandler := handlers.NewRoleHandler(roleManager)
+	roleHandler.RegisterRoleApis(router)
+
 	userManager := managers.NewUserManager()
-	userHandler := handlers.NewUserHandlerFrom(userManager)
+	tokenManager := managers.NewTokenManager()
+	sessionManager := managers.NewSessionManager()
+	twoFactorManager := managers.NewTwoFactorManager()
+	userHandler := handlers.NewUserHandlerFrom(userManager, tokenManager, sessionManager, twoFactorManager)
 	userHandler.RegisterUserApis(router)
 
 	productManager := managers.NewProductManager()
@@ -41,14 +82,45 @@ func main() {
 	wishlistHandler := handlers.NewWishlistHandler(wishlistManager)
 	wishlistHandler.RegisterWishlistApis(router)
 
+	inventoryManager := managers.NewInventoryManager()
+	inventoryHandler := handlers.NewInventoryHandler(inventoryManager)
+	inventoryHandler.RegisterInventoryApis(router)
+
+	promotionManager := managers.NewPromotionManager()
+	promotionHandler := handlers.NewPromotionHandler(promotionManager)
+	promotionHandler.RegisterPromotionApis(router)
+
 	cartManager := managers.NewCartManager()
 	cartHandler := handlers.NewCartHandler(cartManager)
 	cartHandler.RegisterCartApis(router)
 
-	otpManager := managers.NewOtpManager()
+	orderManager := managers.NewOrderManager()
+	orderHandler := handlers.NewOrderHandler(orderManager)
+	orderHandler.RegisterOrderApis(router)
+
+	paymentProvider, err := managers.NewPaymentProviderFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure payment provider: %v", err)
+	}
+	paymentManager := managers.NewPaymentManager(paymentProvider)
+	managers.SubscribeOrderEvents(paymentManager.RefundCancelledOrder)
+	paymentHandler := handlers.NewPaymentHandler(paymentManager, orderManager)
+	paymentHandler.RegisterPaymentApis(router)
+
+	smsSender, err := managers.NewSMSSenderFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure SMS provider: %v", err)
+	}
+	otpManager := managers.NewOtpManager(smsSender)
 	otpHandler := handlers.NewOtpHandler(otpManager)
 	otpHandler.RegisterOtpApis(router)
 
+	// Template previews show sample data only, but are still kept out of production
+	if gin.Mode() != gin.ReleaseMode {
+		devHandler := handlers.NewDevHandler()
+		devHandler.RegisterDevApis(router)
+	}
+
 	if err := productManager.SeedCategories(); err != nil {
 		log.Fatalf("Failed to seed categories: %v", err)
 	}
@@ -75,5 +147,28 @@ func main() {
 	if port == "" {
 		port = os.Getenv("PORT")
 	}
-	router.Run(":" + port)
+
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	// Finish in-flight requests and background jobs before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Println("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown failed: %v", err)
+	}
+	scheduler.Stop()
+	log.Println("Shutdown complete")
 }
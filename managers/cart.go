@@ -1,15 +1,25 @@
 package managers
 
 import (
+	"errors"
 	"fmt"
 	"main/common"
 	"main/database"
 	"main/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCartItemNotFound = errors.New("cart item not found")
 )
 
 type CartManager interface {
-	Add(cartData *common.CartCreationInput) (*models.Cart, error)
-	View(userID uint) ([]models.Cart, error)
+	Add(userID uint, cartData *common.CartCreationInput) (*models.Cart, error)
+	View(userID uint) (*CartSummary, error)
+	ApplyCoupon(userID uint, code string) (*CartSummary, error)
+	RemoveCoupon(userID uint) (*CartSummary, error)
+	Get(cartID uint) (*models.Cart, error)
 	ViewAll() ([]models.Cart, error)
 	Update(cartID uint, updateData *common.CartUpdateInput) (*models.Cart, error)
 	Delete(cartID uint) error
@@ -23,55 +33,137 @@ func NewCartManager() CartManager {
 	return &cartManager{}
 }
 
-func (cartmanager *cartManager) Add(cartData *common.CartCreationInput) (*models.Cart, error) {
-	var existingCartItem models.Cart
-	result := database.DB.Where("user_id = ? AND product_id = ?", cartData.UserID, cartData.ProductID).First(&existingCartItem)
+// Add a product to the user's cart, or more of it to the line already there.
+// With reservations enabled the line's quantity is held for the user.
+func (cartmanager *cartManager) Add(userID uint, cartData *common.CartCreationInput) (*models.Cart, error) {
+	config := loadReservationConfig()
+	var cartItem models.Cart
 
-	if result.Error == nil {
-		existingCartItem.Quantity += cartData.Quantity
-		result = database.DB.Save(&existingCartItem)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("user_id = ? AND product_id = ?", userID, cartData.ProductID)
+		if cartData.VariantID != nil {
+			query = query.Where("variant_id = ?", *cartData.VariantID)
+		} else {
+			query = query.Where("variant_id IS NULL")
+		}
+		result := query.Limit(1).Find(&cartItem)
 		if result.Error != nil {
-			return nil, fmt.Errorf("failed to update cart item quantity: %w", result.Error)
+			return fmt.Errorf("failed to check existing cart item: %w", result.Error)
 		}
-		err := database.DB.Preload("User").Preload("Product.Category").First(&existingCartItem, existingCartItem.Id).Error
-		if err != nil {
-			fmt.Printf("Error preloading User/Product: %v\n", err)
+
+		if err := checkCartStock(tx, userID, cartData.ProductID, cartData.VariantID, cartItem.Quantity+cartData.Quantity); err != nil {
+			return err
 		}
-		return &existingCartItem, nil
-	} else if result.Error != nil && result.Error.Error() != "record not found" {
-		return nil, fmt.Errorf("failed to check existing cart item: %w", result.Error)
-	}
 
-	newCartItem := &models.Cart{
-		UserID:    cartData.UserID,
-		ProductID: cartData.ProductID,
-		Quantity:  cartData.Quantity,
-	}
+		// The price a line was first added at, so a later change is flagged until the line is removed
+		if cartItem.Id == 0 {
+			price, err := currentPrice(tx, cartData.ProductID)
+			if err != nil {
+				return err
+			}
+			cartItem.AddedPrice = price
+		}
 
-	result = database.DB.Create(newCartItem)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to add product to cart: %w", result.Error)
+		cartItem.UserID = userID
+		cartItem.ProductID = cartData.ProductID
+		cartItem.VariantID = cartData.VariantID
+		cartItem.Quantity += cartData.Quantity
+		cartItem.ReservedUntil = config.holdUntil()
+		if err := tx.Save(&cartItem).Error; err != nil {
+			return fmt.Errorf("failed to add product to cart: %w", err)
+		}
+
+		return extendHolds(tx, userID)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	err := database.DB.Preload("User").Preload("Product.Category").First(&newCartItem, newCartItem.Id).Error
+	err = database.DB.Preload("User").Preload("Product.Category").Preload("Variant").First(&cartItem, cartItem.Id).Error
 	if err != nil {
 		fmt.Printf("Error preloading User/Product: %v\n", err)
 	}
-	return newCartItem, nil
+	return &cartItem, nil
 }
 
-func (cartmanager *cartManager) View(userID uint) ([]models.Cart, error) {
+// The user's cart priced as checkout would price it, with shipping estimated to the profile address
+func (cartmanager *cartManager) View(userID uint) (*CartSummary, error) {
+	return priceUserCart(database.DB, userID)
+}
+
+// Apply a coupon to the user's cart in place of the one it had. A coupon that doesn't apply to
+// the cart as it is now is refused.
+func (cartmanager *cartManager) ApplyCoupon(userID uint, code string) (*CartSummary, error) {
+	var summary *CartSummary
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var coupon models.Coupon
+		result := tx.Where("code = ?", normalizeCouponCode(code)).Limit(1).Find(&coupon)
+		if result.Error != nil {
+			return fmt.Errorf("failed to find coupon: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrCouponNotFound
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&models.CartCoupon{}).Error; err != nil {
+			return fmt.Errorf("failed to replace cart coupon: %w", err)
+		}
+		if err := tx.Create(&models.CartCoupon{UserID: userID, CouponID: coupon.Id}).Error; err != nil {
+			return fmt.Errorf("failed to apply coupon: %w", err)
+		}
+
+		var err error
+		summary, err = priceUserCart(tx, userID)
+		if err != nil {
+			return err
+		}
+		if summary.Coupon != nil && summary.Coupon.err != nil {
+			return summary.Coupon.err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+func (cartmanager *cartManager) RemoveCoupon(userID uint) (*CartSummary, error) {
+	if err := database.DB.Where("user_id = ?", userID).Delete(&models.CartCoupon{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to remove coupon: %w", err)
+	}
+	return priceUserCart(database.DB, userID)
+}
+
+func priceUserCart(db *gorm.DB, userID uint) (*CartSummary, error) {
 	var cartItems []models.Cart
-	err := database.DB.Preload("User").Preload("Product.Category").Where("user_id = ?", userID).Find(&cartItems).Error
+	err := db.Preload("User").Preload("Product.Category").Preload("Variant").Where("user_id = ?", userID).Find(&cartItems).Error
 	if err != nil {
 		return nil, fmt.Errorf("failed to view cart: %w", err)
 	}
-	return cartItems, nil
+
+	var user models.User
+	if err := db.Select("id", "address1", "address2", "city", "district", "state", "country", "pin").Limit(1).Find(&user, userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load cart owner: %w", err)
+	}
+	return priceCart(db, userID, user.Address, cartItems, false)
+}
+
+func (cartmanager *cartManager) Get(cartID uint) (*models.Cart, error) {
+	var cartItem models.Cart
+	result := database.DB.First(&cartItem, cartID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrCartItemNotFound
+		}
+		return nil, fmt.Errorf("failed to get cart item: %w", result.Error)
+	}
+	return &cartItem, nil
 }
 
 func (cartmanager *cartManager) ViewAll() ([]models.Cart, error) {
 	var cartItems []models.Cart
-	err := database.DB.Preload("User").Preload("Product.Category").Find(&cartItems).Error
+	err := database.DB.Preload("User").Preload("Product.Category").Preload("Variant").Find(&cartItems).Error
 	if err != nil {
 		return nil, fmt.Errorf("failed to view all carts: %w", err)
 	}
@@ -79,19 +171,32 @@ func (cartmanager *cartManager) ViewAll() ([]models.Cart, error) {
 }
 
 func (cartmanager *cartManager) Update(cartID uint, updateData *common.CartUpdateInput) (*models.Cart, error) {
+	config := loadReservationConfig()
 	var cartItem models.Cart
-	result := database.DB.First(&cartItem, cartID)
-	if result.Error != nil {
-		return nil, fmt.Errorf("cart item not found: %w", result.Error)
-	}
 
-	cartItem.Quantity = updateData.Quantity
-	result = database.DB.Save(&cartItem)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to update cart item: %w", result.Error)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.First(&cartItem, cartID)
+		if result.Error != nil {
+			return fmt.Errorf("cart item not found: %w", result.Error)
+		}
+
+		if err := checkCartStock(tx, cartItem.UserID, cartItem.ProductID, cartItem.VariantID, updateData.Quantity); err != nil {
+			return err
+		}
+
+		cartItem.Quantity = updateData.Quantity
+		cartItem.ReservedUntil = config.holdUntil()
+		if err := tx.Save(&cartItem).Error; err != nil {
+			return fmt.Errorf("failed to update cart item: %w", err)
+		}
+
+		return extendHolds(tx, cartItem.UserID)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	err := database.DB.Preload("User").Preload("Product.Category").First(&cartItem, cartItem.Id).Error
+	err = database.DB.Preload("User").Preload("Product.Category").Preload("Variant").First(&cartItem, cartItem.Id).Error
 	if err != nil {
 		fmt.Printf("Error preloading User/Product: %v\n", err) // Non-critical, log the error
 	}
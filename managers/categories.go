@@ -25,10 +25,16 @@ func NewCategoryManager() CategoryManager {
 }
 
 func (categoryManager *categoryManager) Create(categoryData *common.CategoryCreationInput) (*models.Category, error) {
+	if err := validateTaxFields(categoryData.TaxRateBasisPoints, categoryData.HSNCode); err != nil {
+		return nil, err
+	}
+
 	newCategory := &models.Category{
-		Name:        categoryData.Name,
-		Description: categoryData.Description,
-		ParentID:    categoryData.ParentID,
+		Name:               categoryData.Name,
+		Description:        categoryData.Description,
+		ParentID:           categoryData.ParentID,
+		HSNCode:            categoryData.HSNCode,
+		TaxRateBasisPoints: categoryData.TaxRateBasisPoints,
 	}
 
 	result := database.DB.Create(newCategory)
@@ -80,6 +86,21 @@ func (categoryManager *categoryManager) Update(categoryID string, categoryData *
 	if categoryData.ParentID != nil {
 		category.ParentID = categoryData.ParentID
 	}
+	if err := validateTaxFields(categoryData.TaxRateBasisPoints, categoryData.HSNCode); err != nil {
+		return nil, err
+	}
+	if categoryData.HSNCode != "" {
+		category.HSNCode = categoryData.HSNCode
+	}
+	if categoryData.TaxRateBasisPoints != nil {
+		category.TaxRateBasisPoints = categoryData.TaxRateBasisPoints
+	}
+	if categoryData.ClearHSNCode {
+		category.HSNCode = ""
+	}
+	if categoryData.ClearTaxRate {
+		category.TaxRateBasisPoints = nil
+	}
 
 	result = database.DB.Save(&category)
 	if result.Error != nil {
@@ -0,0 +1,105 @@
+package managers
+
+import (
+	"context"
+	"fmt"
+	"main/common"
+	"main/database"
+	"main/models"
+	"time"
+)
+
+type cleanupConfig struct {
+	interval  time.Duration
+	retention time.Duration
+}
+
+func loadCleanupConfig() cleanupConfig {
+	return cleanupConfig{
+		interval:  common.DurationFromEnv("CLEANUP_INTERVAL", time.Hour),
+		retention: common.DurationFromEnv("CLEANUP_RETENTION", 7*24*time.Hour),
+	}
+}
+
+// Register the jobs that purge expired and time-bound rows.
+// Revoked and expired sessions and tokens are kept for the retention period so they can still be inspected.
+func RegisterCleanupJobs(scheduler Scheduler) {
+	config := loadCleanupConfig()
+
+	scheduler.Add("expired_otps", config.interval, func(ctx context.Context) (int64, error) {
+		return deleteWhere(ctx, &models.Otp{}, "expires_at < ?", time.Now())
+	})
+
+	scheduler.Add("otp_sends", config.interval, func(ctx context.Context) (int64, error) {
+		return deleteWhere(ctx, &models.OtpSend{}, "created_at < ?", time.Now().Add(-loadOtpLimitConfig().sendWindow))
+	})
+
+	// Tokens issued before they carried an expiry are expired by the account's age
+	scheduler.Add("verification_tokens", config.interval, func(ctx context.Context) (int64, error) {
+		now := time.Now()
+		result := database.DB.WithContext(ctx).Model(&models.User{}).
+			Where("is_verified = ? AND verification_token <> ''", false).
+			Where("verification_token_expires_at < ? OR (verification_token_expires_at IS NULL AND created_at < ?)",
+				now, now.Add(-loadEmailVerificationConfig().tokenTTL)).
+			Updates(map[string]interface{}{"verification_token": "", "verification_token_expires_at": nil})
+		if result.Error != nil {
+			return 0, fmt.Errorf("failed to expire verification tokens: %w", result.Error)
+		}
+		return result.RowsAffected, nil
+	})
+
+	scheduler.Add("email_changes", config.interval, func(ctx context.Context) (int64, error) {
+		return deleteWhere(ctx, &models.EmailChange{}, "expires_at < ? OR confirmed_at IS NOT NULL", time.Now())
+	})
+
+	scheduler.Add("email_sends", config.interval, func(ctx context.Context) (int64, error) {
+		return deleteWhere(ctx, &models.EmailSend{}, "created_at < ?", time.Now().Add(-loadEmailVerificationConfig().sendWindow))
+	})
+
+	// Dead emails stay until they are requeued or looked at
+	scheduler.Add("outbox_emails", config.interval, func(ctx context.Context) (int64, error) {
+		return deleteWhere(ctx, &models.OutboxEmail{}, "status = ? AND sent_at < ?", OutboxStatusSent, time.Now().Add(-config.retention))
+	})
+
+	scheduler.Add("revoked_tokens", config.interval, func(ctx context.Context) (int64, error) {
+		return Revocations().PurgeExpired()
+	})
+
+	scheduler.Add("refresh_tokens", config.interval, func(ctx context.Context) (int64, error) {
+		cutoff := time.Now().Add(-config.retention)
+		return deleteWhere(ctx, &models.RefreshToken{}, "expires_at < ? OR revoked_at < ?", cutoff, cutoff)
+	})
+
+	// Sessions still referenced by refresh tokens are left for a run after refresh_tokens removed them
+	scheduler.Add("sessions", config.interval, func(ctx context.Context) (int64, error) {
+		cutoff := time.Now().Add(-config.retention)
+		return deleteWhere(ctx, &models.Session{},
+			"(expires_at < ? OR revoked_at < ?) AND NOT EXISTS (SELECT 1 FROM refresh_tokens WHERE refresh_tokens.session_id = sessions.id)",
+			cutoff, cutoff)
+	})
+
+	scheduler.Add("password_reset_tokens", config.interval, func(ctx context.Context) (int64, error) {
+		return deleteWhere(ctx, &models.PasswordResetToken{}, "expires_at < ? OR used_at IS NOT NULL", time.Now())
+	})
+
+	scheduler.Add("two_factor_challenges", config.interval, func(ctx context.Context) (int64, error) {
+		return deleteWhere(ctx, &models.TwoFactorChallenge{}, "expires_at < ?", time.Now())
+	})
+
+	scheduler.Add("login_attempts", config.interval, func(ctx context.Context) (int64, error) {
+		// Never drop attempts still inside the IP throttling window
+		cutoff := time.Now().Add(-config.retention)
+		if window := time.Now().Add(-loadLoginThrottleConfig().ipWindow); window.Before(cutoff) {
+			cutoff = window
+		}
+		return deleteWhere(ctx, &models.LoginAttempt{}, "created_at < ?", cutoff)
+	})
+}
+
+func deleteWhere(ctx context.Context, model interface{}, query string, args ...interface{}) (int64, error) {
+	result := database.DB.WithContext(ctx).Where(query, args...).Delete(model)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to delete %T rows: %w", model, result.Error)
+	}
+	return result.RowsAffected, nil
+}
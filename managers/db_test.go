@@ -0,0 +1,43 @@
+package managers
+
+import (
+	"fmt"
+	"main/database"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var testDatabases atomic.Int64
+
+// Point database.DB at a fresh in-memory SQLite database with every table migrated.
+// A single connection keeps SQLite from failing concurrent writers with "table is locked",
+// transactions wait for each other instead, as they would on the row locks MySQL takes.
+func useTestDB(t *testing.T) {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:test%d?mode=memory&cache=shared", testDatabases.Add(1))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	if err := db.AutoMigrate(database.Models()...); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+		sqlDB.Close()
+	})
+}
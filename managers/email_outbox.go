@@ -0,0 +1,245 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"main/common"
+	"main/database"
+	"main/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrOutboxEmailNotFound = errors.New("outbox email not found")
+	ErrOutboxEmailSent     = errors.New("outbox email already sent")
+	ErrUnknownOutboxStatus = errors.New("unknown outbox status")
+)
+
+// Outbox emails are pending until sent, and dead once they ran out of attempts
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSent    = "sent"
+	OutboxStatusDead    = "dead"
+)
+
+type EmailOutboxManager interface {
+	List(status string) ([]models.OutboxEmail, error)
+	Get(id string) (*models.OutboxEmail, error)
+	Requeue(id string) (*models.OutboxEmail, error)
+}
+
+type emailOutboxManager struct {
+}
+
+func NewEmailOutboxManager() EmailOutboxManager {
+	return &emailOutboxManager{}
+}
+
+type outboxConfig struct {
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+	lease        time.Duration
+}
+
+func loadOutboxConfig() outboxConfig {
+	return outboxConfig{
+		pollInterval: common.DurationFromEnv("OUTBOX_POLL_INTERVAL", 5*time.Second),
+		batchSize:    common.IntFromEnv("OUTBOX_BATCH_SIZE", 50),
+		maxAttempts:  common.IntFromEnv("OUTBOX_MAX_ATTEMPTS", 8),
+		baseDelay:    common.DurationFromEnv("OUTBOX_BASE_DELAY", 30*time.Second),
+		maxDelay:     common.DurationFromEnv("OUTBOX_MAX_DELAY", time.Hour),
+		// A claimed email is retried after this long if the worker dies while sending it
+		lease: 5 * time.Minute,
+	}
+}
+
+// Wait before the next attempt, doubling with every failed attempt up to maxDelay
+func (config outboxConfig) retryDelay(attempts int) time.Duration {
+	delay := config.baseDelay
+	for i := 1; i < attempts && delay < config.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > config.maxDelay {
+		delay = config.maxDelay
+	}
+	return delay
+}
+
+// Render a message type and store it for the outbox worker. Pass the transaction that
+// creates the rows the email is about, so the email is only sent if they are committed.
+func enqueueEmail(db *gorm.DB, to string, name string, data interface{}) error {
+	message, err := RenderEmail(name, to, data)
+	if err != nil {
+		return err
+	}
+
+	outboxEmail := &models.OutboxEmail{
+		Recipient:     message.To,
+		Subject:       message.Subject,
+		Template:      name,
+		HTMLBody:      message.HTML,
+		TextBody:      message.Text,
+		Status:        OutboxStatusPending,
+		NextAttemptAt: time.Now(),
+	}
+	if err := db.Create(outboxEmail).Error; err != nil {
+		return fmt.Errorf("failed to queue %s email: %w", name, err)
+	}
+	return nil
+}
+
+// Register the worker that delivers due outbox emails through the configured mailer
+func RegisterOutboxJobs(scheduler Scheduler) {
+	config := loadOutboxConfig()
+
+	scheduler.Add("email_outbox", config.pollInterval, func(ctx context.Context) (int64, error) {
+		return config.deliverDue(ctx)
+	})
+}
+
+// Send the due emails, returning how many were sent
+func (config outboxConfig) deliverDue(ctx context.Context) (int64, error) {
+	emails, err := config.claimDue(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var sent int64
+	for i := range emails {
+		if ctx.Err() != nil {
+			break
+		}
+		if config.deliver(ctx, &emails[i]) {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// Lock a batch of due emails and push their next attempt past the lease, so other
+// instances skip them while this one is sending
+func (config outboxConfig) claimDue(ctx context.Context) ([]models.OutboxEmail, error) {
+	var emails []models.OutboxEmail
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", OutboxStatusPending, time.Now()).
+			Order("next_attempt_at").
+			Limit(config.batchSize).
+			Find(&emails)
+		if result.Error != nil {
+			return fmt.Errorf("failed to find due emails: %w", result.Error)
+		}
+		if len(emails) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(emails))
+		for _, email := range emails {
+			ids = append(ids, email.Id)
+		}
+		result = tx.Model(&models.OutboxEmail{}).Where("id IN ?", ids).Update("next_attempt_at", time.Now().Add(config.lease))
+		if result.Error != nil {
+			return fmt.Errorf("failed to claim due emails: %w", result.Error)
+		}
+		return nil
+	})
+	return emails, err
+}
+
+// Send one claimed email and record the outcome, reporting whether it was sent
+func (config outboxConfig) deliver(ctx context.Context, email *models.OutboxEmail) bool {
+	sendErr := CurrentMailer().Send(&EmailMessage{
+		To:      email.Recipient,
+		Subject: email.Subject,
+		HTML:    email.HTMLBody,
+		Text:    email.TextBody,
+	})
+
+	now := time.Now()
+	updates := map[string]interface{}{"attempts": email.Attempts + 1}
+	if sendErr == nil {
+		// The bodies may hold codes and links that should not outlive delivery
+		updates["status"] = OutboxStatusSent
+		updates["sent_at"] = now
+		updates["last_error"] = ""
+		updates["html_body"] = ""
+		updates["text_body"] = ""
+	} else {
+		lastError := sendErr.Error()
+		if len(lastError) > 1000 {
+			lastError = lastError[:1000]
+		}
+		updates["last_error"] = lastError
+		if email.Attempts+1 >= config.maxAttempts {
+			updates["status"] = OutboxStatusDead
+			log.Printf("Email %d to %s failed %d times and was moved to the dead letters: %v", email.Id, email.Recipient, email.Attempts+1, sendErr)
+		} else {
+			updates["next_attempt_at"] = now.Add(config.retryDelay(email.Attempts + 1))
+			log.Printf("Email %d to %s failed, attempt %d: %v", email.Id, email.Recipient, email.Attempts+1, sendErr)
+		}
+	}
+
+	// Recorded even when the job is being stopped, so a sent email is not sent again
+	if err := database.DB.WithContext(context.WithoutCancel(ctx)).Model(email).Updates(updates).Error; err != nil {
+		log.Printf("Failed to record delivery of email %d: %v", email.Id, err)
+	}
+	return sendErr == nil
+}
+
+// List outbox emails, newest first, optionally only those with a status
+func (emailOutboxManager *emailOutboxManager) List(status string) ([]models.OutboxEmail, error) {
+	query := database.DB.Order("id DESC").Limit(100)
+	switch status {
+	case "":
+	case OutboxStatusPending, OutboxStatusSent, OutboxStatusDead:
+		query = query.Where("status = ?", status)
+	default:
+		return nil, ErrUnknownOutboxStatus
+	}
+
+	emails := []models.OutboxEmail{}
+	if err := query.Find(&emails).Error; err != nil {
+		return nil, fmt.Errorf("failed to list outbox emails: %w", err)
+	}
+	return emails, nil
+}
+
+func (emailOutboxManager *emailOutboxManager) Get(id string) (*models.OutboxEmail, error) {
+	var email models.OutboxEmail
+	result := database.DB.First(&email, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrOutboxEmailNotFound
+		}
+		return nil, fmt.Errorf("failed to find outbox email: %w", result.Error)
+	}
+	return &email, nil
+}
+
+// Give a dead or pending email a fresh set of attempts, starting now
+func (emailOutboxManager *emailOutboxManager) Requeue(id string) (*models.OutboxEmail, error) {
+	email, err := emailOutboxManager.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if email.Status == OutboxStatusSent {
+		return nil, ErrOutboxEmailSent
+	}
+
+	email.Status = OutboxStatusPending
+	email.Attempts = 0
+	email.NextAttemptAt = time.Now()
+	result := database.DB.Model(email).Select("status", "attempts", "next_attempt_at").Updates(email)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to requeue outbox email: %w", result.Error)
+	}
+	return email, nil
+}
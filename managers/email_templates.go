@@ -0,0 +1,158 @@
+package managers
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"main/common"
+	"net/url"
+	"sort"
+	texttemplate "text/template"
+	"time"
+)
+
+var ErrUnknownEmailTemplate = errors.New("unknown email template")
+
+// Every message type has an HTML and a plain-text template named after it in emails/
+//
+//go:embed emails/*.html emails/*.txt
+var emailFiles embed.FS
+
+var (
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(emailFiles, "emails/*.html"))
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(emailFiles, "emails/*.txt"))
+)
+
+type verifyEmailData struct {
+	Name           string
+	Link           string
+	ExpiresInHours int
+}
+
+type resetPasswordData struct {
+	Name             string
+	Link             string
+	ExpiresInMinutes int
+}
+
+type accountLockedData struct {
+	Name      string
+	IPAddress string
+	Until     string
+	ResetLink string
+}
+
+type otpLoginData struct {
+	Name             string
+	Code             string
+	ExpiresInMinutes int
+}
+
+type emailChangeData struct {
+	Name           string
+	NewEmail       string
+	Link           string
+	ExpiresInHours int
+}
+
+type emailChangedData struct {
+	Name     string
+	NewEmail string
+}
+
+type emailTemplate struct {
+	subject string
+	// Data shown by the preview endpoint, built on request so links use the current base URL
+	sample func() interface{}
+}
+
+var emailTemplates = map[string]emailTemplate{
+	"verify_email": {
+		subject: "Verify your Email address",
+		sample: func() interface{} {
+			return verifyEmailData{Name: "Jane", Link: publicLink("/api/user/verify", "sample-token"), ExpiresInHours: 24}
+		},
+	},
+	"reset_password": {
+		subject: "Reset your password",
+		sample: func() interface{} {
+			return resetPasswordData{Name: "Jane", Link: publicLink("/reset-password", "sample-token"), ExpiresInMinutes: 30}
+		},
+	},
+	"account_locked": {
+		subject: "Your account has been locked",
+		sample: func() interface{} {
+			return accountLockedData{Name: "Jane", IPAddress: "203.0.113.7", Until: time.Now().Add(15 * time.Minute).Format(time.RFC1123), ResetLink: publicLink("/forgot-password", "")}
+		},
+	},
+	"otp_login": {
+		subject: "Your login code",
+		sample: func() interface{} {
+			return otpLoginData{Name: "Jane", Code: "123456", ExpiresInMinutes: 5}
+		},
+	},
+	"email_change": {
+		subject: "Confirm your new email address",
+		sample: func() interface{} {
+			return emailChangeData{Name: "Jane", NewEmail: "jane.new@example.com", Link: publicLink("/api/user/email/confirm", "sample-token"), ExpiresInHours: 24}
+		},
+	},
+	"email_changed": {
+		subject: "Your email address was changed",
+		sample: func() interface{} {
+			return emailChangedData{Name: "Jane", NewEmail: "jane.new@example.com"}
+		},
+	},
+}
+
+// Absolute link under PUBLIC_BASE_URL, with the token as query parameter when given
+func publicLink(path string, token string) string {
+	link := common.PublicBaseURL() + path
+	if token != "" {
+		link += "?" + url.Values{"token": {token}}.Encode()
+	}
+	return link
+}
+
+// Render the HTML and plain-text bodies of a message type
+func RenderEmail(name string, to string, data interface{}) (*EmailMessage, error) {
+	template, ok := emailTemplates[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEmailTemplate, name)
+	}
+
+	var htmlBody, textBody bytes.Buffer
+	if err := htmlTemplates.ExecuteTemplate(&htmlBody, name+".html", data); err != nil {
+		return nil, fmt.Errorf("failed to render %s.html: %w", name, err)
+	}
+	if err := textTemplates.ExecuteTemplate(&textBody, name+".txt", data); err != nil {
+		return nil, fmt.Errorf("failed to render %s.txt: %w", name, err)
+	}
+
+	return &EmailMessage{
+		To:      to,
+		Subject: template.subject,
+		HTML:    htmlBody.String(),
+		Text:    textBody.String(),
+	}, nil
+}
+
+// Render a message type with its sample data
+func PreviewEmail(name string) (*EmailMessage, error) {
+	template, ok := emailTemplates[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEmailTemplate, name)
+	}
+	return RenderEmail(name, "jane@example.com", template.sample())
+}
+
+func EmailTemplateNames() []string {
+	names := make([]string, 0, len(emailTemplates))
+	for name := range emailTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,274 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"main/common"
+	"main/database"
+	"main/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrVerificationTokenExpired = errors.New("verification token expired")
+	ErrInvalidEmailChangeToken  = errors.New("invalid or expired email change token")
+	ErrEmailRateLimited         = errors.New("too many emails requested")
+)
+
+// EmailRateLimitError tells the caller when another link can be requested
+type EmailRateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (err *EmailRateLimitError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", ErrEmailRateLimited, err.RetryAfter.Round(time.Second))
+}
+
+func (err *EmailRateLimitError) Unwrap() error {
+	return ErrEmailRateLimited
+}
+
+// Kinds of links counted against the email quota
+const (
+	emailKindVerification = "verification"
+	emailKindEmailChange  = "email_change"
+)
+
+type emailVerificationConfig struct {
+	tokenTTL   time.Duration
+	cooldown   time.Duration
+	maxSends   int
+	sendWindow time.Duration
+}
+
+func loadEmailVerificationConfig() emailVerificationConfig {
+	return emailVerificationConfig{
+		tokenTTL:   common.DurationFromEnv("VERIFICATION_TOKEN_TTL", 24*time.Hour),
+		cooldown:   common.DurationFromEnv("EMAIL_RESEND_COOLDOWN", time.Minute),
+		maxSends:   common.IntFromEnv("EMAIL_RESEND_MAX", 5),
+		sendWindow: common.DurationFromEnv("EMAIL_RESEND_WINDOW", 24*time.Hour),
+	}
+}
+
+// A fresh verification token and the time it stops working
+func (config emailVerificationConfig) newVerificationToken() (string, time.Time, error) {
+	uuidToken, err := uuid.NewUUID()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate UUID: %w", err)
+	}
+	return uuidToken.String(), time.Now().Add(config.tokenTTL), nil
+}
+
+// Tokens issued before they carried an expiry last for the TTL from sign up
+func (config emailVerificationConfig) verificationExpired(user *models.User) bool {
+	expiresAt := user.CreatedAt.Add(config.tokenTTL)
+	if user.VerificationTokenExpiresAt != nil {
+		expiresAt = *user.VerificationTokenExpiresAt
+	}
+	return expiresAt.Before(time.Now())
+}
+
+// Refuse to send when the user is inside the cooldown or over the quota for this kind of link
+func (config emailVerificationConfig) checkQuota(userID uint, kind string) error {
+	var sends []models.EmailSend
+	result := database.DB.Where("user_id = ? AND kind = ? AND created_at > ?", userID, kind, time.Now().Add(-config.sendWindow)).
+		Order("created_at DESC").
+		Find(&sends)
+	if result.Error != nil {
+		return fmt.Errorf("failed to count email sends: %w", result.Error)
+	}
+	if len(sends) == 0 {
+		return nil
+	}
+
+	if wait := time.Until(sends[0].CreatedAt.Add(config.cooldown)); wait > 0 {
+		return &EmailRateLimitError{RetryAfter: wait}
+	}
+	if len(sends) >= config.maxSends {
+		return &EmailRateLimitError{RetryAfter: time.Until(sends[len(sends)-1].CreatedAt.Add(config.sendWindow))}
+	}
+	return nil
+}
+
+func recordEmailSend(db *gorm.DB, userID uint, kind string) error {
+	if err := db.Create(&models.EmailSend{UserID: userID, Kind: kind}).Error; err != nil {
+		return fmt.Errorf("failed to record email send: %w", err)
+	}
+	return nil
+}
+
+// Send a new verification link, replacing the old one. Unknown and already verified
+// accounts are ignored so callers can't probe for accounts.
+func (userManager *userManager) ResendVerification(email string) error {
+	var user models.User
+	result := database.DB.Where("email = ?", strings.TrimSpace(email)).First(&user)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to find user: %w", result.Error)
+	}
+	if user.IsVerified {
+		return nil
+	}
+
+	config := loadEmailVerificationConfig()
+	if err := config.checkQuota(user.Id, emailKindVerification); err != nil {
+		return err
+	}
+
+	token, expiresAt, err := config.newVerificationToken()
+	if err != nil {
+		return err
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&user).Updates(map[string]interface{}{
+			"verification_token":            token,
+			"verification_token_expires_at": expiresAt,
+		})
+		if result.Error != nil {
+			return fmt.Errorf("failed to store verification token: %w", result.Error)
+		}
+		return queueVerificationEmail(tx, &user, token)
+	})
+}
+
+// Queue the verification link for a user and count it against the resend quota
+func queueVerificationEmail(db *gorm.DB, user *models.User, token string) error {
+	if err := recordEmailSend(db, user.Id, emailKindVerification); err != nil {
+		return err
+	}
+
+	return enqueueEmail(db, user.Email, "verify_email", verifyEmailData{
+		Name:           user.FirstName,
+		Link:           publicLink("/api/user/verify", token),
+		ExpiresInHours: int(loadEmailVerificationConfig().tokenTTL.Hours()),
+	})
+}
+
+// Store a pending change of address and send the confirmation link to the new address.
+// The current address keeps working until the link is opened.
+func requestEmailChange(user *models.User, newEmail string) error {
+	config := loadEmailVerificationConfig()
+
+	token, err := common.GenerateOpaqueToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate email change token: %w", err)
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Only the latest request can be confirmed
+		if err := tx.Where("user_id = ? AND confirmed_at IS NULL", user.Id).Delete(&models.EmailChange{}).Error; err != nil {
+			return fmt.Errorf("failed to clear previous email changes: %w", err)
+		}
+
+		change := &models.EmailChange{
+			UserID:    user.Id,
+			NewEmail:  newEmail,
+			TokenHash: common.HashToken(token),
+			ExpiresAt: time.Now().Add(config.tokenTTL),
+		}
+		if err := tx.Create(change).Error; err != nil {
+			return fmt.Errorf("failed to store email change: %w", err)
+		}
+		if err := recordEmailSend(tx, user.Id, emailKindEmailChange); err != nil {
+			return err
+		}
+		return enqueueEmail(tx, newEmail, "email_change", emailChangeData{
+			Name:           user.FirstName,
+			NewEmail:       newEmail,
+			Link:           publicLink("/api/user/email/confirm", token),
+			ExpiresInHours: int(config.tokenTTL.Hours()),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	logSecurityEvent(EventEmailChangeRequested, "user_id", user.Id)
+	return nil
+}
+
+// The address a user has asked to move to and not confirmed yet, if any
+func pendingEmailChange(userID uint) (string, error) {
+	var change models.EmailChange
+	result := database.DB.Where("user_id = ? AND confirmed_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("created_at DESC").
+		Limit(1).
+		Find(&change)
+	if result.Error != nil {
+		return "", fmt.Errorf("failed to find email change: %w", result.Error)
+	}
+	return change.NewEmail, nil
+}
+
+// Move the account to the new address from a confirmation link, tell the old address
+// and log the user out everywhere since access tokens carry the email
+func (userManager *userManager) ConfirmEmailChange(token string) error {
+	var user models.User
+	var oldEmail, newEmail string
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var change models.EmailChange
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", common.HashToken(token)).First(&change)
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return ErrInvalidEmailChangeToken
+			}
+			return fmt.Errorf("failed to find email change: %w", result.Error)
+		}
+
+		if change.ConfirmedAt != nil || change.ExpiresAt.Before(time.Now()) {
+			return ErrInvalidEmailChangeToken
+		}
+
+		// The address may have been taken since the change was requested
+		var taken int64
+		if err := tx.Model(&models.User{}).Where("email = ? AND id <> ?", change.NewEmail, change.UserID).Count(&taken).Error; err != nil {
+			return fmt.Errorf("failed to check email existence: %w", err)
+		}
+		if taken > 0 {
+			return ErrEmailAlreadyExists
+		}
+
+		if err := tx.First(&user, change.UserID).Error; err != nil {
+			return fmt.Errorf("failed to find user: %w", err)
+		}
+		oldEmail, newEmail = user.Email, change.NewEmail
+
+		now := time.Now()
+		change.ConfirmedAt = &now
+		if err := tx.Save(&change).Error; err != nil {
+			return fmt.Errorf("failed to confirm email change: %w", err)
+		}
+
+		// Opening the link proves the new mailbox, so the account counts as verified
+		result = tx.Model(&user).Updates(map[string]interface{}{
+			"email":                         newEmail,
+			"is_verified":                   true,
+			"verification_token":            "",
+			"verification_token_expires_at": nil,
+		})
+		if result.Error != nil {
+			return fmt.Errorf("failed to update email: %w", result.Error)
+		}
+
+		return enqueueEmail(tx, oldEmail, "email_changed", emailChangedData{
+			Name:     user.FirstName,
+			NewEmail: newEmail,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	logSecurityEvent(EventEmailChanged, "user_id", user.Id)
+
+	return revokeSessions(database.DB.Where("user_id = ?", user.Id))
+}
@@ -0,0 +1,38 @@
+package managers
+
+import (
+	"log"
+	"sync"
+)
+
+// eventBus hands every published event to the subscribed handlers, in the publishing goroutine
+type eventBus[T any] struct {
+	name     string
+	mu       sync.RWMutex
+	handlers []func(event T)
+}
+
+func (bus *eventBus[T]) subscribe(handler func(event T)) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	bus.handlers = append(bus.handlers, handler)
+}
+
+// A failing handler is logged and does not stop the others
+func (bus *eventBus[T]) publish(event T) {
+	bus.mu.RLock()
+	handlers := bus.handlers
+	bus.mu.RUnlock()
+
+	for _, handler := range handlers {
+		func() {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					log.Printf("%s event handler panicked for %+v: %v", bus.name, event, recovered)
+				}
+			}()
+			handler(event)
+		}()
+	}
+}
@@ -0,0 +1,396 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"main/common"
+	"main/database"
+	"main/models"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInsufficientStock    = errors.New("insufficient stock")
+	ErrVariantRequired      = errors.New("product has variants, one must be chosen")
+	ErrVariantNotFound      = errors.New("variant not found")
+	ErrVariantSKUExists     = errors.New("variant SKU already exists")
+	ErrProductHasStock      = errors.New("product stock must be adjusted to zero before adding variants")
+	ErrInvalidStockMovement = errors.New("invalid stock movement")
+)
+
+// InsufficientStockError tells the caller how much of the product or variant is left
+type InsufficientStockError struct {
+	ProductID uint
+	VariantID *uint
+	Available int
+}
+
+func (err *InsufficientStockError) Error() string {
+	if err.VariantID != nil {
+		return fmt.Sprintf("%v: variant %d of product %d has %d available", ErrInsufficientStock, *err.VariantID, err.ProductID, err.Available)
+	}
+	return fmt.Sprintf("%v: product %d has %d available", ErrInsufficientStock, err.ProductID, err.Available)
+}
+
+func (err *InsufficientStockError) Unwrap() error {
+	return ErrInsufficientStock
+}
+
+// Kinds of stock movements
+const (
+	StockMovementReceipt    = "receipt"
+	StockMovementSale       = "sale"
+	StockMovementAdjustment = "adjustment"
+	StockMovementReturn     = "return"
+)
+
+type inventoryConfig struct {
+	lowStockThreshold int
+}
+
+func loadInventoryConfig() inventoryConfig {
+	return inventoryConfig{
+		lowStockThreshold: common.IntFromEnv("LOW_STOCK_THRESHOLD", 5),
+	}
+}
+
+// LowStockEvent is published when a sale or adjustment takes stock down to its threshold
+type LowStockEvent struct {
+	ProductID uint
+	VariantID *uint
+	SKU       string
+	Name      string
+	Stock     int
+	Threshold int
+}
+
+var lowStockEvents = &eventBus[LowStockEvent]{name: "Low stock"}
+
+// Call handler whenever a product or variant falls to its low-stock threshold.
+// Handlers run in the goroutine that changed the stock, after the change has been committed.
+func SubscribeLowStockEvents(handler func(event LowStockEvent)) {
+	lowStockEvents.subscribe(handler)
+}
+
+func publishLowStockEvents(events []LowStockEvent) {
+	for _, event := range events {
+		log.Printf("%s (%s) is low on stock: %d left, threshold %d", event.Name, event.SKU, event.Stock, event.Threshold)
+		lowStockEvents.publish(event)
+	}
+}
+
+// Where the stock of a cart line or order line is tracked: its variant, or the product when it has none
+type stockLevel struct {
+	productID uint
+	variantID *uint
+	sku       string
+	name      string
+	stock     int
+	threshold *int
+}
+
+// The row's own threshold, or LOW_STOCK_THRESHOLD when it has none
+func (config inventoryConfig) threshold(own *int) int {
+	if own != nil {
+		return *own
+	}
+	return config.lowStockThreshold
+}
+
+// Load the stock of a product or one of its variants, locking the row when asked to.
+// Products with variants only have stock through them.
+func loadStock(db *gorm.DB, productID uint, variantID *uint, lock bool) (*stockLevel, error) {
+	query := func() *gorm.DB {
+		if lock {
+			return db.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+		return db
+	}
+
+	var product models.Product
+	result := db.First(&product, productID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %d", ErrProductUnavailable, productID)
+		}
+		return nil, fmt.Errorf("failed to find product: %w", result.Error)
+	}
+
+	if variantID != nil {
+		var variant models.ProductVariant
+		result := query().Where("product_id = ?", productID).First(&variant, *variantID)
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return nil, ErrVariantNotFound
+			}
+			return nil, fmt.Errorf("failed to find variant: %w", result.Error)
+		}
+		return &stockLevel{
+			productID: productID,
+			variantID: &variant.Id,
+			sku:       variant.SKU,
+			name:      product.Name + " (" + variant.Name + ")",
+			stock:     variant.Stock,
+			threshold: variant.LowStockThreshold,
+		}, nil
+	}
+
+	var variants int64
+	if err := db.Model(&models.ProductVariant{}).Where("product_id = ?", productID).Count(&variants).Error; err != nil {
+		return nil, fmt.Errorf("failed to count variants: %w", err)
+	}
+	if variants > 0 {
+		return nil, ErrVariantRequired
+	}
+
+	if lock {
+		if err := query().First(&product, productID).Error; err != nil {
+			return nil, fmt.Errorf("failed to lock product: %w", err)
+		}
+	}
+	return &stockLevel{
+		productID: productID,
+		sku:       product.SKU,
+		name:      product.Name,
+		stock:     product.Stock,
+		threshold: product.LowStockThreshold,
+	}, nil
+}
+
+// Change the stock of a product or variant by delta inside tx and record the movement.
+// Stock never goes below zero. Returns an event when the change reaches the low-stock threshold.
+func adjustStock(tx *gorm.DB, productID uint, variantID *uint, delta int, movement *models.StockMovement) (*LowStockEvent, error) {
+	level, err := loadStock(tx, productID, variantID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	after := level.stock + delta
+	if after < 0 {
+		return nil, &InsufficientStockError{ProductID: productID, VariantID: level.variantID, Available: level.stock}
+	}
+
+	var result *gorm.DB
+	if level.variantID != nil {
+		result = tx.Model(&models.ProductVariant{}).Where("id = ?", *level.variantID).Update("stock", after)
+	} else {
+		result = tx.Model(&models.Product{}).Where("id = ?", productID).Update("stock", after)
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update stock: %w", result.Error)
+	}
+
+	movement.ProductID = productID
+	movement.VariantID = level.variantID
+	movement.Quantity = delta
+	movement.StockAfter = after
+	if err := tx.Create(movement).Error; err != nil {
+		return nil, fmt.Errorf("failed to record stock movement: %w", err)
+	}
+
+	threshold := loadInventoryConfig().threshold(level.threshold)
+	if delta < 0 && level.stock > threshold && after <= threshold {
+		return &LowStockEvent{
+			ProductID: productID,
+			VariantID: level.variantID,
+			SKU:       level.sku,
+			Name:      level.name,
+			Stock:     after,
+			Threshold: threshold,
+		}, nil
+	}
+	return nil, nil
+}
+
+// Put the items of a cancelled or refunded order back into stock. Products deleted since are restocked too.
+func restockOrder(tx *gorm.DB, order *models.Order, note string) error {
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ?", order.Id).Order("product_id, variant_id").Find(&items).Error; err != nil {
+		return fmt.Errorf("failed to load order items: %w", err)
+	}
+
+	// A fresh session, so conditions of one query don't leak into the next
+	unscoped := tx.Unscoped().Session(&gorm.Session{})
+	for _, item := range items {
+		_, err := adjustStock(unscoped, item.ProductID, item.VariantID, int(item.Quantity), &models.StockMovement{
+			Kind:    StockMovementReturn,
+			OrderID: &order.Id,
+			Note:    note,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type InventoryManager interface {
+	RecordMovement(movementData *common.StockMovementInput, actorID uint) (*models.StockMovement, error)
+	Movements(productID uint) ([]models.StockMovement, error)
+	LowStock() ([]common.LowStockItem, error)
+	CreateVariant(productID uint, variantData *common.VariantCreationInput) (*models.ProductVariant, error)
+	UpdateVariant(variantID uint, variantData *common.VariantUpdationInput) (*models.ProductVariant, error)
+}
+
+type inventoryManager struct {
+}
+
+func NewInventoryManager() InventoryManager {
+	return &inventoryManager{}
+}
+
+// Record a receipt, return or adjustment by hand. Sales are only recorded by checkout.
+func (inventoryManager *inventoryManager) RecordMovement(movementData *common.StockMovementInput, actorID uint) (*models.StockMovement, error) {
+	switch movementData.Kind {
+	case StockMovementReceipt, StockMovementReturn:
+		if movementData.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: %s quantity must be positive", ErrInvalidStockMovement, movementData.Kind)
+		}
+	case StockMovementAdjustment:
+		if movementData.Quantity == 0 {
+			return nil, fmt.Errorf("%w: adjustment quantity must not be zero", ErrInvalidStockMovement)
+		}
+	default:
+		return nil, fmt.Errorf("%w: kind must be receipt, return or adjustment", ErrInvalidStockMovement)
+	}
+
+	movement := &models.StockMovement{
+		Kind:    movementData.Kind,
+		ActorID: &actorID,
+		Note:    movementData.Note,
+	}
+
+	var lowStock *LowStockEvent
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		lowStock, err = adjustStock(tx, movementData.ProductID, movementData.VariantID, movementData.Quantity, movement)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if lowStock != nil {
+		publishLowStockEvents([]LowStockEvent{*lowStock})
+	}
+	return movement, nil
+}
+
+// The stock ledger of a product and its variants, newest first
+func (inventoryManager *inventoryManager) Movements(productID uint) ([]models.StockMovement, error) {
+	movements := []models.StockMovement{}
+	err := database.DB.Where("product_id = ?", productID).Order("id DESC").Find(&movements).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stock movements: %w", err)
+	}
+	return movements, nil
+}
+
+// Every product and variant at or below its low-stock threshold, lowest stock first
+func (inventoryManager *inventoryManager) LowStock() ([]common.LowStockItem, error) {
+	config := loadInventoryConfig()
+
+	var products []models.Product
+	if err := database.DB.Preload("Variants").Order("id").Find(&products).Error; err != nil {
+		return nil, fmt.Errorf("failed to load products: %w", err)
+	}
+
+	items := []common.LowStockItem{}
+	for _, product := range products {
+		if len(product.Variants) == 0 {
+			if threshold := config.threshold(product.LowStockThreshold); product.Stock <= threshold {
+				items = append(items, common.LowStockItem{
+					ProductID: product.Id,
+					SKU:       product.SKU,
+					Name:      product.Name,
+					Stock:     product.Stock,
+					Threshold: threshold,
+				})
+			}
+			continue
+		}
+
+		for _, variant := range product.Variants {
+			if threshold := config.threshold(variant.LowStockThreshold); variant.Stock <= threshold {
+				variantID := variant.Id
+				items = append(items, common.LowStockItem{
+					ProductID: product.Id,
+					VariantID: &variantID,
+					SKU:       variant.SKU,
+					Name:      product.Name + " (" + variant.Name + ")",
+					Stock:     variant.Stock,
+					Threshold: threshold,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Stock < items[j].Stock
+	})
+	return items, nil
+}
+
+// Add a variant to a product. Its stock starts at zero and is received like any other.
+func (inventoryManager *inventoryManager) CreateVariant(productID uint, variantData *common.VariantCreationInput) (*models.ProductVariant, error) {
+	var product models.Product
+	result := database.DB.First(&product, productID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %d", ErrProductUnavailable, productID)
+		}
+		return nil, fmt.Errorf("failed to find product: %w", result.Error)
+	}
+	// The product's own stock would be stranded once it is sold through variants
+	if product.Stock != 0 {
+		return nil, ErrProductHasStock
+	}
+
+	variant := &models.ProductVariant{
+		ProductID:         productID,
+		SKU:               variantData.SKU,
+		Name:              variantData.Name,
+		LowStockThreshold: variantData.LowStockThreshold,
+	}
+	if err := database.DB.Create(variant).Error; err != nil {
+		if isDuplicateKeyError(err) {
+			return nil, ErrVariantSKUExists
+		}
+		return nil, fmt.Errorf("failed to create variant: %w", err)
+	}
+	return variant, nil
+}
+
+// Rename a variant or change its SKU or threshold. Stock only changes through movements.
+func (inventoryManager *inventoryManager) UpdateVariant(variantID uint, variantData *common.VariantUpdationInput) (*models.ProductVariant, error) {
+	var variant models.ProductVariant
+	result := database.DB.First(&variant, variantID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrVariantNotFound
+		}
+		return nil, fmt.Errorf("failed to find variant: %w", result.Error)
+	}
+
+	if variantData.SKU != "" {
+		variant.SKU = variantData.SKU
+	}
+	if variantData.Name != "" {
+		variant.Name = variantData.Name
+	}
+	if variantData.LowStockThreshold != nil {
+		variant.LowStockThreshold = variantData.LowStockThreshold
+	}
+
+	if err := database.DB.Omit("Stock").Save(&variant).Error; err != nil {
+		if isDuplicateKeyError(err) {
+			return nil, ErrVariantSKUExists
+		}
+		return nil, fmt.Errorf("failed to update variant: %w", err)
+	}
+	return &variant, nil
+}
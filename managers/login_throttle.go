@@ -0,0 +1,171 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"main/common"
+	"main/database"
+	"main/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrLoginThrottled = errors.New("too many login attempts")
+	ErrAccountLocked  = errors.New("account locked")
+)
+
+// LoginThrottledError tells the caller when the next login attempt will be accepted
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (err *LoginThrottledError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", err.Unwrap(), err.RetryAfter.Round(time.Second))
+}
+
+func (err *LoginThrottledError) Unwrap() error {
+	if err.Locked {
+		return ErrAccountLocked
+	}
+	return ErrLoginThrottled
+}
+
+type loginThrottleConfig struct {
+	maxFailures     int
+	lockoutDuration time.Duration
+	ipMaxFailures   int
+	ipWindow        time.Duration
+	baseDelay       time.Duration
+	maxDelay        time.Duration
+}
+
+func loadLoginThrottleConfig() loginThrottleConfig {
+	return loginThrottleConfig{
+		maxFailures:     common.IntFromEnv("LOGIN_MAX_FAILURES", 5),
+		lockoutDuration: common.DurationFromEnv("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		ipMaxFailures:   common.IntFromEnv("LOGIN_IP_MAX_FAILURES", 20),
+		ipWindow:        common.DurationFromEnv("LOGIN_IP_WINDOW", 15*time.Minute),
+		baseDelay:       common.DurationFromEnv("LOGIN_BASE_DELAY", time.Second),
+		maxDelay:        common.DurationFromEnv("LOGIN_MAX_DELAY", 30*time.Second),
+	}
+}
+
+// Delay required after the nth consecutive failure, doubling each time
+func (config loginThrottleConfig) delayAfter(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+
+	delay := config.baseDelay
+	for i := 1; i < failures && delay < config.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > config.maxDelay {
+		delay = config.maxDelay
+	}
+	return delay
+}
+
+// Refuse the attempt when the IP has too many recent failures
+func (config loginThrottleConfig) checkIP(ipAddress string) error {
+	var failures int64
+	result := database.DB.Model(&models.LoginAttempt{}).
+		Where("ip_address = ? AND success = ? AND created_at > ?", ipAddress, false, time.Now().Add(-config.ipWindow)).
+		Count(&failures)
+	if result.Error != nil {
+		return fmt.Errorf("failed to count login attempts: %w", result.Error)
+	}
+
+	if int(failures) >= config.ipMaxFailures {
+		return &LoginThrottledError{RetryAfter: config.ipWindow}
+	}
+	return nil
+}
+
+// Refuse the attempt while the account is locked or inside its progressive delay
+func (config loginThrottleConfig) checkAccount(user *models.User) error {
+	now := time.Now()
+
+	if user.LockedUntil != nil && user.LockedUntil.After(now) {
+		return &LoginThrottledError{RetryAfter: user.LockedUntil.Sub(now), Locked: true}
+	}
+
+	if user.LastFailedLoginAt != nil {
+		nextAttempt := user.LastFailedLoginAt.Add(config.delayAfter(user.FailedLoginAttempts))
+		if nextAttempt.After(now) {
+			return &LoginThrottledError{RetryAfter: nextAttempt.Sub(now)}
+		}
+	}
+	return nil
+}
+
+// Count a failed password for the account, locking it once the threshold is reached.
+// Returns true when this failure locked the account. The count is incremented in the database
+// so concurrent failures are all counted.
+func (config loginThrottleConfig) recordAccountFailure(user *models.User) (bool, error) {
+	now := time.Now()
+	result := database.DB.Model(user).UpdateColumns(map[string]interface{}{
+		"failed_login_attempts": gorm.Expr("failed_login_attempts + 1"),
+		"last_failed_login_at":  now,
+	})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to record failed login: %w", result.Error)
+	}
+
+	var failures int
+	if err := database.DB.Model(&models.User{}).Where("id = ?", user.Id).Pluck("failed_login_attempts", &failures).Error; err != nil {
+		return false, fmt.Errorf("failed to read failed logins: %w", err)
+	}
+	user.FailedLoginAttempts = failures
+	user.LastFailedLoginAt = &now
+	if failures < config.maxFailures {
+		return false, nil
+	}
+
+	// Only the failure that reaches the threshold first locks the account
+	lockedUntil := now.Add(config.lockoutDuration)
+	result = database.DB.Model(&models.User{}).
+		Where("id = ? AND failed_login_attempts >= ?", user.Id, config.maxFailures).
+		UpdateColumns(map[string]interface{}{
+			"failed_login_attempts": 0,
+			"last_failed_login_at":  nil,
+			"locked_until":          lockedUntil,
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to lock account: %w", result.Error)
+	}
+	user.FailedLoginAttempts = 0
+	user.LastFailedLoginAt = nil
+	user.LockedUntil = &lockedUntil
+	return result.RowsAffected > 0, nil
+}
+
+func resetAccountFailures(user *models.User) error {
+	if user.FailedLoginAttempts == 0 && user.LastFailedLoginAt == nil && user.LockedUntil == nil {
+		return nil
+	}
+
+	user.FailedLoginAttempts = 0
+	user.LastFailedLoginAt = nil
+	user.LockedUntil = nil
+	result := database.DB.Model(user).Select("failed_login_attempts", "last_failed_login_at", "locked_until").Updates(user)
+	if result.Error != nil {
+		return fmt.Errorf("failed to reset failed logins: %w", result.Error)
+	}
+	return nil
+}
+
+func recordLoginAttempt(email string, ipAddress string, success bool) {
+	attempt := &models.LoginAttempt{
+		Email:     truncate(email, 191),
+		IPAddress: ipAddress,
+		Success:   success,
+	}
+	if err := database.DB.Create(attempt).Error; err != nil {
+		log.Printf("Failed to record login attempt: %v", err)
+	}
+}
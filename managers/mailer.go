@@ -0,0 +1,171 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// EmailMessage is a rendered email with an HTML body and a plain-text alternative
+type EmailMessage struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+// Mailer delivers rendered emails, so the flows that send them do not depend on one transport
+type Mailer interface {
+	Send(message *EmailMessage) error
+}
+
+var mailer Mailer = NewMemoryMailer()
+
+// The mailer selected by InitializeMailer, shared by every manager
+func CurrentMailer() Mailer {
+	return mailer
+}
+
+// Select the mailer from MAIL_PROVIDER ("smtp", "file" or "memory").
+// When unset, SMTP is used if SMTP_SERVER is set and the file drop otherwise.
+func InitializeMailer() error {
+	provider := strings.ToLower(os.Getenv("MAIL_PROVIDER"))
+	if provider == "" {
+		provider = "smtp"
+		if os.Getenv("SMTP_SERVER") == "" {
+			log.Println("MAIL_PROVIDER is not set and SMTP is not configured, emails are written to the mail drop directory")
+			provider = "file"
+		}
+	}
+
+	switch provider {
+	case "smtp":
+		smtpMailer, err := NewSMTPMailer(os.Getenv("SMTP_SERVER"), os.Getenv("SMTP_PORT"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("FROM_EMAIL"))
+		if err != nil {
+			return err
+		}
+		mailer = smtpMailer
+	case "file":
+		dir := os.Getenv("MAIL_DROP_DIR")
+		if dir == "" {
+			dir = "mail_drop"
+		}
+		mailer = NewFileMailer(dir, os.Getenv("FROM_EMAIL"))
+	case "memory":
+		mailer = NewMemoryMailer()
+	default:
+		return fmt.Errorf("unknown MAIL_PROVIDER %q", os.Getenv("MAIL_PROVIDER"))
+	}
+	return nil
+}
+
+func newGomailMessage(from string, message *EmailMessage) *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetHeader("From", from)
+	m.SetHeader("To", message.To)
+	m.SetHeader("Subject", message.Subject)
+	m.SetBody("text/plain", message.Text)
+	m.AddAlternative("text/html", message.HTML)
+	return m
+}
+
+type smtpMailer struct {
+	dialer *gomail.Dialer
+	from   string
+}
+
+func NewSMTPMailer(host, port, username, password, from string) (Mailer, error) {
+	if host == "" || from == "" {
+		return nil, errors.New("SMTP_SERVER and FROM_EMAIL are required for the smtp mail provider")
+	}
+
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_PORT: %w", err)
+	}
+
+	return &smtpMailer{
+		dialer: gomail.NewDialer(host, portNumber, username, password),
+		from:   from,
+	}, nil
+}
+
+func (smtpMailer *smtpMailer) Send(message *EmailMessage) error {
+	if err := smtpMailer.dialer.DialAndSend(newGomailMessage(smtpMailer.from, message)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+type fileMailer struct {
+	dir  string
+	from string
+	mu   sync.Mutex
+	seq  int
+}
+
+// Write every email as an .eml file into dir instead of sending it, for development
+func NewFileMailer(dir string, from string) Mailer {
+	if from == "" {
+		from = "noreply@localhost"
+	}
+	return &fileMailer{dir: dir, from: from}
+}
+
+func (fileMailer *fileMailer) Send(message *EmailMessage) error {
+	fileMailer.mu.Lock()
+	defer fileMailer.mu.Unlock()
+
+	if err := os.MkdirAll(fileMailer.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create mail drop directory: %w", err)
+	}
+
+	fileMailer.seq++
+	name := fmt.Sprintf("%s-%03d.eml", time.Now().Format("20060102T150405.000"), fileMailer.seq)
+	file, err := os.OpenFile(filepath.Join(fileMailer.dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create mail drop file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := newGomailMessage(fileMailer.from, message).WriteTo(file); err != nil {
+		return fmt.Errorf("failed to write mail drop file: %w", err)
+	}
+	return nil
+}
+
+// MemoryMailer keeps sent emails in memory, for tests and local runs without a mail server
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []EmailMessage
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (memoryMailer *MemoryMailer) Send(message *EmailMessage) error {
+	memoryMailer.mu.Lock()
+	defer memoryMailer.mu.Unlock()
+
+	memoryMailer.messages = append(memoryMailer.messages, *message)
+	return nil
+}
+
+// Return the emails sent so far and forget them
+func (memoryMailer *MemoryMailer) Take() []EmailMessage {
+	memoryMailer.mu.Lock()
+	defer memoryMailer.mu.Unlock()
+
+	messages := memoryMailer.messages
+	memoryMailer.messages = nil
+	return messages
+}
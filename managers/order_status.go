@@ -0,0 +1,186 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"main/database"
+	"main/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrUnknownOrderStatus     = errors.New("unknown order status")
+	ErrInvalidOrderTransition = errors.New("invalid order status transition")
+)
+
+// InvalidTransitionError names the change that the order lifecycle does not allow
+type InvalidTransitionError struct {
+	From string
+	To   string
+}
+
+func (err *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("%v: %s to %s", ErrInvalidOrderTransition, err.From, err.To)
+}
+
+func (err *InvalidTransitionError) Unwrap() error {
+	return ErrInvalidOrderTransition
+}
+
+const (
+	OrderStatusPendingPayment = "pending_payment"
+	OrderStatusPaid           = "paid"
+	OrderStatusPacked         = "packed"
+	OrderStatusShipped        = "shipped"
+	OrderStatusDelivered      = "delivered"
+	OrderStatusCancelled      = "cancelled"
+	OrderStatusRefunded       = "refunded"
+)
+
+// Every status an order can move to from each status. Cancelled and refunded orders are final.
+var orderTransitions = map[string][]string{
+	OrderStatusPendingPayment: {OrderStatusPaid, OrderStatusCancelled},
+	OrderStatusPaid:           {OrderStatusPacked, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusPacked:         {OrderStatusShipped, OrderStatusCancelled},
+	OrderStatusShipped:        {OrderStatusDelivered},
+	OrderStatusDelivered:      {OrderStatusRefunded},
+	OrderStatusCancelled:      {},
+	OrderStatusRefunded:       {},
+}
+
+func canTransitionOrder(from string, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Who changed an order's status, recorded with every transition
+const (
+	OrderActorCustomer = "customer"
+	OrderActorAdmin    = "admin"
+	OrderActorSystem   = "system"
+)
+
+type OrderActor struct {
+	Kind   string
+	UserID uint
+}
+
+func SystemActor() OrderActor {
+	return OrderActor{Kind: OrderActorSystem}
+}
+
+// OrderEvent is published after an order status change has been committed
+type OrderEvent struct {
+	OrderID uint
+	UserID  uint
+	From    string
+	To      string
+	Actor   OrderActor
+	Reason  string
+	At      time.Time
+}
+
+type OrderEventHandler func(event OrderEvent)
+
+var orderEvents = &eventBus[OrderEvent]{name: "Order"}
+
+// Call handler after every order status change, including the checkout that creates the order.
+// Handlers run in the goroutine that made the change, so slow work belongs in a goroutine or the outbox.
+func SubscribeOrderEvents(handler OrderEventHandler) {
+	orderEvents.subscribe(handler)
+}
+
+func publishOrderEvent(event OrderEvent) {
+	orderEvents.publish(event)
+}
+
+// Move a locked order to a new status inside tx and record the transition. The returned
+// event is published by the caller once tx has been committed.
+func transitionOrder(tx *gorm.DB, order *models.Order, to string, actor OrderActor, reason string) (OrderEvent, error) {
+	if _, ok := orderTransitions[to]; !ok {
+		return OrderEvent{}, fmt.Errorf("%w: %s", ErrUnknownOrderStatus, to)
+	}
+
+	from := order.Status
+	if !canTransitionOrder(from, to) {
+		return OrderEvent{}, &InvalidTransitionError{From: from, To: to}
+	}
+
+	if err := tx.Model(order).Update("status", to).Error; err != nil {
+		return OrderEvent{}, fmt.Errorf("failed to update order status: %w", err)
+	}
+	order.Status = to
+
+	// Items that never left the warehouse go back into stock, and the coupon use is
+	// given back. Returns of delivered orders are recorded as movements once the goods are back.
+	if to == OrderStatusCancelled || (to == OrderStatusRefunded && from == OrderStatusPaid) {
+		if err := restockOrder(tx, order, "order "+to); err != nil {
+			return OrderEvent{}, err
+		}
+		if err := releaseCoupon(tx, order); err != nil {
+			return OrderEvent{}, err
+		}
+	}
+
+	return recordOrderTransition(tx, order, from, actor, reason)
+}
+
+// Record that an order, already saved in its current status, came from the given status
+func recordOrderTransition(tx *gorm.DB, order *models.Order, from string, actor OrderActor, reason string) (OrderEvent, error) {
+	transition := &models.OrderTransition{
+		OrderID:    order.Id,
+		FromStatus: from,
+		ToStatus:   order.Status,
+		Actor:      actor.Kind,
+		Reason:     reason,
+	}
+	if actor.UserID != 0 {
+		transition.ActorID = &actor.UserID
+	}
+	if err := tx.Create(transition).Error; err != nil {
+		return OrderEvent{}, fmt.Errorf("failed to record order transition: %w", err)
+	}
+
+	return OrderEvent{
+		OrderID: order.Id,
+		UserID:  order.UserID,
+		From:    from,
+		To:      order.Status,
+		Actor:   actor,
+		Reason:  reason,
+		At:      transition.CreatedAt,
+	}, nil
+}
+
+// Lock an order, move it to a new status and publish the change
+func changeOrderStatus(orderID uint, to string, actor OrderActor, reason string) (*models.Order, error) {
+	var order models.Order
+	var event OrderEvent
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID)
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return ErrOrderNotFound
+			}
+			return fmt.Errorf("failed to find order: %w", result.Error)
+		}
+
+		var err error
+		event, err = transitionOrder(tx, &order, to, actor, reason)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	publishOrderEvent(event)
+	return &order, nil
+}
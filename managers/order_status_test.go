@@ -0,0 +1,38 @@
+package managers
+
+import "testing"
+
+func TestCanTransitionOrder(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{OrderStatusPendingPayment, OrderStatusPaid, true},
+		{OrderStatusPendingPayment, OrderStatusCancelled, true},
+		{OrderStatusPendingPayment, OrderStatusShipped, false},
+		{OrderStatusPendingPayment, OrderStatusRefunded, false},
+		{OrderStatusPaid, OrderStatusPacked, true},
+		{OrderStatusPaid, OrderStatusCancelled, true},
+		{OrderStatusPaid, OrderStatusRefunded, true},
+		{OrderStatusPaid, OrderStatusPaid, false},
+		{OrderStatusPacked, OrderStatusShipped, true},
+		{OrderStatusPacked, OrderStatusCancelled, true},
+		{OrderStatusPacked, OrderStatusDelivered, false},
+		{OrderStatusShipped, OrderStatusDelivered, true},
+		{OrderStatusShipped, OrderStatusCancelled, false},
+		{OrderStatusDelivered, OrderStatusRefunded, true},
+		{OrderStatusDelivered, OrderStatusCancelled, false},
+		{OrderStatusCancelled, OrderStatusPaid, false},
+		{OrderStatusCancelled, OrderStatusRefunded, false},
+		{OrderStatusRefunded, OrderStatusPaid, false},
+		{"placed", OrderStatusPaid, false},
+		{OrderStatusPaid, "lost", false},
+	}
+
+	for _, test := range tests {
+		if got := canTransitionOrder(test.from, test.to); got != test.want {
+			t.Errorf("canTransitionOrder(%q, %q) = %t, want %t", test.from, test.to, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"main/common"
+	"main/database"
+	"main/models"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrOrderNotFound      = errors.New("order not found")
+	ErrCartEmpty          = errors.New("cart is empty")
+	ErrProductUnavailable = errors.New("product is no longer available")
+	ErrInvalidPrice       = errors.New("invalid price")
+)
+
+type OrderManager interface {
+	Checkout(userID uint) (*models.Order, error)
+	List(userID uint) ([]models.Order, error)
+	ListAll() ([]models.Order, error)
+	Get(orderID uint) (*models.Order, error)
+	UpdateStatus(orderID uint, statusData *common.OrderStatusInput, actorID uint) (*models.Order, error)
+	Cancel(orderID uint, actorID uint, reason string) (*models.Order, error)
+}
+
+type orderManager struct {
+}
+
+func NewOrderManager() OrderManager {
+	return &orderManager{}
+}
+
+// Turn the user's cart into an order, take its items out of stock and empty the cart.
+// Names, SKUs and prices are copied from the products as they are now, and the
+// order is priced by the same pipeline as the cart view.
+func (orderManager *orderManager) Checkout(userID uint) (*models.Order, error) {
+	var order models.Order
+	var event OrderEvent
+	var lowStock []LowStockEvent
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return fmt.Errorf("failed to find user: %w", err)
+		}
+
+		// A second checkout of the same cart waits here and then finds it empty
+		var cartItems []models.Cart
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Product").Preload("Variant").Where("user_id = ?", userID).Order("id").Find(&cartItems)
+		if result.Error != nil {
+			return fmt.Errorf("failed to load cart: %w", result.Error)
+		}
+		if len(cartItems) == 0 {
+			return ErrCartEmpty
+		}
+
+		// Stock rows are locked in the same order by every checkout, so concurrent
+		// checkouts of overlapping carts wait for each other instead of deadlocking
+		sort.SliceStable(cartItems, func(i, j int) bool {
+			if cartItems[i].ProductID != cartItems[j].ProductID {
+				return cartItems[i].ProductID < cartItems[j].ProductID
+			}
+			return variantSortKey(cartItems[i].VariantID) < variantSortKey(cartItems[j].VariantID)
+		})
+
+		summary, err := priceCart(tx, userID, user.Address, cartItems, true)
+		if err != nil {
+			return err
+		}
+		if summary.Coupon != nil && summary.Coupon.err != nil {
+			return summary.Coupon.err
+		}
+
+		order = models.Order{
+			UserID:          userID,
+			Status:          OrderStatusPendingPayment,
+			ShippingAddress: user.Address,
+			Subtotal:        summary.Subtotal,
+			Discount:        summary.Discount,
+			Tax:             summary.Tax,
+			Shipping:        summary.Shipping,
+			Total:           summary.Total,
+			TaxInclusive:    summary.TaxInclusive,
+		}
+		if summary.Coupon != nil {
+			order.CouponCode = summary.Coupon.Code
+		}
+
+		cartIDs := make([]uint, 0, len(summary.Items))
+		for _, line := range summary.Items {
+			if line.Unavailable {
+				// Deleted products are not preloaded, and other unavailable lines have no valid price
+				if line.Product.Id == 0 {
+					return fmt.Errorf("%w: %d", ErrProductUnavailable, line.ProductID)
+				}
+				return fmt.Errorf("product %d: %w", line.ProductID, ErrInvalidPrice)
+			}
+
+			item := models.OrderItem{
+				ProductID:    line.ProductID,
+				VariantID:    line.VariantID,
+				ProductName:  line.Product.Name,
+				SKU:          line.Product.SKU,
+				UnitPrice:    line.UnitPrice,
+				Quantity:     line.Quantity,
+				LineTotal:    line.LineTotal,
+				Discount:     line.Discount,
+				Tax:          line.Tax,
+				TaxableValue: line.TaxableValue,
+				HSNCode:      line.HSNCode,
+			}
+			if line.Variant != nil {
+				item.VariantName = line.Variant.Name
+				item.SKU = line.Variant.SKU
+			}
+			for _, allocation := range line.Discounts {
+				item.Discounts = append(item.Discounts, models.OrderItemDiscount{
+					Source:   allocation.Source,
+					SourceID: allocation.SourceID,
+					Label:    allocation.Label,
+					Amount:   allocation.Amount,
+				})
+			}
+			for _, tax := range line.Taxes {
+				item.Taxes = append(item.Taxes, models.OrderItemTax{Name: tax.Name, Rate: tax.Rate, Amount: tax.Amount})
+			}
+			order.Items = append(order.Items, item)
+			cartIDs = append(cartIDs, line.Id)
+		}
+
+		if err := tx.Create(&order).Error; err != nil {
+			return fmt.Errorf("failed to create order: %w", err)
+		}
+
+		for _, item := range order.Items {
+			// Stock held by other carts is not for sale
+			available, err := availableStock(tx, item.ProductID, item.VariantID, userID, true)
+			if err != nil {
+				return err
+			}
+			if int(item.Quantity) > available {
+				return &InsufficientStockError{ProductID: item.ProductID, VariantID: item.VariantID, Available: available}
+			}
+
+			event, err := adjustStock(tx, item.ProductID, item.VariantID, -int(item.Quantity), &models.StockMovement{
+				Kind:    StockMovementSale,
+				OrderID: &order.Id,
+				ActorID: &userID,
+			})
+			if err != nil {
+				return err
+			}
+			if event != nil {
+				lowStock = append(lowStock, *event)
+			}
+		}
+
+		if err := tx.Delete(&models.Cart{}, cartIDs).Error; err != nil {
+			return fmt.Errorf("failed to empty cart: %w", err)
+		}
+		if summary.Coupon != nil {
+			if err := redeemCoupon(tx, summary.Coupon.coupon, &order); err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", userID).Delete(&models.CartCoupon{}).Error; err != nil {
+				return fmt.Errorf("failed to clear cart coupon: %w", err)
+			}
+		}
+
+		event, err = recordOrderTransition(tx, &order, "", OrderActor{Kind: OrderActorCustomer, UserID: userID}, "checkout")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	publishOrderEvent(event)
+	publishLowStockEvents(lowStock)
+	return &order, nil
+}
+
+// Sort key that puts lines without a variant first
+func variantSortKey(variantID *uint) uint {
+	if variantID == nil {
+		return 0
+	}
+	return *variantID
+}
+
+// A user's orders, newest first
+func (orderManager *orderManager) List(userID uint) ([]models.Order, error) {
+	orders := []models.Order{}
+	err := database.DB.Preload("Items").Where("user_id = ?", userID).Order("id DESC").Find(&orders).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+	return orders, nil
+}
+
+func (orderManager *orderManager) ListAll() ([]models.Order, error) {
+	orders := []models.Order{}
+	err := database.DB.Preload("Items").Order("id DESC").Find(&orders).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list all orders: %w", err)
+	}
+	return orders, nil
+}
+
+func (orderManager *orderManager) Get(orderID uint) (*models.Order, error) {
+	var order models.Order
+	byID := func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}
+	result := database.DB.Preload("Items.Discounts").Preload("Items.Taxes").Preload("Transitions", byID).Preload("Payments", byID).First(&order, orderID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("failed to get order: %w", result.Error)
+	}
+	return &order, nil
+}
+
+// Set any status the lifecycle allows from the current one, on behalf of an admin
+func (orderManager *orderManager) UpdateStatus(orderID uint, statusData *common.OrderStatusInput, actorID uint) (*models.Order, error) {
+	if _, err := changeOrderStatus(orderID, statusData.Status, OrderActor{Kind: OrderActorAdmin, UserID: actorID}, statusData.Reason); err != nil {
+		return nil, err
+	}
+	return orderManager.Get(orderID)
+}
+
+// Cancel an order on behalf of its customer, which the lifecycle only allows before shipment
+func (orderManager *orderManager) Cancel(orderID uint, actorID uint, reason string) (*models.Order, error) {
+	if _, err := changeOrderStatus(orderID, OrderStatusCancelled, OrderActor{Kind: OrderActorCustomer, UserID: actorID}, reason); err != nil {
+		return nil, err
+	}
+	return orderManager.Get(orderID)
+}
@@ -0,0 +1,110 @@
+package managers
+
+import (
+	"main/common"
+	"main/database"
+	"main/models"
+	"testing"
+)
+
+func createTestUser(t *testing.T, email string) *models.User {
+	t.Helper()
+	user := &models.User{Email: email, Password: "hash", IsVerified: true}
+	if err := database.DB.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return user
+}
+
+// A product at 500 rupees with the given stock
+func createTestProduct(t *testing.T, stock int) *models.Product {
+	t.Helper()
+	category := &models.Category{Name: "Books"}
+	if err := database.DB.Create(category).Error; err != nil {
+		t.Fatalf("failed to create category: %v", err)
+	}
+	product := &models.Product{SKU: "BOOK-1", Name: "Book", Price: common.NewMoney(50000, "INR"), CategoryID: category.Id, Stock: stock}
+	if err := database.DB.Create(product).Error; err != nil {
+		t.Fatalf("failed to create product: %v", err)
+	}
+	return product
+}
+
+// Put quantity units of the product in the user's cart, with the coupon applied unless it is nil
+func fillCart(t *testing.T, user *models.User, product *models.Product, quantity uint, coupon *models.Coupon) {
+	t.Helper()
+	cartItem := &models.Cart{UserID: user.Id, ProductID: product.Id, Quantity: quantity, AddedPrice: product.Price}
+	if err := database.DB.Create(cartItem).Error; err != nil {
+		t.Fatalf("failed to fill cart: %v", err)
+	}
+	if coupon != nil {
+		if err := database.DB.Create(&models.CartCoupon{UserID: user.Id, CouponID: coupon.Id}).Error; err != nil {
+			t.Fatalf("failed to apply coupon: %v", err)
+		}
+	}
+}
+
+func TestCancelledOrderReturnsStockAndCoupon(t *testing.T) {
+	tests := []struct {
+		name string
+		// Statuses the order moves through after checkout
+		path []string
+		// Whether the items go back into stock and the coupon use is given back
+		wantReleased bool
+	}{
+		{name: "cancelled before payment", path: []string{OrderStatusCancelled}, wantReleased: true},
+		{name: "cancelled after payment", path: []string{OrderStatusPaid, OrderStatusCancelled}, wantReleased: true},
+		{name: "cancelled after packing", path: []string{OrderStatusPaid, OrderStatusPacked, OrderStatusCancelled}, wantReleased: true},
+		{name: "refunded before packing", path: []string{OrderStatusPaid, OrderStatusRefunded}, wantReleased: true},
+		{name: "refunded after delivery", path: []string{OrderStatusPaid, OrderStatusPacked, OrderStatusShipped, OrderStatusDelivered, OrderStatusRefunded}},
+		{name: "delivered", path: []string{OrderStatusPaid, OrderStatusPacked, OrderStatusShipped, OrderStatusDelivered}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestDB(t)
+			user := createTestUser(t, "bob@example.com")
+			product := createTestProduct(t, 10)
+			coupon := &models.Coupon{Code: "TENOFF", Kind: common.CouponKindPercentage, PercentOff: 10, Active: true}
+			if err := database.DB.Create(coupon).Error; err != nil {
+				t.Fatalf("failed to create coupon: %v", err)
+			}
+			fillCart(t, user, product, 3, coupon)
+
+			order, err := NewOrderManager().Checkout(user.Id)
+			if err != nil {
+				t.Fatalf("Checkout() error = %v", err)
+			}
+			for _, status := range test.path {
+				if _, err := changeOrderStatus(order.Id, status, SystemActor(), ""); err != nil {
+					t.Fatalf("changeOrderStatus(%s) error = %v", status, err)
+				}
+			}
+
+			wantStock, wantTimesUsed, wantRedemptions, wantReturns := 7, 1, int64(1), int64(0)
+			if test.wantReleased {
+				wantStock, wantTimesUsed, wantRedemptions, wantReturns = 10, 0, 0, 1
+			}
+
+			database.DB.First(product, product.Id)
+			if product.Stock != wantStock {
+				t.Errorf("stock = %d, want %d", product.Stock, wantStock)
+			}
+			var returns int64
+			database.DB.Model(&models.StockMovement{}).Where("order_id = ? AND kind = ?", order.Id, StockMovementReturn).Count(&returns)
+			if returns != wantReturns {
+				t.Errorf("%d return movements recorded, want %d", returns, wantReturns)
+			}
+
+			database.DB.First(coupon, coupon.Id)
+			if coupon.TimesUsed != wantTimesUsed {
+				t.Errorf("coupon used %d times, want %d", coupon.TimesUsed, wantTimesUsed)
+			}
+			var redemptions int64
+			database.DB.Model(&models.CouponRedemption{}).Where("order_id = ?", order.Id).Count(&redemptions)
+			if redemptions != wantRedemptions {
+				t.Errorf("%d coupon redemptions left, want %d", redemptions, wantRedemptions)
+			}
+		})
+	}
+}
@@ -7,148 +7,324 @@ import (
 	"main/common"
 	"main/database"
 	"main/models"
-	"os"
-	"regexp"
 	"strings"
 	"time"
 
-	twilio "github.com/twilio/twilio-go"
-	openapi "github.com/twilio/twilio-go/rest/api/v2010"
 	"gorm.io/gorm"
 )
 
 var (
-	ErrInvalidOTP = errors.New("invalid OTP")
-	ErrOTPExpired = errors.New("OTP expired")
+	ErrInvalidOTP           = errors.New("invalid OTP")
+	ErrOTPExpired           = errors.New("OTP expired")
+	ErrUnknownOtpChannel    = errors.New("unknown OTP channel")
+	ErrPhoneMissing         = errors.New("no phone number on the account")
+	ErrPhoneAlreadyVerified = errors.New("phone number already verified")
+	ErrPhoneInUse           = errors.New("phone number verified by another account")
 )
 
 type OtpManager interface {
-	SendOTP(userID uint, phoneNumber string) error
-	VerifyOTP(phoneNumber string, otp string) error
+	SendOTP(userID uint) error
+	VerifyOTP(userID uint, otp string) error
+	StartLogin(channel string, destination string) error
+	CompleteLogin(channel string, destination string, otp string, client common.ClientInfo) (*models.User, *common.TokenPair, error)
 }
 
 type otpManager struct {
-	//client
+	smsSender    SMSSender
+	tokenManager TokenManager
 }
 
-func NewOtpManager() OtpManager {
-	return &otpManager{}
+func NewOtpManager(smsSender SMSSender) OtpManager {
+	return &otpManager{
+		smsSender:    smsSender,
+		tokenManager: NewTokenManager(),
+	}
 }
 
 const otpLength = 6
 const otpExpiration = 5 * time.Minute
 
-func (otpManager *otpManager) SendOTP(userID uint, phoneNumber string) error {
-	phoneNumber = formatPhoneNumber(phoneNumber)
-
-	otp := common.GenerateOTP(otpLength)
-
-	expiresAt := time.Now().Add(otpExpiration)
+// Codes sent for phone verification and for passwordless login are kept apart
+const (
+	otpPurposeVerify = "verify"
+	otpPurposeLogin  = "login"
+)
 
-	otpRecord := models.Otp{
-		UserID:    userID,
-		OTP:       otp,
-		CreatedAt: time.Now(),
-		ExpiresAt: expiresAt,
+// Send a verification code to the phone number on the user's profile
+func (otpManager *otpManager) SendOTP(userID uint) error {
+	user, err := findPhoneToVerify(userID)
+	if err != nil {
+		return err
 	}
 
-	result := database.DB.Create(&otpRecord)
-	if result.Error != nil {
-		return fmt.Errorf("failed to Create Otp record: %w", result.Error)
+	otpRecord, otp, err := loadOtpLimitConfig().issueOTP(user.Id, otpPurposeVerify, common.OtpChannelSMS, user.Phone)
+	if err != nil {
+		if errors.Is(err, ErrOTPRateLimited) {
+			logSecurityEvent(EventOtpRateLimited, "user_id", user.Id, "purpose", otpPurposeVerify)
+		}
+		return err
 	}
 
-	err := sendOTP(phoneNumber, otp)
+	err = otpManager.sendOTP(user.Phone, otp)
 	if err != nil {
 
-		deleteErr := database.DB.Delete(&otpRecord).Error
+		deleteErr := database.DB.Delete(otpRecord).Error
 		if deleteErr != nil {
 			log.Printf("Failed to delete OTP record after sending failed: %v", deleteErr)
 
 		}
-		return fmt.Errorf("failed to send OTP via Twilio: %w", err)
+		return fmt.Errorf("failed to send OTP: %w", err)
 	}
 
 	return nil
 }
 
-func (otpManager *otpManager) VerifyOTP(phoneNumber string, otp string) error {
-	phoneNumber = formatPhoneNumber(phoneNumber)
+// Check a code sent by SendOTP and mark the user's phone number as verified
+func (otpManager *otpManager) VerifyOTP(userID uint, otp string) error {
+	user, err := findPhoneToVerify(userID)
+	if err != nil {
+		return err
+	}
+
+	err = loadOtpLimitConfig().consumeOTP(user.Id, otpPurposeVerify, common.OtpChannelSMS, otp)
+	if err != nil {
+		if errors.Is(err, ErrOTPAttemptsExceeded) {
+			warnSecurityEvent(EventOtpAttemptsExceeded, "user_id", user.Id, "purpose", otpPurposeVerify)
+		}
+		return err
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkPhoneAvailable(tx, user); err != nil {
+			return err
+		}
+
+		// The phone is matched too, so a number changed since the code was sent is not marked verified
+		result := tx.Model(&models.User{}).
+			Where("id = ? AND phone = ?", user.Id, user.Phone).
+			Updates(map[string]interface{}{"phone_verified": true, "phone_verified_at": time.Now()})
+		if result.Error != nil {
+			return fmt.Errorf("failed to mark phone verified: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidOTP
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logSecurityEvent(EventPhoneVerified, "user_id", user.Id)
+	return nil
+}
 
+// Load a user whose phone number can be verified, storing the number in E.164 form if it was saved
+// before numbers were normalized
+func findPhoneToVerify(userID uint) (*models.User, error) {
 	var user models.User
-	result := database.DB.Where("phone = ?", phoneNumber).First(&user)
+	result := database.DB.First(&user, userID)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return fmt.Errorf("user with phone number %s not found: %w", phoneNumber, result.Error)
+			return nil, ErrUserNotFound
 		}
-		return fmt.Errorf("failed to find user by phone number: %w", result.Error)
+		return nil, fmt.Errorf("failed to find user: %w", result.Error)
+	}
+
+	if user.Phone == "" {
+		return nil, ErrPhoneMissing
+	}
+	if user.PhoneVerified {
+		return nil, ErrPhoneAlreadyVerified
 	}
 
-	var otpRecord models.Otp
+	phone, err := common.NormalizePhoneNumber(user.Phone)
+	if err != nil {
+		return nil, err
+	}
+	if phone != user.Phone {
+		if err := database.DB.Model(&user).Update("phone", phone).Error; err != nil {
+			return nil, fmt.Errorf("failed to normalize phone number: %w", err)
+		}
+		user.Phone = phone
+	}
 
-	result = database.DB.Where("user_id = ? AND otp = ?", user.Id, otp).
-		Order("created_at DESC").
-		First(&otpRecord)
+	if err := checkPhoneAvailable(database.DB, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
 
+// A number can only be verified on one account, since it identifies the account for SMS login
+func checkPhoneAvailable(tx *gorm.DB, user *models.User) error {
+	var count int64
+	result := tx.Model(&models.User{}).
+		Where("phone = ? AND phone_verified = ? AND id <> ?", user.Phone, true, user.Id).
+		Count(&count)
 	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return ErrInvalidOTP
+		return fmt.Errorf("failed to check phone number: %w", result.Error)
+	}
+	if count > 0 {
+		return ErrPhoneInUse
+	}
+	return nil
+}
+
+// Send a login code to the account behind a phone number or email address.
+// Unknown and unverified accounts are ignored silently so the endpoint does not reveal which accounts exist,
+// and for the same reason requests over the send quota, and codes that fail to send, are dropped rather than refused.
+func (otpManager *otpManager) StartLogin(channel string, destination string) error {
+	user, err := findOtpLoginUser(channel, destination)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			logSecurityEvent(EventOtpLoginRequested, "channel", channel, "reason", "unknown_account")
+			return nil
 		}
-		return fmt.Errorf("failed to find OTP record: %w", result.Error)
+		return err
+	}
+	if !user.IsVerified {
+		logSecurityEvent(EventOtpLoginRequested, "user_id", user.Id, "channel", channel, "reason", "unverified")
+		return nil
 	}
 
-	if otpRecord.ExpiresAt.Before(time.Now()) {
-		return ErrOTPExpired
+	destination = user.Email
+	if channel == common.OtpChannelSMS {
+		destination = user.Phone
 	}
 
-	result = database.DB.Delete(&otpRecord)
-	if result.Error != nil {
-		log.Printf("Error deleting OTP record: %v", result.Error)
+	otpRecord, otp, err := loadOtpLimitConfig().issueOTP(user.Id, otpPurposeLogin, channel, destination)
+	if err != nil {
+		if errors.Is(err, ErrOTPRateLimited) {
+			logSecurityEvent(EventOtpRateLimited, "user_id", user.Id, "purpose", otpPurposeLogin, "channel", channel)
+		} else {
+			log.Printf("Failed to issue a login OTP to user %d: %v", user.Id, err)
+		}
+		return nil
+	}
+
+	if channel == common.OtpChannelEmail {
+		err = sendOtpLoginEmail(user, otp)
+	} else {
+		err = otpManager.sendOTP(destination, otp)
+	}
+	if err != nil {
+		if deleteErr := database.DB.Delete(otpRecord).Error; deleteErr != nil {
+			log.Printf("Failed to delete OTP record after sending failed: %v", deleteErr)
+		}
+		log.Printf("Failed to send a login OTP to user %d: %v", user.Id, err)
+		return nil
 	}
 
+	logSecurityEvent(EventOtpLoginRequested, "user_id", user.Id, "channel", channel)
 	return nil
 }
 
-func sendOTP(phoneNumber, otp string) error {
-	accountSid := os.Getenv("TWILIO_ACCOUNT_SID")
-	authToken := os.Getenv("TWILIO_AUTH_TOKEN")
-	twilioPhoneNumber := os.Getenv("TWILIO_PHONE_NUMBER")
+// Exchange a login code for the same tokens a password login issues
+func (otpManager *otpManager) CompleteLogin(channel string, destination string, otp string, client common.ClientInfo) (*models.User, *common.TokenPair, error) {
+	throttle := loadLoginThrottleConfig()
 
-	client := twilio.NewRestClientWithParams(twilio.ClientParams{
-		Username: accountSid,
-		Password: authToken,
-	})
+	if err := throttle.checkIP(client.IPAddress); err != nil {
+		logSecurityEvent(EventLoginThrottled, "ip", client.IPAddress, "reason", "ip", "method", "otp")
+		return nil, nil, err
+	}
+
+	user, err := findOtpLoginUser(channel, destination)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			recordLoginAttempt(destination, client.IPAddress, false)
+			return nil, nil, ErrInvalidOTP
+		}
+		return nil, nil, err
+	}
+
+	if err := throttle.checkAccount(user); err != nil {
+		logSecurityEvent(EventLoginThrottled, "user_id", user.Id, "ip", client.IPAddress, "reason", "account", "method", "otp")
+		return nil, nil, err
+	}
 
-	messageInput := &openapi.CreateMessageParams{}
-	messageInput.SetTo(phoneNumber)
-	messageInput.SetFrom(twilioPhoneNumber)
-	messageInput.SetBody(fmt.Sprintf("Your OTP is: %s", otp))
+	err = loadOtpLimitConfig().consumeOTP(user.Id, otpPurposeLogin, channel, otp)
+	if errors.Is(err, ErrInvalidOTP) || errors.Is(err, ErrOTPExpired) || errors.Is(err, ErrOTPAttemptsExceeded) {
+		recordLoginAttempt(user.Email, client.IPAddress, false)
+		logSecurityEvent(EventLoginFailed, "user_id", user.Id, "ip", client.IPAddress, "reason", "wrong_otp", "method", "otp")
+		if errors.Is(err, ErrOTPAttemptsExceeded) {
+			warnSecurityEvent(EventOtpAttemptsExceeded, "user_id", user.Id, "purpose", otpPurposeLogin)
+		}
 
-	_, err := client.Api.CreateMessage(messageInput)
+		locked, lockErr := throttle.recordAccountFailure(user)
+		if lockErr != nil {
+			return nil, nil, lockErr
+		}
+		if locked {
+			warnSecurityEvent(EventAccountLocked, "user_id", user.Id, "ip", client.IPAddress, "until", user.LockedUntil)
+		}
+		return nil, nil, err
+	}
 	if err != nil {
-		log.Printf("twilio error %s", err.Error())
-		return err
+		return nil, nil, err
 	}
 
-	log.Println("OTP sent successfully")
+	if err := resetAccountFailures(user); err != nil {
+		return nil, nil, err
+	}
 
-	return nil
+	// The code replaces the password, not the second factor
+	if user.TwoFactorEnabled {
+		logSecurityEvent(EventTwoFactorChallenged, "user_id", user.Id, "ip", client.IPAddress, "method", "otp")
+		return user, nil, startTwoFactorChallenge(user)
+	}
+
+	tokens, err := otpManager.tokenManager.IssueTokens(user, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recordLoginAttempt(user.Email, client.IPAddress, true)
+	logSecurityEvent(EventLoginSucceeded, "user_id", user.Id, "ip", client.IPAddress, "method", "otp")
+	return user, tokens, nil
 }
 
-func formatPhoneNumber(phoneNumber string) string {
+func findOtpLoginUser(channel string, destination string) (*models.User, error) {
+	var user models.User
+	var result *gorm.DB
+	switch channel {
+	case common.OtpChannelSMS:
+		// Only a verified number identifies an account
+		phone, err := common.NormalizePhoneNumber(destination)
+		if err != nil {
+			return nil, gorm.ErrRecordNotFound
+		}
+		result = database.DB.Where("phone = ? AND phone_verified = ?", phone, true).First(&user)
+	case common.OtpChannelEmail:
+		result = database.DB.Where("email = ?", strings.TrimSpace(destination)).First(&user)
+	default:
+		return nil, ErrUnknownOtpChannel
+	}
 
-	reg, err := regexp.Compile("[^0-9]+")
-	if err != nil {
-		log.Println(err)
-		return ""
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, result.Error
+		}
+		return nil, fmt.Errorf("failed to find user: %w", result.Error)
 	}
+	return &user, nil
+}
 
-	phoneNumber = reg.ReplaceAllString(phoneNumber, "")
+func sendOtpLoginEmail(user *models.User, otp string) error {
+	return enqueueEmail(database.DB, user.Email, "otp_login", otpLoginData{
+		Name:             user.FirstName,
+		Code:             otp,
+		ExpiresInMinutes: int(otpExpiration.Minutes()),
+	})
+}
 
-	if strings.HasPrefix(phoneNumber, "91") {
-		phoneNumber = "+" + phoneNumber
-	} else if !strings.HasPrefix(phoneNumber, "+") {
-		phoneNumber = "+" + phoneNumber
+func (otpManager *otpManager) sendOTP(phoneNumber, otp string) error {
+	err := otpManager.smsSender.Send(phoneNumber, fmt.Sprintf("Your OTP is: %s", otp))
+	if err != nil {
+		log.Printf("SMS error %s", err.Error())
+		return err
 	}
 
-	return phoneNumber
+	log.Println("OTP sent successfully")
+
+	return nil
 }
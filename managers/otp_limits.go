@@ -0,0 +1,193 @@
+package managers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"main/common"
+	"main/database"
+	"main/models"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrOTPRateLimited      = errors.New("too many OTP requests")
+	ErrOTPAttemptsExceeded = errors.New("too many wrong OTP attempts")
+)
+
+// OtpRateLimitError tells the caller when another code can be requested
+type OtpRateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (err *OtpRateLimitError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", ErrOTPRateLimited, err.RetryAfter.Round(time.Second))
+}
+
+func (err *OtpRateLimitError) Unwrap() error {
+	return ErrOTPRateLimited
+}
+
+type otpLimitConfig struct {
+	maxAttempts int
+	cooldown    time.Duration
+	maxSends    int
+	sendWindow  time.Duration
+}
+
+func loadOtpLimitConfig() otpLimitConfig {
+	return otpLimitConfig{
+		maxAttempts: common.IntFromEnv("OTP_MAX_ATTEMPTS", 5),
+		cooldown:    common.DurationFromEnv("OTP_SEND_COOLDOWN", time.Minute),
+		maxSends:    common.IntFromEnv("OTP_MAX_SENDS", 5),
+		sendWindow:  common.DurationFromEnv("OTP_SEND_WINDOW", time.Hour),
+	}
+}
+
+// Key OTPs are HMAC'd with, OTP_HMAC_KEY or else JWT_SECRET_KEY
+func otpHMACKey() string {
+	if key := os.Getenv("OTP_HMAC_KEY"); key != "" {
+		return key
+	}
+	return os.Getenv("JWT_SECRET_KEY")
+}
+
+// Refuse to start without a key for OTPs, which would make stored codes easy to brute force
+func CheckOtpHMACKey() error {
+	if otpHMACKey() == "" {
+		return errors.New("OTP_HMAC_KEY or JWT_SECRET_KEY must be set")
+	}
+	return nil
+}
+
+// Codes are short, so they are stored as an HMAC keyed with a server secret rather than a plain hash.
+// The user and purpose are part of the message so a code only matches where it was issued.
+func hashOTP(userID uint, purpose string, code string) string {
+	mac := hmac.New(sha256.New, []byte(otpHMACKey()))
+	fmt.Fprintf(mac, "%d:%s:%s", userID, purpose, code)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Refuse to send when the user or the destination is inside its cooldown or over its quota.
+// The sends are read with a locking read, which on MySQL also blocks new sends to the destination
+// until tx ends.
+func (config otpLimitConfig) checkQuota(tx *gorm.DB, userID uint, destination string) error {
+	for _, scope := range []*gorm.DB{
+		tx.Where("user_id = ?", userID),
+		tx.Where("destination = ?", destination),
+	} {
+		var sends []models.OtpSend
+		result := scope.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("created_at > ?", time.Now().Add(-config.sendWindow)).
+			Order("created_at DESC").
+			Find(&sends)
+		if result.Error != nil {
+			return fmt.Errorf("failed to count OTP sends: %w", result.Error)
+		}
+		if len(sends) == 0 {
+			continue
+		}
+
+		if wait := time.Until(sends[0].CreatedAt.Add(config.cooldown)); wait > 0 {
+			return &OtpRateLimitError{RetryAfter: wait}
+		}
+		if len(sends) >= config.maxSends {
+			// The quota frees up when the oldest send in the window leaves it
+			return &OtpRateLimitError{RetryAfter: time.Until(sends[len(sends)-1].CreatedAt.Add(config.sendWindow))}
+		}
+	}
+	return nil
+}
+
+// Store a new code for the user and purpose, replacing any earlier ones, and count it against the quotas.
+// The user is locked while the quotas are checked and the send recorded, so concurrent requests
+// are counted one after the other rather than all passing the check before any send is recorded.
+func (config otpLimitConfig) issueOTP(userID uint, purpose string, channel string, destination string) (*models.Otp, string, error) {
+	code, err := common.GenerateOTP(otpLength)
+	if err != nil {
+		return nil, "", err
+	}
+
+	otpRecord := &models.Otp{
+		UserID:      userID,
+		CodeHash:    hashOTP(userID, purpose, code),
+		Channel:     channel,
+		Purpose:     purpose,
+		Destination: destination,
+		CreatedAt:   time.Now(),
+		ExpiresAt:   time.Now().Add(otpExpiration),
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&user, userID).Error; err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+		if err := config.checkQuota(tx, userID, destination); err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ? AND purpose = ?", userID, purpose).Delete(&models.Otp{}).Error; err != nil {
+			return fmt.Errorf("failed to invalidate earlier OTPs: %w", err)
+		}
+		if err := tx.Create(otpRecord).Error; err != nil {
+			return fmt.Errorf("failed to Create Otp record: %w", err)
+		}
+		if err := tx.Create(&models.OtpSend{UserID: userID, Destination: destination}).Error; err != nil {
+			return fmt.Errorf("failed to record OTP send: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return otpRecord, code, nil
+}
+
+// Check a code against the user's current code for the purpose. A match deletes the code;
+// a miss counts an attempt and deletes the code once the attempts run out.
+func (config otpLimitConfig) consumeOTP(userID uint, purpose string, channel string, code string) error {
+	var outcome error
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var otpRecord models.Otp
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND purpose = ?", userID, purpose).
+			Order("created_at DESC").
+			First(&otpRecord)
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				outcome = ErrInvalidOTP
+				return nil
+			}
+			return fmt.Errorf("failed to find OTP record: %w", result.Error)
+		}
+
+		if otpRecord.ExpiresAt.Before(time.Now()) {
+			outcome = ErrOTPExpired
+			return tx.Delete(&otpRecord).Error
+		}
+
+		if otpRecord.Channel == channel && hmac.Equal([]byte(otpRecord.CodeHash), []byte(hashOTP(userID, purpose, code))) {
+			return tx.Delete(&otpRecord).Error
+		}
+
+		otpRecord.Attempts++
+		if otpRecord.Attempts >= config.maxAttempts {
+			outcome = ErrOTPAttemptsExceeded
+			return tx.Delete(&otpRecord).Error
+		}
+		outcome = ErrInvalidOTP
+		return tx.Model(&otpRecord).Update("attempts", otpRecord.Attempts).Error
+	})
+	if err != nil {
+		return err
+	}
+	return outcome
+}
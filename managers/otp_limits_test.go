@@ -0,0 +1,84 @@
+package managers
+
+import (
+	"errors"
+	"main/common"
+	"main/database"
+	"main/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentOtpSendsRespectQuota(t *testing.T) {
+	useTestDB(t)
+	t.Setenv("OTP_HMAC_KEY", "test-key")
+
+	user := &models.User{Email: "bob@example.com", Password: "hash", Phone: "+919876543210", IsVerified: true}
+	if err := database.DB.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	config := otpLimitConfig{maxAttempts: 5, cooldown: time.Minute, maxSends: 5, sendWindow: time.Hour}
+	const requests = 8
+	errs := make(chan error, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := config.issueOTP(user.Id, otpPurposeLogin, common.OtpChannelSMS, user.Phone)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var issued int
+	for err := range errs {
+		switch {
+		case err == nil:
+			issued++
+		case !errors.Is(err, ErrOTPRateLimited):
+			t.Fatalf("issueOTP() error = %v", err)
+		}
+	}
+	if issued != 1 {
+		t.Errorf("%d codes were issued inside one cooldown, want 1", issued)
+	}
+
+	var sends int64
+	database.DB.Model(&models.OtpSend{}).Where("user_id = ?", user.Id).Count(&sends)
+	if sends != 1 {
+		t.Errorf("%d sends were recorded, want 1", sends)
+	}
+}
+
+func TestOtpSendQuota(t *testing.T) {
+	useTestDB(t)
+
+	user := &models.User{Email: "bob@example.com", Password: "hash", IsVerified: true}
+	other := &models.User{Email: "eve@example.com", Password: "hash", IsVerified: true}
+	database.DB.Create(user)
+	database.DB.Create(other)
+
+	config := otpLimitConfig{maxAttempts: 5, cooldown: 0, maxSends: 2, sendWindow: time.Hour}
+	steps := []struct {
+		userID      uint
+		destination string
+		wantErr     bool
+	}{
+		{userID: user.Id, destination: "bob@example.com"},
+		{userID: user.Id, destination: "bob@example.com"},
+		{userID: user.Id, destination: "bob@example.com", wantErr: true},
+		// The destination has its own quota, whichever account asks
+		{userID: other.Id, destination: "bob@example.com", wantErr: true},
+		{userID: other.Id, destination: "eve@example.com"},
+	}
+	for i, step := range steps {
+		_, _, err := config.issueOTP(step.userID, otpPurposeLogin, common.OtpChannelEmail, step.destination)
+		if step.wantErr != errors.Is(err, ErrOTPRateLimited) || (!step.wantErr && err != nil) {
+			t.Errorf("send %d: issueOTP() error = %v, want rate limited %t", i+1, err, step.wantErr)
+		}
+	}
+}
@@ -0,0 +1,428 @@
+package managers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"main/common"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPaymentDeclined          = errors.New("payment declined")
+	ErrPaymentTimeout           = errors.New("payment provider timed out")
+	ErrUnknownPaymentMethod     = errors.New("unknown payment method")
+	ErrInvalidWebhookSignature  = errors.New("invalid webhook signature")
+	ErrPaymentIntentNotCaptured = errors.New("payment intent cannot be captured or refunded")
+)
+
+// Status of a payment, as stored and as reported by providers
+const (
+	PaymentStatusPending    = "pending"
+	PaymentStatusAuthorized = "authorized"
+	PaymentStatusCaptured   = "captured"
+	PaymentStatusFailed     = "failed"
+	PaymentStatusRefunded   = "refunded"
+)
+
+// Webhooks older than this are refused, so a captured request can't be replayed later
+const webhookTolerance = 5 * time.Minute
+
+type PaymentIntentRequest struct {
+	// Our reference for the payment, echoed back in webhooks
+	Reference string
+	// Amount in the currency's minor unit, such as paise
+	Amount   int64
+	Currency string
+	// Provider specific payment method. For the fake provider it scripts the outcome.
+	Method string
+}
+
+type PaymentIntent struct {
+	ID           string
+	Status       string
+	ClientSecret string
+}
+
+// PaymentWebhookEvent is a verified webhook. Status is empty for events that don't change a payment.
+type PaymentWebhookEvent struct {
+	ID        string
+	Type      string
+	Status    string
+	IntentID  string
+	Reference string
+}
+
+// PaymentProvider takes payments for orders, so checkout does not depend on one gateway
+type PaymentProvider interface {
+	Name() string
+	CreateIntent(request *PaymentIntentRequest) (*PaymentIntent, error)
+	Capture(intentID string, amount int64) (*PaymentIntent, error)
+	Refund(intentID string, amount int64) error
+	// Check the webhook's signature and decode it
+	VerifyWebhook(payload []byte, header http.Header) (*PaymentWebhookEvent, error)
+}
+
+// Select the payment provider from PAYMENT_PROVIDER ("stripe" or "fake"). The fake provider
+// captures payments without charging anyone, so it is refused in release mode.
+func NewPaymentProviderFromEnv() (PaymentProvider, error) {
+	switch provider := strings.ToLower(os.Getenv("PAYMENT_PROVIDER")); provider {
+	case "":
+		return nil, errors.New("PAYMENT_PROVIDER is required")
+	case "stripe":
+		apiURL := os.Getenv("STRIPE_API_URL")
+		if apiURL == "" {
+			apiURL = "https://api.stripe.com"
+		}
+		return NewStripePaymentProvider(os.Getenv("STRIPE_SECRET_KEY"), os.Getenv("PAYMENT_WEBHOOK_SECRET"), apiURL)
+	case "fake":
+		if common.IsReleaseMode() {
+			return nil, errors.New("the fake payment provider can't be used in release mode")
+		}
+		secret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+		if secret == "" {
+			return nil, errors.New("PAYMENT_WEBHOOK_SECRET is required for the fake payment provider")
+		}
+		log.Println("Payments use the fake provider, nobody is charged")
+		return NewFakePaymentProvider(secret), nil
+	default:
+		return nil, fmt.Errorf("unknown PAYMENT_PROVIDER %q", os.Getenv("PAYMENT_PROVIDER"))
+	}
+}
+
+// Signature header value for a webhook payload: "t=<unix time>,v1=<hex HMAC-SHA256 of "<t>.<payload>">",
+// the scheme Stripe uses and the fake provider copies
+func SignWebhookPayload(secret string, payload []byte, at time.Time) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + webhookMAC(secret, timestamp, payload)
+}
+
+func webhookMAC(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifyWebhookSignature(secret string, payload []byte, signature string) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(signature, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidWebhookSignature
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > webhookTolerance || age < -webhookTolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidWebhookSignature)
+	}
+
+	expected := webhookMAC(secret, timestamp, payload)
+	for _, candidate := range signatures {
+		if hmac.Equal([]byte(candidate), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidWebhookSignature
+}
+
+// Payment methods understood by the fake provider, each with a fixed outcome
+const (
+	FakeMethodSuccess   = "fake_success"
+	FakeMethodAuthorize = "fake_authorize"
+	FakeMethodPending   = "fake_pending"
+	FakeMethodDecline   = "fake_decline"
+	FakeMethodTimeout   = "fake_timeout"
+)
+
+type fakePaymentProvider struct {
+	webhookSecret string
+	mu            sync.Mutex
+	intents       map[string]string
+}
+
+// A provider that never leaves the process, for development and tests. The payment method scripts
+// the outcome: fake_success (the default) captures at once, fake_authorize needs a capture,
+// fake_pending waits for a webhook, fake_decline is declined and fake_timeout times out.
+// Webhooks are JSON objects with id, type, intentId and reference, signed with SignWebhookPayload
+// in the X-Fake-Signature header. Their type is the new payment status.
+func NewFakePaymentProvider(webhookSecret string) PaymentProvider {
+	return &fakePaymentProvider{webhookSecret: webhookSecret, intents: map[string]string{}}
+}
+
+func (provider *fakePaymentProvider) Name() string {
+	return "fake"
+}
+
+func (provider *fakePaymentProvider) CreateIntent(request *PaymentIntentRequest) (*PaymentIntent, error) {
+	var status string
+	switch request.Method {
+	case "", FakeMethodSuccess:
+		status = PaymentStatusCaptured
+	case FakeMethodAuthorize:
+		status = PaymentStatusAuthorized
+	case FakeMethodPending:
+		status = PaymentStatusPending
+	case FakeMethodDecline:
+		return nil, fmt.Errorf("%w: card declined by the fake provider", ErrPaymentDeclined)
+	case FakeMethodTimeout:
+		return nil, ErrPaymentTimeout
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPaymentMethod, request.Method)
+	}
+
+	intent := &PaymentIntent{
+		ID:           "fake_pi_" + request.Reference,
+		Status:       status,
+		ClientSecret: "fake_secret_" + request.Reference,
+	}
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	provider.intents[intent.ID] = status
+	return intent, nil
+}
+
+func (provider *fakePaymentProvider) Capture(intentID string, amount int64) (*PaymentIntent, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	switch provider.intents[intentID] {
+	case PaymentStatusPending, PaymentStatusAuthorized, PaymentStatusCaptured:
+		provider.intents[intentID] = PaymentStatusCaptured
+		return &PaymentIntent{ID: intentID, Status: PaymentStatusCaptured}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrPaymentIntentNotCaptured, intentID)
+	}
+}
+
+func (provider *fakePaymentProvider) Refund(intentID string, amount int64) error {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if provider.intents[intentID] != PaymentStatusCaptured {
+		return fmt.Errorf("%w: %s", ErrPaymentIntentNotCaptured, intentID)
+	}
+	provider.intents[intentID] = PaymentStatusRefunded
+	return nil
+}
+
+func (provider *fakePaymentProvider) VerifyWebhook(payload []byte, header http.Header) (*PaymentWebhookEvent, error) {
+	if err := verifyWebhookSignature(provider.webhookSecret, payload, header.Get("X-Fake-Signature")); err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		ID        string `json:"id"`
+		Type      string `json:"type"`
+		IntentID  string `json:"intentId"`
+		Reference string `json:"reference"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil || body.ID == "" {
+		return nil, fmt.Errorf("invalid fake webhook payload: %v", err)
+	}
+
+	event := &PaymentWebhookEvent{ID: body.ID, Type: body.Type, IntentID: body.IntentID, Reference: body.Reference}
+	switch body.Type {
+	case PaymentStatusAuthorized, PaymentStatusCaptured, PaymentStatusFailed, PaymentStatusRefunded:
+		event.Status = body.Type
+	}
+	return event, nil
+}
+
+type stripePaymentProvider struct {
+	secretKey     string
+	webhookSecret string
+	apiURL        string
+	client        *http.Client
+}
+
+// Take payments with Stripe PaymentIntents. apiURL is normally https://api.stripe.com.
+func NewStripePaymentProvider(secretKey, webhookSecret, apiURL string) (PaymentProvider, error) {
+	if secretKey == "" || webhookSecret == "" {
+		return nil, errors.New("STRIPE_SECRET_KEY and PAYMENT_WEBHOOK_SECRET are required for the stripe payment provider")
+	}
+
+	return &stripePaymentProvider{
+		secretKey:     secretKey,
+		webhookSecret: webhookSecret,
+		apiURL:        strings.TrimSuffix(apiURL, "/"),
+		client:        &http.Client{Timeout: 15 * time.Second},
+	}, nil
+}
+
+func (provider *stripePaymentProvider) Name() string {
+	return "stripe"
+}
+
+type stripePaymentIntent struct {
+	ID           string `json:"id"`
+	Status       string `json:"status"`
+	ClientSecret string `json:"client_secret"`
+}
+
+func (intent *stripePaymentIntent) toPaymentIntent() *PaymentIntent {
+	return &PaymentIntent{
+		ID:           intent.ID,
+		Status:       stripeIntentStatus(intent.Status),
+		ClientSecret: intent.ClientSecret,
+	}
+}
+
+func stripeIntentStatus(status string) string {
+	switch status {
+	case "succeeded":
+		return PaymentStatusCaptured
+	case "requires_capture":
+		return PaymentStatusAuthorized
+	case "canceled":
+		return PaymentStatusFailed
+	default:
+		return PaymentStatusPending
+	}
+}
+
+// POST a form to the Stripe API and decode the JSON answer into out
+func (provider *stripePaymentProvider) post(path string, form url.Values, idempotencyKey string, out interface{}) error {
+	request, err := http.NewRequest(http.MethodPost, provider.apiURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to build Stripe request: %w", err)
+	}
+	request.SetBasicAuth(provider.secretKey, "")
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		request.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	response, err := provider.client.Do(request)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return fmt.Errorf("%w: %v", ErrPaymentTimeout, err)
+		}
+		return fmt.Errorf("failed to reach Stripe: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read Stripe response: %w", err)
+	}
+
+	if response.StatusCode == http.StatusPaymentRequired {
+		var failure struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(body, &failure)
+		return fmt.Errorf("%w: %s", ErrPaymentDeclined, failure.Error.Message)
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("Stripe responded %d: %s", response.StatusCode, strings.TrimSpace(string(body[:min(len(body), 512)])))
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(body, out); err != nil {
+			return fmt.Errorf("failed to decode Stripe response: %w", err)
+		}
+	}
+	return nil
+}
+
+func (provider *stripePaymentProvider) CreateIntent(request *PaymentIntentRequest) (*PaymentIntent, error) {
+	form := url.Values{
+		"amount":              {strconv.FormatInt(request.Amount, 10)},
+		"currency":            {strings.ToLower(request.Currency)},
+		"metadata[reference]": {request.Reference},
+	}
+	if request.Method != "" {
+		// Confirm server side with a saved payment method, otherwise the client confirms with the secret
+		form.Set("payment_method", request.Method)
+		form.Set("confirm", "true")
+	} else {
+		form.Set("automatic_payment_methods[enabled]", "true")
+	}
+
+	var intent stripePaymentIntent
+	if err := provider.post("/v1/payment_intents", form, request.Reference, &intent); err != nil {
+		return nil, err
+	}
+	return intent.toPaymentIntent(), nil
+}
+
+func (provider *stripePaymentProvider) Capture(intentID string, amount int64) (*PaymentIntent, error) {
+	form := url.Values{"amount_to_capture": {strconv.FormatInt(amount, 10)}}
+
+	var intent stripePaymentIntent
+	if err := provider.post("/v1/payment_intents/"+url.PathEscape(intentID)+"/capture", form, "capture-"+intentID, &intent); err != nil {
+		return nil, err
+	}
+	return intent.toPaymentIntent(), nil
+}
+
+func (provider *stripePaymentProvider) Refund(intentID string, amount int64) error {
+	form := url.Values{
+		"payment_intent": {intentID},
+		"amount":         {strconv.FormatInt(amount, 10)},
+	}
+	return provider.post("/v1/refunds", form, "refund-"+intentID, nil)
+}
+
+func (provider *stripePaymentProvider) VerifyWebhook(payload []byte, header http.Header) (*PaymentWebhookEvent, error) {
+	if err := verifyWebhookSignature(provider.webhookSecret, payload, header.Get("Stripe-Signature")); err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				ID            string            `json:"id"`
+				PaymentIntent string            `json:"payment_intent"`
+				Metadata      map[string]string `json:"metadata"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil || body.ID == "" {
+		return nil, fmt.Errorf("invalid Stripe webhook payload: %v", err)
+	}
+
+	object := body.Data.Object
+	event := &PaymentWebhookEvent{ID: body.ID, Type: body.Type, IntentID: object.ID, Reference: object.Metadata["reference"]}
+	switch body.Type {
+	case "payment_intent.amount_capturable_updated":
+		event.Status = PaymentStatusAuthorized
+	case "payment_intent.succeeded":
+		event.Status = PaymentStatusCaptured
+	case "payment_intent.payment_failed":
+		event.Status = PaymentStatusFailed
+	case "charge.refunded":
+		// Charges point at their payment intent
+		event.Status = PaymentStatusRefunded
+		event.IntentID = object.PaymentIntent
+	}
+	return event, nil
+}
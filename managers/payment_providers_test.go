@@ -0,0 +1,51 @@
+package managers
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	secret := "whsec_test"
+	payload := []byte(`{"id":"evt_1","type":"captured"}`)
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	valid := webhookMAC(secret, timestamp, payload)
+
+	tests := []struct {
+		name      string
+		payload   []byte
+		signature string
+		wantErr   bool
+	}{
+		{name: "valid", payload: payload, signature: SignWebhookPayload(secret, payload, now)},
+		{name: "valid among several signatures", payload: payload, signature: "t=" + timestamp + ",v1=0bad,v1=" + valid},
+		{name: "spaces around parts", payload: payload, signature: " t=" + timestamp + " , v1=" + valid},
+		{name: "just inside the tolerance", payload: payload, signature: SignWebhookPayload(secret, payload, now.Add(-webhookTolerance+time.Minute))},
+		{name: "wrong secret", payload: payload, signature: SignWebhookPayload("other", payload, now), wantErr: true},
+		{name: "altered payload", payload: []byte(`{"id":"evt_1","type":"refunded"}`), signature: SignWebhookPayload(secret, payload, now), wantErr: true},
+		{name: "too old", payload: payload, signature: SignWebhookPayload(secret, payload, now.Add(-webhookTolerance-time.Minute)), wantErr: true},
+		{name: "too far in the future", payload: payload, signature: SignWebhookPayload(secret, payload, now.Add(webhookTolerance+time.Minute)), wantErr: true},
+		{name: "no timestamp", payload: payload, signature: "v1=" + valid, wantErr: true},
+		{name: "timestamp not a number", payload: payload, signature: "t=soon,v1=" + valid, wantErr: true},
+		{name: "no signature", payload: payload, signature: "t=" + timestamp, wantErr: true},
+		{name: "empty header", payload: payload, signature: "", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifyWebhookSignature(secret, test.payload, test.signature)
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidWebhookSignature) {
+					t.Fatalf("verifyWebhookSignature() error = %v, want ErrInvalidWebhookSignature", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyWebhookSignature() error = %v", err)
+			}
+		})
+	}
+}
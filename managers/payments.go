@@ -0,0 +1,435 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"main/common"
+	"main/database"
+	"main/models"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPaymentNotFound      = errors.New("payment not found")
+	ErrOrderNotPayable      = errors.New("order is not awaiting payment")
+	ErrPaymentInProgress    = errors.New("order already has a payment in progress")
+	ErrPaymentNotRefundable = errors.New("only captured payments can be refunded")
+	ErrOrderNotRefundable   = errors.New("order can't be refunded in its current status")
+
+	// Another refund got to the payment first
+	errRefundNotDue = errors.New("payment is no longer due a refund")
+)
+
+// Statuses a payment can move to. A failed payment can still be captured when the provider
+// reports success after the request timed out.
+var paymentTransitions = map[string][]string{
+	PaymentStatusPending:    {PaymentStatusAuthorized, PaymentStatusCaptured, PaymentStatusFailed},
+	PaymentStatusAuthorized: {PaymentStatusCaptured, PaymentStatusFailed},
+	PaymentStatusFailed:     {PaymentStatusAuthorized, PaymentStatusCaptured},
+	PaymentStatusCaptured:   {PaymentStatusRefunded},
+	PaymentStatusRefunded:   {},
+}
+
+func canTransitionPayment(from string, to string) bool {
+	for _, next := range paymentTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+type PaymentManager interface {
+	Pay(orderID uint, paymentData *common.PaymentInput) (*models.Payment, error)
+	Refund(paymentID uint, actorID uint) (*models.Payment, error)
+	HandleWebhook(payload []byte, header http.Header) error
+	// Order event handler refunding what was captured for orders that get cancelled
+	RefundCancelledOrder(event OrderEvent)
+}
+
+type paymentManager struct {
+	provider PaymentProvider
+}
+
+func NewPaymentManager(provider PaymentProvider) PaymentManager {
+	return &paymentManager{provider: provider}
+}
+
+// Our reference for a payment, sent to the provider and echoed back in its webhooks
+func paymentReference(paymentID uint) string {
+	return "pay_" + strconv.FormatUint(uint64(paymentID), 10)
+}
+
+// Start a payment attempt for an order awaiting payment. Payments the provider completes at once
+// mark the order paid, the others are completed by webhook.
+func (paymentManager *paymentManager) Pay(orderID uint, paymentData *common.PaymentInput) (*models.Payment, error) {
+	var payment models.Payment
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var order models.Order
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID)
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return ErrOrderNotFound
+			}
+			return fmt.Errorf("failed to find order: %w", result.Error)
+		}
+		if order.Status != OrderStatusPendingPayment {
+			return ErrOrderNotPayable
+		}
+		// The order is locked, so a second attempt waits here until the first is recorded
+		var inProgress int64
+		err := tx.Model(&models.Payment{}).
+			Where("order_id = ? AND status IN ?", order.Id, []string{PaymentStatusPending, PaymentStatusAuthorized, PaymentStatusCaptured}).
+			Count(&inProgress).Error
+		if err != nil {
+			return fmt.Errorf("failed to check payments: %w", err)
+		}
+		if inProgress > 0 {
+			return ErrPaymentInProgress
+		}
+
+		payment = models.Payment{
+			OrderID:  order.Id,
+			Provider: paymentManager.provider.Name(),
+			Method:   paymentData.Method,
+			Status:   PaymentStatusPending,
+			Amount:   order.Total,
+		}
+		if err := tx.Create(&payment).Error; err != nil {
+			return fmt.Errorf("failed to create payment: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	intent, err := paymentManager.provider.CreateIntent(&PaymentIntentRequest{
+		Reference: paymentReference(payment.Id),
+		Amount:    payment.Amount.Minor,
+		Currency:  payment.Amount.Currency,
+		Method:    paymentData.Method,
+	})
+	if err != nil {
+		// A provider that still charges after timing out reports it by webhook, which captures the payment
+		if _, settleErr := settlePayment(payment.Id, "", PaymentStatusFailed, truncate(err.Error(), 255), SystemActor()); settleErr != nil {
+			log.Printf("Failed to record failure of payment %d: %v", payment.Id, settleErr)
+		}
+		return nil, err
+	}
+
+	if intent.Status == PaymentStatusAuthorized {
+		// Left authorized when the capture fails, the provider's webhook retries it
+		if captured, err := paymentManager.provider.Capture(intent.ID, payment.Amount.Minor); err != nil {
+			log.Printf("Failed to capture payment %d: %v", payment.Id, err)
+		} else {
+			intent.Status = captured.Status
+		}
+	}
+
+	settled, err := settlePayment(payment.Id, intent.ID, intent.Status, "", SystemActor())
+	if err != nil {
+		return nil, err
+	}
+	settled = paymentManager.refundDue(settled)
+	settled.ClientSecret = intent.ClientSecret
+	return settled, nil
+}
+
+// Refund a captured payment in full and mark its order refunded. Orders being packed or shipped
+// have to be delivered or cancelled first, so the order and its payment stay consistent.
+func (paymentManager *paymentManager) Refund(paymentID uint, actorID uint) (*models.Payment, error) {
+	return paymentManager.refund(paymentID, OrderActor{Kind: OrderActorAdmin, UserID: actorID}, func(payment *models.Payment, order *models.Order) error {
+		if payment.Status != PaymentStatusCaptured {
+			return ErrPaymentNotRefundable
+		}
+		if order.Status != OrderStatusRefunded && order.Status != OrderStatusCancelled && !canTransitionOrder(order.Status, OrderStatusRefunded) {
+			return ErrOrderNotRefundable
+		}
+		return nil
+	})
+}
+
+// Apply a verified provider webhook. Every event is applied once, redeliveries are acknowledged
+// and ignored, and events for unknown payments are acknowledged so the provider stops sending them.
+func (paymentManager *paymentManager) HandleWebhook(payload []byte, header http.Header) error {
+	event, err := paymentManager.provider.VerifyWebhook(payload, header)
+	if err != nil {
+		return err
+	}
+	if event.Status == "" {
+		return nil
+	}
+
+	payment, err := findWebhookPayment(event)
+	if err != nil {
+		if errors.Is(err, ErrPaymentNotFound) {
+			log.Printf("Ignoring %s webhook %s for an unknown payment", paymentManager.provider.Name(), event.ID)
+			return nil
+		}
+		return err
+	}
+
+	status := event.Status
+	if status == PaymentStatusAuthorized && canTransitionPayment(payment.Status, PaymentStatusCaptured) {
+		// A failed capture fails the webhook, so the provider delivers it again
+		captured, err := paymentManager.provider.Capture(event.IntentID, payment.Amount.Minor)
+		if err != nil {
+			return err
+		}
+		status = captured.Status
+	}
+
+	var settled *models.Payment
+	var orderEvents []OrderEvent
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		record := &models.PaymentEvent{
+			Provider:  paymentManager.provider.Name(),
+			EventID:   event.ID,
+			Type:      truncate(event.Type, 100),
+			PaymentID: &payment.Id,
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+		if result.Error != nil {
+			return fmt.Errorf("failed to record payment event: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		var err error
+		settled, orderEvents, err = applyPaymentStatus(tx, payment.Id, event.IntentID, status, "", SystemActor())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, orderEvent := range orderEvents {
+		publishOrderEvent(orderEvent)
+	}
+	if settled != nil {
+		paymentManager.refundDue(settled)
+	}
+	return nil
+}
+
+// Refund the captured payments of an order cancelled after it was paid
+func (paymentManager *paymentManager) RefundCancelledOrder(event OrderEvent) {
+	if event.To != OrderStatusCancelled {
+		return
+	}
+
+	var captured []models.Payment
+	result := database.DB.Where("order_id = ? AND status = ?", event.OrderID, PaymentStatusCaptured).Find(&captured)
+	if result.Error != nil {
+		log.Printf("Failed to find payments of cancelled order %d: %v", event.OrderID, result.Error)
+		return
+	}
+	for i := range captured {
+		payment := &captured[i]
+		// Flagged first, so a refund the provider refuses is not forgotten
+		if err := database.DB.Model(payment).Update("refund_due", true).Error; err != nil {
+			log.Printf("Failed to flag payment %d of cancelled order %d for refund: %v", payment.Id, event.OrderID, err)
+			continue
+		}
+		payment.RefundDue = true
+		paymentManager.refundDue(payment)
+	}
+}
+
+// Refund a payment flagged as captured for a cancelled order. A refund the provider refuses
+// leaves the flag set, so the payment can be found and refunded by hand.
+func (paymentManager *paymentManager) refundDue(payment *models.Payment) *models.Payment {
+	if !payment.RefundDue || payment.Status != PaymentStatusCaptured {
+		return payment
+	}
+
+	refunded, err := paymentManager.refund(payment.Id, SystemActor(), func(payment *models.Payment, order *models.Order) error {
+		if !payment.RefundDue || payment.Status != PaymentStatusCaptured {
+			return errRefundNotDue
+		}
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, errRefundNotDue) {
+			log.Printf("Payment %d of cancelled order %d is still to be refunded: %v", payment.Id, payment.OrderID, err)
+		}
+		return payment
+	}
+	return refunded
+}
+
+// Refund a payment at the provider and mark it refunded, once check accepts the payment and its
+// order. Both stay locked until the refund is recorded, so a concurrent refund of the same payment
+// waits and is then turned away by its check instead of reaching the provider a second time.
+func (paymentManager *paymentManager) refund(paymentID uint, actor OrderActor, check func(payment *models.Payment, order *models.Order) error) (*models.Payment, error) {
+	var refunded *models.Payment
+	var orderEvents []OrderEvent
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var payment models.Payment
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, paymentID)
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return ErrPaymentNotFound
+			}
+			return fmt.Errorf("failed to find payment: %w", result.Error)
+		}
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, payment.OrderID).Error; err != nil {
+			return fmt.Errorf("failed to find order: %w", err)
+		}
+		if err := check(&payment, &order); err != nil {
+			return err
+		}
+
+		if err := paymentManager.provider.Refund(payment.ProviderRef, payment.Amount.Minor); err != nil {
+			return err
+		}
+
+		var err error
+		refunded, orderEvents, err = applyPaymentStatus(tx, payment.Id, "", PaymentStatusRefunded, "", actor)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, orderEvent := range orderEvents {
+		publishOrderEvent(orderEvent)
+	}
+	return refunded, nil
+}
+
+// The payment a webhook is about, by the provider's ID or else by our reference
+func findWebhookPayment(event *PaymentWebhookEvent) (*models.Payment, error) {
+	var payment models.Payment
+	query := database.DB.Where("provider_ref = ? AND provider_ref <> ''", event.IntentID)
+	if id, err := strconv.ParseUint(strings.TrimPrefix(event.Reference, "pay_"), 10, 64); err == nil && strings.HasPrefix(event.Reference, "pay_") {
+		query = query.Or("id = ?", id)
+	}
+
+	result := query.Order("id").Limit(1).Find(&payment)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to find payment: %w", result.Error)
+	}
+	if payment.Id == 0 {
+		return nil, ErrPaymentNotFound
+	}
+	return &payment, nil
+}
+
+// Move a payment to a new status and publish the order changes that follow
+func settlePayment(paymentID uint, providerRef string, status string, failureReason string, actor OrderActor) (*models.Payment, error) {
+	var payment *models.Payment
+	var orderEvents []OrderEvent
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		payment, orderEvents, err = applyPaymentStatus(tx, paymentID, providerRef, status, failureReason, actor)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, orderEvent := range orderEvents {
+		publishOrderEvent(orderEvent)
+	}
+	return payment, nil
+}
+
+// Move a locked payment to a new status inside tx. A captured payment marks its order paid and a
+// refunded one marks it refunded. Repeated and out of order updates leave the payment as it is.
+func applyPaymentStatus(tx *gorm.DB, paymentID uint, providerRef string, status string, failureReason string, actor OrderActor) (*models.Payment, []OrderEvent, error) {
+	var payment models.Payment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, paymentID).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to find payment: %w", err)
+	}
+
+	updates := map[string]interface{}{}
+	if providerRef != "" && payment.ProviderRef == "" {
+		updates["provider_ref"] = providerRef
+	}
+
+	changed := status != payment.Status && canTransitionPayment(payment.Status, status)
+	if changed {
+		updates["status"] = status
+		updates["failure_reason"] = failureReason
+		if status == PaymentStatusRefunded {
+			updates["refund_due"] = false
+		}
+	} else if status != payment.Status {
+		log.Printf("Ignoring change of payment %d from %s to %s", payment.Id, payment.Status, status)
+	}
+
+	if len(updates) > 0 {
+		if err := tx.Model(&payment).Updates(updates).Error; err != nil {
+			return nil, nil, fmt.Errorf("failed to update payment: %w", err)
+		}
+	}
+	if !changed {
+		return &payment, nil, nil
+	}
+
+	var orderEvents []OrderEvent
+	switch status {
+	case PaymentStatusCaptured:
+		event, err := followPayment(tx, &payment, OrderStatusPaid, actor, "payment "+paymentReference(payment.Id)+" captured")
+		if err != nil {
+			return nil, nil, err
+		}
+		if event != nil {
+			orderEvents = append(orderEvents, *event)
+		}
+	case PaymentStatusRefunded:
+		event, err := followPayment(tx, &payment, OrderStatusRefunded, actor, "payment "+paymentReference(payment.Id)+" refunded")
+		if err != nil {
+			return nil, nil, err
+		}
+		if event != nil {
+			orderEvents = append(orderEvents, *event)
+		}
+	}
+	return &payment, orderEvents, nil
+}
+
+// Move the payment's order to the status a payment change implies, when the lifecycle allows it
+func followPayment(tx *gorm.DB, payment *models.Payment, to string, actor OrderActor, reason string) (*OrderEvent, error) {
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, payment.OrderID).Error; err != nil {
+		return nil, fmt.Errorf("failed to find order: %w", err)
+	}
+
+	// Cancelled orders stay cancelled when their payment is refunded
+	if order.Status == to || (to == OrderStatusRefunded && order.Status == OrderStatusCancelled) {
+		return nil, nil
+	}
+	if to == OrderStatusPaid && order.Status == OrderStatusCancelled {
+		// Paid after the order was cancelled, so the money goes back
+		log.Printf("Payment %d was captured for cancelled order %d and will be refunded", payment.Id, order.Id)
+		if err := tx.Model(payment).Update("refund_due", true).Error; err != nil {
+			return nil, fmt.Errorf("failed to flag payment for refund: %w", err)
+		}
+		payment.RefundDue = true
+		return nil, nil
+	}
+	if !canTransitionOrder(order.Status, to) {
+		log.Printf("Payment %d is %s but order %d is %s and was left as it is", payment.Id, payment.Status, order.Id, order.Status)
+		return nil, nil
+	}
+
+	event, err := transitionOrder(tx, &order, to, actor, reason)
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
@@ -0,0 +1,222 @@
+package managers
+
+import (
+	"encoding/json"
+	"errors"
+	"main/common"
+	"main/database"
+	"main/models"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A provider whose refunds are counted and can be held until the test lets them finish
+type stubPaymentProvider struct {
+	mu            sync.Mutex
+	refunds       int
+	refundStarted chan struct{}
+	releaseRefund chan struct{}
+}
+
+func (provider *stubPaymentProvider) Name() string { return "stub" }
+
+func (provider *stubPaymentProvider) CreateIntent(request *PaymentIntentRequest) (*PaymentIntent, error) {
+	return &PaymentIntent{ID: "pi_" + request.Reference, Status: PaymentStatusCaptured}, nil
+}
+
+func (provider *stubPaymentProvider) Capture(intentID string, amount int64) (*PaymentIntent, error) {
+	return &PaymentIntent{ID: intentID, Status: PaymentStatusCaptured}, nil
+}
+
+func (provider *stubPaymentProvider) Refund(intentID string, amount int64) error {
+	provider.mu.Lock()
+	provider.refunds++
+	provider.mu.Unlock()
+	if provider.refundStarted != nil {
+		provider.refundStarted <- struct{}{}
+		<-provider.releaseRefund
+	}
+	return nil
+}
+
+func (provider *stubPaymentProvider) VerifyWebhook(payload []byte, header http.Header) (*PaymentWebhookEvent, error) {
+	return nil, errors.New("stub provider does not send webhooks")
+}
+
+func (provider *stubPaymentProvider) refundCount() int {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	return provider.refunds
+}
+
+// An order in the given status with a captured payment for its total
+func createPaidOrder(t *testing.T, status string) (*models.Order, *models.Payment) {
+	t.Helper()
+
+	total := common.NewMoney(50000, "INR")
+	order := &models.Order{UserID: 1, Status: status, Subtotal: total, Total: total}
+	if err := database.DB.Create(order).Error; err != nil {
+		t.Fatalf("failed to create order: %v", err)
+	}
+	payment := &models.Payment{OrderID: order.Id, Provider: "stub", ProviderRef: "pi_test", Status: PaymentStatusCaptured, Amount: total}
+	if err := database.DB.Create(payment).Error; err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+	return order, payment
+}
+
+func TestRefundOrderStatus(t *testing.T) {
+	tests := []struct {
+		orderStatus string
+		wantErr     error
+		wantOrder   string
+	}{
+		{orderStatus: OrderStatusPaid, wantOrder: OrderStatusRefunded},
+		{orderStatus: OrderStatusDelivered, wantOrder: OrderStatusRefunded},
+		{orderStatus: OrderStatusCancelled, wantOrder: OrderStatusCancelled},
+		{orderStatus: OrderStatusPacked, wantErr: ErrOrderNotRefundable, wantOrder: OrderStatusPacked},
+		{orderStatus: OrderStatusShipped, wantErr: ErrOrderNotRefundable, wantOrder: OrderStatusShipped},
+	}
+
+	for _, test := range tests {
+		t.Run(test.orderStatus, func(t *testing.T) {
+			useTestDB(t)
+			provider := &stubPaymentProvider{}
+			order, payment := createPaidOrder(t, test.orderStatus)
+
+			_, err := NewPaymentManager(provider).Refund(payment.Id, 1)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Refund() error = %v, want %v", err, test.wantErr)
+			}
+
+			wantRefunds, wantPayment := 1, PaymentStatusRefunded
+			if test.wantErr != nil {
+				wantRefunds, wantPayment = 0, PaymentStatusCaptured
+			}
+			if got := provider.refundCount(); got != wantRefunds {
+				t.Errorf("provider refunds = %d, want %d", got, wantRefunds)
+			}
+			database.DB.First(payment, payment.Id)
+			if payment.Status != wantPayment {
+				t.Errorf("payment status = %s, want %s", payment.Status, wantPayment)
+			}
+			database.DB.First(order, order.Id)
+			if order.Status != test.wantOrder {
+				t.Errorf("order status = %s, want %s", order.Status, test.wantOrder)
+			}
+		})
+	}
+}
+
+func TestConcurrentRefundsReachProviderOnce(t *testing.T) {
+	useTestDB(t)
+	provider := &stubPaymentProvider{refundStarted: make(chan struct{}, 2), releaseRefund: make(chan struct{})}
+	paymentManager := NewPaymentManager(provider)
+	_, payment := createPaidOrder(t, OrderStatusPaid)
+
+	errs := make(chan error, 2)
+	refund := func() {
+		_, err := paymentManager.Refund(payment.Id, 1)
+		errs <- err
+	}
+
+	go refund()
+	<-provider.refundStarted
+	// The second refund starts while the first is at the provider
+	go refund()
+	time.Sleep(50 * time.Millisecond)
+	close(provider.releaseRefund)
+
+	var refunded, rejected int
+	for i := 0; i < 2; i++ {
+		switch err := <-errs; {
+		case err == nil:
+			refunded++
+		case errors.Is(err, ErrPaymentNotRefundable):
+			rejected++
+		default:
+			t.Fatalf("Refund() error = %v", err)
+		}
+	}
+	if refunded != 1 || rejected != 1 {
+		t.Errorf("%d refunds went through and %d were rejected, want 1 and 1", refunded, rejected)
+	}
+	if got := provider.refundCount(); got != 1 {
+		t.Errorf("provider refunds = %d, want 1", got)
+	}
+}
+
+func TestHandleWebhookAppliesEachEventOnce(t *testing.T) {
+	useTestDB(t)
+	const secret = "whsec_test"
+	paymentManager := NewPaymentManager(NewFakePaymentProvider(secret))
+
+	total := common.NewMoney(50000, "INR")
+	order := &models.Order{UserID: 1, Status: OrderStatusPendingPayment, Subtotal: total, Total: total}
+	if err := database.DB.Create(order).Error; err != nil {
+		t.Fatalf("failed to create order: %v", err)
+	}
+	payment, err := paymentManager.Pay(order.Id, &common.PaymentInput{Method: FakeMethodPending})
+	if err != nil {
+		t.Fatalf("Pay() error = %v", err)
+	}
+
+	deliver := func(id string, status string, intentID string, reference string) error {
+		payload, _ := json.Marshal(map[string]string{"id": id, "type": status, "intentId": intentID, "reference": reference})
+		header := http.Header{}
+		header.Set("X-Fake-Signature", SignWebhookPayload(secret, payload, time.Now()))
+		return paymentManager.HandleWebhook(payload, header)
+	}
+	intentID, reference := payment.ProviderRef, paymentReference(payment.Id)
+
+	steps := []struct {
+		name        string
+		eventID     string
+		status      string
+		intentID    string
+		reference   string
+		wantPayment string
+		wantEvents  int64
+	}{
+		{name: "capture", eventID: "evt_1", status: PaymentStatusCaptured, intentID: intentID, reference: reference, wantPayment: PaymentStatusCaptured, wantEvents: 1},
+		{name: "redelivered capture", eventID: "evt_1", status: PaymentStatusCaptured, intentID: intentID, reference: reference, wantPayment: PaymentStatusCaptured, wantEvents: 1},
+		{name: "second event for the same capture", eventID: "evt_2", status: PaymentStatusCaptured, intentID: intentID, reference: reference, wantPayment: PaymentStatusCaptured, wantEvents: 2},
+		{name: "failure after the capture", eventID: "evt_3", status: PaymentStatusFailed, intentID: intentID, reference: reference, wantPayment: PaymentStatusCaptured, wantEvents: 3},
+		{name: "unknown payment", eventID: "evt_4", status: PaymentStatusCaptured, intentID: "fake_pi_unknown", reference: "pay_999999", wantPayment: PaymentStatusCaptured, wantEvents: 3},
+	}
+	for _, step := range steps {
+		if err := deliver(step.eventID, step.status, step.intentID, step.reference); err != nil {
+			t.Fatalf("%s: HandleWebhook() error = %v", step.name, err)
+		}
+
+		var stored models.Payment
+		database.DB.First(&stored, payment.Id)
+		if stored.Status != step.wantPayment {
+			t.Errorf("%s: payment status = %s, want %s", step.name, stored.Status, step.wantPayment)
+		}
+		var events int64
+		database.DB.Model(&models.PaymentEvent{}).Count(&events)
+		if events != step.wantEvents {
+			t.Errorf("%s: %d payment events recorded, want %d", step.name, events, step.wantEvents)
+		}
+	}
+
+	database.DB.First(order, order.Id)
+	if order.Status != OrderStatusPaid {
+		t.Errorf("order status = %s, want %s", order.Status, OrderStatusPaid)
+	}
+	var paidTransitions int64
+	database.DB.Model(&models.OrderTransition{}).Where("order_id = ? AND to_status = ?", order.Id, OrderStatusPaid).Count(&paidTransitions)
+	if paidTransitions != 1 {
+		t.Errorf("order was marked paid %d times, want once", paidTransitions)
+	}
+
+	payload := []byte(`{"id":"evt_5","type":"refunded","intentId":"` + intentID + `"}`)
+	header := http.Header{}
+	header.Set("X-Fake-Signature", SignWebhookPayload("other", payload, time.Now()))
+	if err := paymentManager.HandleWebhook(payload, header); !errors.Is(err, ErrInvalidWebhookSignature) {
+		t.Errorf("HandleWebhook() with a bad signature error = %v, want %v", err, ErrInvalidWebhookSignature)
+	}
+}
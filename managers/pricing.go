@@ -0,0 +1,674 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"main/common"
+	"main/models"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CartLine is a cart row with its price as of now
+type CartLine struct {
+	models.Cart
+	UnitPrice common.Money `json:"unitPrice"`
+	LineTotal common.Money `json:"lineTotal"`
+	Discount  common.Money `json:"discount"`
+	Tax       common.Money `json:"tax"`
+	// The value Tax is charged on, the line total after discounts without tax
+	TaxableValue common.Money   `json:"taxableValue"`
+	HSNCode      string         `json:"hsnCode,omitempty"`
+	Taxes        []TaxComponent `json:"taxes,omitempty"`
+	// How Discount is made up, one allocation per promotion or coupon
+	Discounts []DiscountAllocation `json:"discounts,omitempty"`
+	// The product's price changed since the line was added
+	PriceChanged      bool          `json:"priceChanged,omitempty"`
+	PreviousUnitPrice *common.Money `json:"previousUnitPrice,omitempty"`
+	// The product was deleted or has no valid price, so the line is left out of the totals
+	Unavailable bool `json:"unavailable,omitempty"`
+	// The parents of the product's category, nearest first
+	parentCategoryIDs []uint
+}
+
+// CartSummary is what a cart costs, computed the same way for the cart view and for checkout
+type CartSummary struct {
+	Items    []CartLine   `json:"items"`
+	Subtotal common.Money `json:"subtotal"`
+	Discount common.Money `json:"discount"`
+	Tax      common.Money `json:"tax"`
+	Shipping common.Money `json:"shipping"`
+	Total    common.Money `json:"total"`
+	// Prices include tax, so Total does not add Tax on top
+	TaxInclusive bool `json:"taxInclusive"`
+	// Tax by kind and rate over all lines
+	Taxes []TaxComponent `json:"taxes,omitempty"`
+	// Automatic promotions the cart qualifies for, with what each takes off
+	Promotions []DiscountAllocation `json:"promotions,omitempty"`
+	Coupon     *AppliedCoupon       `json:"coupon,omitempty"`
+}
+
+// A promotion's or coupon's share of a line, or its whole discount on the cart
+type DiscountAllocation struct {
+	Source   string       `json:"source"`
+	SourceID uint         `json:"sourceID"`
+	Label    string       `json:"label"`
+	Amount   common.Money `json:"amount"`
+}
+
+// The coupon applied to the cart. Error says why it doesn't currently apply, in which case it
+// takes nothing off and checkout is refused until it is removed or the cart changes.
+type AppliedCoupon struct {
+	Code         string       `json:"code"`
+	Kind         string       `json:"kind"`
+	Discount     common.Money `json:"discount"`
+	FreeShipping bool         `json:"freeShipping,omitempty"`
+	Error        string       `json:"error,omitempty"`
+	coupon       *models.Coupon
+	err          error
+}
+
+// Everything the pricing steps may look at besides the lines
+type pricingInput struct {
+	db *gorm.DB
+	// Lock the applied coupon so its usage limits hold until the order is placed
+	lock            bool
+	now             time.Time
+	userID          uint
+	shippingAddress models.Address
+	config          pricingConfig
+	// The categories of the lines' products with all their parents
+	categoryByID map[uint]*models.Category
+}
+
+// A step of the pricing pipeline. Steps run in order over the summary, each filling in its part.
+type pricingStep func(summary *CartSummary, input *pricingInput) error
+
+var pricingSteps = []pricingStep{
+	priceLines,
+	loadLineCategories,
+	applyPromotions,
+	applyCoupon,
+	applyTax,
+	estimateShipping,
+	applyFreeShipping,
+	totalCart,
+}
+
+type pricingConfig struct {
+	// Tax rate of products without one of their own or from their category, in hundredths of a percent
+	taxRateBasisPoints int
+	pricesIncludeTax   bool
+	shippingFee        string
+	freeShippingOver   string
+}
+
+func loadPricingConfig() pricingConfig {
+	config := pricingConfig{
+		shippingFee:      strings.TrimSpace(os.Getenv("SHIPPING_FEE")),
+		freeShippingOver: strings.TrimSpace(os.Getenv("FREE_SHIPPING_OVER")),
+		pricesIncludeTax: common.BoolFromEnv("PRICES_INCLUDE_TAX", false),
+	}
+	if value := os.Getenv("TAX_RATE"); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 100 {
+			log.Printf("Invalid TAX_RATE value is %s. Using default of 0.", value)
+		} else {
+			config.taxRateBasisPoints = int(math.Round(rate * 100))
+		}
+	}
+	return config
+}
+
+// Read an amount from the configuration in the cart's currency, zero when unset or invalid
+func (config pricingConfig) amount(key string, value string, currency string) common.Money {
+	if value == "" {
+		return common.NewMoney(0, currency)
+	}
+	money, err := common.ParseMoney(value, currency)
+	if err != nil || money.IsNegative() {
+		log.Printf("Invalid %s value is %s. Using 0.", key, value)
+		return common.NewMoney(0, currency)
+	}
+	return money
+}
+
+// Price cart rows, with Product and Variant preloaded, for delivery to the address
+func priceCart(db *gorm.DB, userID uint, shippingAddress models.Address, cartItems []models.Cart, lock bool) (*CartSummary, error) {
+	summary := &CartSummary{Items: make([]CartLine, 0, len(cartItems))}
+	for _, cartItem := range cartItems {
+		summary.Items = append(summary.Items, CartLine{Cart: cartItem})
+	}
+
+	input := &pricingInput{
+		db:              db,
+		lock:            lock,
+		now:             time.Now(),
+		userID:          userID,
+		shippingAddress: shippingAddress,
+		config:          loadPricingConfig(),
+	}
+	for _, step := range pricingSteps {
+		if err := step(summary, input); err != nil {
+			return nil, err
+		}
+	}
+	return summary, nil
+}
+
+// The currency all lines are priced in, DefaultCurrency for an empty cart
+func (summary *CartSummary) currency() string {
+	for _, line := range summary.Items {
+		if !line.Unavailable {
+			return line.UnitPrice.Currency
+		}
+	}
+	return common.DefaultCurrency()
+}
+
+// Current unit prices and line totals, flagging prices that changed since the line was added
+func priceLines(summary *CartSummary, input *pricingInput) error {
+	currency := ""
+	for i := range summary.Items {
+		line := &summary.Items[i]
+
+		// Deleted products are not preloaded, and prices the migration could not read have no currency
+		price := line.Product.Price
+		if line.Product.Id == 0 || price.Currency == "" || price.IsNegative() {
+			line.Unavailable = true
+			continue
+		}
+		if currency == "" {
+			currency = price.Currency
+		} else if price.Currency != currency {
+			return fmt.Errorf("%w: %s and %s", common.ErrCurrencyMismatch, currency, price.Currency)
+		}
+
+		line.UnitPrice = price
+		line.LineTotal = price.Mul(int64(line.Quantity))
+		line.Discount = common.NewMoney(0, price.Currency)
+		line.Tax = common.NewMoney(0, price.Currency)
+
+		// Lines added before prices were recorded have no currency and are never flagged
+		added := line.Cart.AddedPrice
+		if added.Currency != "" && added != price {
+			line.PriceChanged = true
+			line.PreviousUnitPrice = &added
+		}
+	}
+	return nil
+}
+
+// The categories of the lines' products and their parents, loaded one level of the tree at a time.
+// Promotions scoped to a category cover its subcategories, and tax rates are inherited from parents.
+func loadLineCategories(summary *CartSummary, input *pricingInput) error {
+	categoryByID := make(map[uint]*models.Category)
+	var pending []uint
+	for _, line := range summary.Items {
+		if !line.Unavailable {
+			pending = append(pending, line.Product.CategoryID)
+		}
+	}
+
+	for len(pending) > 0 {
+		var categories []models.Category
+		if err := input.db.Select("id", "parent_id", "hsn_code", "tax_rate_basis_points").Where("id IN ?", pending).Find(&categories).Error; err != nil {
+			return fmt.Errorf("failed to load categories: %w", err)
+		}
+
+		pending = nil
+		for i := range categories {
+			category := &categories[i]
+			categoryByID[category.Id] = category
+			// Loaded parents are not queried again, which also ends category loops
+			if category.ParentID != nil && categoryByID[*category.ParentID] == nil {
+				pending = append(pending, *category.ParentID)
+			}
+		}
+	}
+	input.categoryByID = categoryByID
+
+	for i := range summary.Items {
+		line := &summary.Items[i]
+		if line.Unavailable {
+			continue
+		}
+		// A category loop would otherwise never end
+		seen := map[uint]bool{line.Product.CategoryID: true}
+		category := categoryByID[line.Product.CategoryID]
+		for category != nil && category.ParentID != nil && !seen[*category.ParentID] {
+			seen[*category.ParentID] = true
+			line.parentCategoryIDs = append(line.parentCategoryIDs, *category.ParentID)
+			category = categoryByID[*category.ParentID]
+		}
+	}
+	return nil
+}
+
+// Whether the line's product is in the category or one of its subcategories
+func (line *CartLine) inCategory(categoryID uint) bool {
+	if line.Product.CategoryID == categoryID {
+		return true
+	}
+	for _, parentID := range line.parentCategoryIDs {
+		if parentID == categoryID {
+			return true
+		}
+	}
+	return false
+}
+
+// What is left to pay for a line after the discounts allocated to it so far
+func (line *CartLine) remaining() int64 {
+	return line.LineTotal.Minor - line.Discount.Minor
+}
+
+func (line *CartLine) addDiscount(source string, sourceID uint, label string, minor int64) {
+	if minor <= 0 {
+		return
+	}
+	amount := common.NewMoney(minor, line.UnitPrice.Currency)
+	line.Discount.Minor += minor
+	line.Discounts = append(line.Discounts, DiscountAllocation{Source: source, SourceID: sourceID, Label: label, Amount: amount})
+}
+
+// The available lines in the scope of a coupon or promotion
+func (summary *CartSummary) eligibleLines(products []models.Product, categories []models.Category) []*CartLine {
+	var lines []*CartLine
+	for i := range summary.Items {
+		line := &summary.Items[i]
+		if !line.Unavailable && inPromotionScope(line, products, categories) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Spread a discount over lines in proportion to what is left to pay for each, never taking a
+// line below zero. Minor units lost to rounding go to the first lines with room. Returns the
+// amount allocated.
+func allocateDiscount(lines []*CartLine, minor int64, source string, sourceID uint, label string) int64 {
+	var left int64
+	for _, line := range lines {
+		left += line.remaining()
+	}
+	if minor > left {
+		minor = left
+	}
+	if minor <= 0 {
+		return 0
+	}
+
+	shares := make([]int64, len(lines))
+	var allocated int64
+	for i, line := range lines {
+		shares[i] = minor * line.remaining() / left
+		allocated += shares[i]
+	}
+	for i := 0; allocated < minor; i = (i + 1) % len(lines) {
+		if shares[i] < lines[i].remaining() {
+			shares[i]++
+			allocated++
+		}
+	}
+
+	for i, line := range lines {
+		line.addDiscount(source, sourceID, label, shares[i])
+	}
+	return minor
+}
+
+// Percent of an amount in minor units, rounded half up
+func percentOf(minor int64, percent int) int64 {
+	return (minor*int64(percent) + 50) / 100
+}
+
+// Automatic promotions in the order they were created, each on what earlier ones left to pay
+func applyPromotions(summary *CartSummary, input *pricingInput) error {
+	if len(summary.Items) == 0 {
+		return nil
+	}
+
+	var promotions []models.Promotion
+	err := input.db.Preload("Tiers").Preload("Products").Preload("Categories").
+		Where("active = ?", true).Order("id").Find(&promotions).Error
+	if err != nil {
+		return fmt.Errorf("failed to load promotions: %w", err)
+	}
+
+	currency := summary.currency()
+	for i := range promotions {
+		promotion := &promotions[i]
+		if !runsAt(promotion.Active, promotion.StartsAt, promotion.EndsAt, input.now) {
+			continue
+		}
+		lines := summary.eligibleLines(promotion.Products, promotion.Categories)
+		if len(lines) == 0 {
+			continue
+		}
+
+		var minor int64
+		switch promotion.Kind {
+		case common.PromotionKindBuyXGetY:
+			minor = applyBuyXGetY(promotion, lines)
+		case common.PromotionKindTiered:
+			minor = applyTiered(promotion, lines, currency)
+		}
+		if minor > 0 {
+			summary.Promotions = append(summary.Promotions, DiscountAllocation{
+				Source:   DiscountSourcePromotion,
+				SourceID: promotion.Id,
+				Label:    promotion.Name,
+				Amount:   common.NewMoney(minor, currency),
+			})
+		}
+	}
+	return nil
+}
+
+// GetQuantity units at GetPercentOff for every BuyQuantity + GetQuantity units, the cheapest ones
+func applyBuyXGetY(promotion *models.Promotion, lines []*CartLine) int64 {
+	var units int
+	for _, line := range lines {
+		units += int(line.Quantity)
+	}
+	free := units / (promotion.BuyQuantity + promotion.GetQuantity) * promotion.GetQuantity
+	if free == 0 {
+		return 0
+	}
+
+	cheapest := append([]*CartLine(nil), lines...)
+	sort.SliceStable(cheapest, func(i, j int) bool {
+		return cheapest[i].UnitPrice.Minor < cheapest[j].UnitPrice.Minor
+	})
+
+	var total int64
+	for _, line := range cheapest {
+		if free == 0 {
+			break
+		}
+		count := int(line.Quantity)
+		if count > free {
+			count = free
+		}
+		free -= count
+
+		minor := percentOf(line.UnitPrice.Minor*int64(count), promotion.GetPercentOff)
+		if minor > line.remaining() {
+			minor = line.remaining()
+		}
+		line.addDiscount(DiscountSourcePromotion, promotion.Id, promotion.Name, minor)
+		total += minor
+	}
+	return total
+}
+
+// The PercentOff of the highest tier the lines' subtotal reaches, on what is left to pay for them
+func applyTiered(promotion *models.Promotion, lines []*CartLine, currency string) int64 {
+	var subtotal, left int64
+	for _, line := range lines {
+		subtotal += line.LineTotal.Minor
+		left += line.remaining()
+	}
+
+	var best *models.PromotionTier
+	for i := range promotion.Tiers {
+		tier := &promotion.Tiers[i]
+		if tier.MinSubtotal.Currency != currency || subtotal < tier.MinSubtotal.Minor {
+			continue
+		}
+		if best == nil || tier.MinSubtotal.Minor > best.MinSubtotal.Minor {
+			best = tier
+		}
+	}
+	if best == nil {
+		return 0
+	}
+	return allocateDiscount(lines, percentOf(left, best.PercentOff), DiscountSourcePromotion, promotion.Id, promotion.Name)
+}
+
+// Refusals that leave the coupon on the cart without applying it
+var couponRefusals = []error{ErrCouponNotActive, ErrCouponUsedUp, ErrCouponUserLimit, ErrCouponNotApplicable, ErrCouponMinimum}
+
+func isCouponRefusal(err error) bool {
+	for _, refusal := range couponRefusals {
+		if errors.Is(err, refusal) {
+			return true
+		}
+	}
+	return false
+}
+
+// The coupon applied to the user's cart, on what promotions left to pay
+func applyCoupon(summary *CartSummary, input *pricingInput) error {
+	var cartCoupon models.CartCoupon
+	result := input.db.Where("user_id = ?", input.userID).Limit(1).Find(&cartCoupon)
+	if result.Error != nil {
+		return fmt.Errorf("failed to load cart coupon: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	query := input.db
+	if input.lock {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	var coupon models.Coupon
+	if err := query.Preload("Products").Preload("Categories").First(&coupon, cartCoupon.CouponID).Error; err != nil {
+		return fmt.Errorf("failed to load coupon: %w", err)
+	}
+
+	currency := summary.currency()
+	applied := &AppliedCoupon{
+		Code:     coupon.Code,
+		Kind:     coupon.Kind,
+		Discount: common.NewMoney(0, currency),
+		coupon:   &coupon,
+	}
+	summary.Coupon = applied
+
+	lines := summary.eligibleLines(coupon.Products, coupon.Categories)
+	eligible := common.NewMoney(0, currency)
+	for _, line := range lines {
+		eligible.Minor += line.remaining()
+	}
+	if err := checkCoupon(input.db, &coupon, input.userID, lines, eligible, input.now); err != nil {
+		if !isCouponRefusal(err) {
+			return err
+		}
+		applied.err = err
+		applied.Error = err.Error()
+		return nil
+	}
+
+	switch coupon.Kind {
+	case common.CouponKindPercentage:
+		applied.Discount.Minor = allocateDiscount(lines, percentOf(eligible.Minor, coupon.PercentOff), DiscountSourceCoupon, coupon.Id, coupon.Code)
+	case common.CouponKindFixed:
+		applied.Discount.Minor = allocateDiscount(lines, coupon.AmountOff.Minor, DiscountSourceCoupon, coupon.Id, coupon.Code)
+	case common.CouponKindFreeShipping:
+		applied.FreeShipping = true
+	}
+	return nil
+}
+
+// Taxes on each line after its discount, by the rules of the tax calculator. The rate and HSN
+// code come from the product, else from its category or the nearest parent category with
+// them, and the rate from TAX_RATE when none has one.
+func applyTax(summary *CartSummary, input *pricingInput) error {
+	summary.TaxInclusive = input.config.pricesIncludeTax
+	if len(summary.Items) == 0 {
+		return nil
+	}
+
+	for i := range summary.Items {
+		line := &summary.Items[i]
+		if line.Unavailable {
+			continue
+		}
+
+		rate, hsnCode := productTax(&line.Product, input.categoryByID)
+		if rate == nil {
+			rate = &input.config.taxRateBasisPoints
+		}
+		line.HSNCode = hsnCode
+
+		amount := common.NewMoney(line.remaining(), line.UnitPrice.Currency)
+		taxes, err := taxCalculator.Calculate(&TaxableLine{
+			Amount:          amount,
+			Inclusive:       input.config.pricesIncludeTax,
+			RateBasisPoints: *rate,
+			HSNCode:         hsnCode,
+			ShipTo:          input.shippingAddress,
+		})
+		if err != nil {
+			return err
+		}
+
+		line.Taxes = taxes
+		for _, tax := range taxes {
+			line.Tax.Minor += tax.Amount.Minor
+			summary.addTax(tax)
+		}
+		line.TaxableValue = amount
+		if input.config.pricesIncludeTax {
+			line.TaxableValue.Minor -= line.Tax.Minor
+		}
+	}
+	return nil
+}
+
+// The product's own tax rate and HSN code, each falling back to the nearest category with one
+func productTax(product *models.Product, categoryByID map[uint]*models.Category) (*int, string) {
+	rate, hsnCode := product.TaxRateBasisPoints, product.HSNCode
+	seen := make(map[uint]bool)
+	for category := categoryByID[product.CategoryID]; category != nil && (rate == nil || hsnCode == ""); {
+		if rate == nil {
+			rate = category.TaxRateBasisPoints
+		}
+		if hsnCode == "" {
+			hsnCode = category.HSNCode
+		}
+		// A category loop would otherwise never end
+		seen[category.Id] = true
+		if category.ParentID == nil || seen[*category.ParentID] {
+			break
+		}
+		category = categoryByID[*category.ParentID]
+	}
+	return rate, hsnCode
+}
+
+// Add a line's tax to the cart's total of the same tax at the same rate
+func (summary *CartSummary) addTax(tax TaxComponent) {
+	for i := range summary.Taxes {
+		if summary.Taxes[i].Name == tax.Name && summary.Taxes[i].Rate == tax.Rate {
+			summary.Taxes[i].Amount.Minor += tax.Amount.Minor
+			return
+		}
+	}
+	summary.Taxes = append(summary.Taxes, tax)
+}
+
+// SHIPPING_FEE per order, waived when the discounted subtotal reaches FREE_SHIPPING_OVER
+func estimateShipping(summary *CartSummary, input *pricingInput) error {
+	currency := summary.currency()
+	summary.Shipping = common.NewMoney(0, currency)
+
+	goods := common.NewMoney(0, currency)
+	for _, line := range summary.Items {
+		if line.Unavailable {
+			continue
+		}
+		var err error
+		if goods, err = goods.Add(line.LineTotal); err != nil {
+			return err
+		}
+		if goods, err = goods.Sub(line.Discount); err != nil {
+			return err
+		}
+	}
+	if goods.IsZero() {
+		return nil
+	}
+
+	fee := input.config.amount("SHIPPING_FEE", input.config.shippingFee, currency)
+	if input.config.freeShippingOver != "" {
+		threshold := input.config.amount("FREE_SHIPPING_OVER", input.config.freeShippingOver, currency)
+		if goods.Minor >= threshold.Minor {
+			return nil
+		}
+	}
+	summary.Shipping = fee
+	return nil
+}
+
+// Waive the shipping fee for a free shipping coupon, counting it as the coupon's discount
+func applyFreeShipping(summary *CartSummary, input *pricingInput) error {
+	if summary.Coupon == nil || !summary.Coupon.FreeShipping {
+		return nil
+	}
+	summary.Coupon.Discount = summary.Shipping
+	summary.Shipping = common.NewMoney(0, summary.Shipping.Currency)
+	return nil
+}
+
+// Subtotal, discount and tax over the lines, and the grand total with shipping. Tax is only
+// added to the total when prices don't include it.
+func totalCart(summary *CartSummary, input *pricingInput) error {
+	currency := summary.currency()
+	summary.Subtotal = common.NewMoney(0, currency)
+	summary.Discount = common.NewMoney(0, currency)
+	summary.Tax = common.NewMoney(0, currency)
+
+	var err error
+	for _, line := range summary.Items {
+		if line.Unavailable {
+			continue
+		}
+		if summary.Subtotal, err = summary.Subtotal.Add(line.LineTotal); err != nil {
+			return err
+		}
+		if summary.Discount, err = summary.Discount.Add(line.Discount); err != nil {
+			return err
+		}
+		if summary.Tax, err = summary.Tax.Add(line.Tax); err != nil {
+			return err
+		}
+	}
+
+	summary.Total, err = summary.Subtotal.Sub(summary.Discount)
+	if err != nil {
+		return err
+	}
+	if !summary.TaxInclusive {
+		if summary.Total, err = summary.Total.Add(summary.Tax); err != nil {
+			return err
+		}
+	}
+	if summary.Total, err = summary.Total.Add(summary.Shipping); err != nil {
+		return err
+	}
+	return nil
+}
+
+// The product's current price, recorded on cart lines so later changes can be flagged
+func currentPrice(db *gorm.DB, productID uint) (common.Money, error) {
+	var product models.Product
+	if err := db.Select("id", "price_minor", "price_currency").First(&product, productID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return common.Money{}, ErrProductUnavailable
+		}
+		return common.Money{}, fmt.Errorf("failed to load product price: %w", err)
+	}
+	return product.Price, nil
+}
@@ -0,0 +1,288 @@
+package managers
+
+import (
+	"main/common"
+	"main/database"
+	"main/models"
+	"reflect"
+	"testing"
+)
+
+// A cart line of quantity units at unit paise, with discount paise already taken off
+func testCartLine(unit int64, quantity uint, discount int64) *CartLine {
+	line := &CartLine{
+		UnitPrice: common.NewMoney(unit, "INR"),
+		LineTotal: common.NewMoney(unit*int64(quantity), "INR"),
+		Discount:  common.NewMoney(discount, "INR"),
+	}
+	line.Quantity = quantity
+	return line
+}
+
+func lineDiscounts(lines []*CartLine) []int64 {
+	discounts := make([]int64, len(lines))
+	for i, line := range lines {
+		discounts[i] = line.Discount.Minor
+	}
+	return discounts
+}
+
+func equalMinor(a []int64, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAllocateDiscount(t *testing.T) {
+	tests := []struct {
+		name          string
+		lines         []*CartLine
+		discount      int64
+		wantAllocated int64
+		wantDiscounts []int64
+	}{
+		{
+			name:          "in proportion to price",
+			lines:         []*CartLine{testCartLine(10000, 1, 0), testCartLine(10000, 3, 0)},
+			discount:      2500,
+			wantAllocated: 2500,
+			wantDiscounts: []int64{625, 1875},
+		},
+		{
+			name:          "rounding remainder to the first lines",
+			lines:         []*CartLine{testCartLine(10000, 1, 0), testCartLine(10000, 1, 0), testCartLine(10000, 1, 0)},
+			discount:      100,
+			wantAllocated: 100,
+			wantDiscounts: []int64{34, 33, 33},
+		},
+		{
+			name:          "on what is left after earlier discounts",
+			lines:         []*CartLine{testCartLine(10000, 1, 5000), testCartLine(5000, 1, 0)},
+			discount:      1000,
+			wantAllocated: 1000,
+			wantDiscounts: []int64{5500, 500},
+		},
+		{
+			name:          "skipping lines with nothing left",
+			lines:         []*CartLine{testCartLine(1000, 1, 1000), testCartLine(1000, 1, 0)},
+			discount:      300,
+			wantAllocated: 300,
+			wantDiscounts: []int64{1000, 300},
+		},
+		{
+			name:          "capped at what is left to pay",
+			lines:         []*CartLine{testCartLine(1000, 1, 0), testCartLine(500, 1, 0)},
+			discount:      5000,
+			wantAllocated: 1500,
+			wantDiscounts: []int64{1000, 500},
+		},
+		{
+			name:          "nothing to allocate",
+			lines:         []*CartLine{testCartLine(1000, 1, 0)},
+			discount:      0,
+			wantAllocated: 0,
+			wantDiscounts: []int64{0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			allocated := allocateDiscount(test.lines, test.discount, DiscountSourceCoupon, 1, "TEST")
+			if allocated != test.wantAllocated {
+				t.Errorf("allocated %d, want %d", allocated, test.wantAllocated)
+			}
+			if got := lineDiscounts(test.lines); !equalMinor(got, test.wantDiscounts) {
+				t.Errorf("line discounts %v, want %v", got, test.wantDiscounts)
+			}
+		})
+	}
+}
+
+func TestApplyBuyXGetY(t *testing.T) {
+	tests := []struct {
+		name          string
+		buy, get      int
+		percentOff    int
+		lines         []*CartLine
+		wantTotal     int64
+		wantDiscounts []int64
+	}{
+		{
+			name: "one free in three", buy: 2, get: 1, percentOff: 100,
+			lines:         []*CartLine{testCartLine(300, 3, 0)},
+			wantTotal:     300,
+			wantDiscounts: []int64{300},
+		},
+		{
+			name: "cheapest unit is free", buy: 2, get: 1, percentOff: 100,
+			lines:         []*CartLine{testCartLine(500, 2, 0), testCartLine(200, 1, 0)},
+			wantTotal:     200,
+			wantDiscounts: []int64{0, 200},
+		},
+		{
+			name: "incomplete groups don't count", buy: 2, get: 1, percentOff: 100,
+			lines:         []*CartLine{testCartLine(100, 5, 0)},
+			wantTotal:     100,
+			wantDiscounts: []int64{100},
+		},
+		{
+			name: "free units spread over the cheapest lines", buy: 1, get: 1, percentOff: 100,
+			lines:         []*CartLine{testCartLine(500, 2, 0), testCartLine(100, 1, 0), testCartLine(300, 1, 0)},
+			wantTotal:     400,
+			wantDiscounts: []int64{0, 100, 300},
+		},
+		{
+			name: "part off rounded half up", buy: 2, get: 1, percentOff: 50,
+			lines:         []*CartLine{testCartLine(301, 3, 0)},
+			wantTotal:     151,
+			wantDiscounts: []int64{151},
+		},
+		{
+			name: "never below zero", buy: 2, get: 1, percentOff: 100,
+			lines:         []*CartLine{testCartLine(300, 3, 800)},
+			wantTotal:     100,
+			wantDiscounts: []int64{900},
+		},
+		{
+			name: "too few units", buy: 2, get: 1, percentOff: 100,
+			lines:         []*CartLine{testCartLine(300, 2, 0)},
+			wantTotal:     0,
+			wantDiscounts: []int64{0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			promotion := &models.Promotion{Id: 1, Name: "Buy X get Y", BuyQuantity: test.buy, GetQuantity: test.get, GetPercentOff: test.percentOff}
+			total := applyBuyXGetY(promotion, test.lines)
+			if total != test.wantTotal {
+				t.Errorf("total %d, want %d", total, test.wantTotal)
+			}
+			if got := lineDiscounts(test.lines); !equalMinor(got, test.wantDiscounts) {
+				t.Errorf("line discounts %v, want %v", got, test.wantDiscounts)
+			}
+		})
+	}
+}
+
+func TestApplyTiered(t *testing.T) {
+	promotion := &models.Promotion{
+		Id:   2,
+		Name: "Spend more",
+		Tiers: []models.PromotionTier{
+			{MinSubtotal: common.NewMoney(100000, "INR"), PercentOff: 10},
+			{MinSubtotal: common.NewMoney(50000, "INR"), PercentOff: 5},
+			// Never compared with carts in other currencies
+			{MinSubtotal: common.NewMoney(1000, "USD"), PercentOff: 50},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		lines         []*CartLine
+		wantTotal     int64
+		wantDiscounts []int64
+	}{
+		{
+			name:          "below every tier",
+			lines:         []*CartLine{testCartLine(40000, 1, 0)},
+			wantTotal:     0,
+			wantDiscounts: []int64{0},
+		},
+		{
+			name:          "exactly at the lower tier",
+			lines:         []*CartLine{testCartLine(50000, 1, 0)},
+			wantTotal:     2500,
+			wantDiscounts: []int64{2500},
+		},
+		{
+			name:          "lower tier spread over lines",
+			lines:         []*CartLine{testCartLine(30000, 1, 0), testCartLine(10000, 3, 0)},
+			wantTotal:     3000,
+			wantDiscounts: []int64{1500, 1500},
+		},
+		{
+			name:          "highest tier reached",
+			lines:         []*CartLine{testCartLine(60000, 2, 0)},
+			wantTotal:     12000,
+			wantDiscounts: []int64{12000},
+		},
+		{
+			name:          "tier by subtotal, percent of what is left",
+			lines:         []*CartLine{testCartLine(120000, 1, 20000)},
+			wantTotal:     10000,
+			wantDiscounts: []int64{30000},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			total := applyTiered(promotion, test.lines, "INR")
+			if total != test.wantTotal {
+				t.Errorf("total %d, want %d", total, test.wantTotal)
+			}
+			if got := lineDiscounts(test.lines); !equalMinor(got, test.wantDiscounts) {
+				t.Errorf("line discounts %v, want %v", got, test.wantDiscounts)
+			}
+		})
+	}
+}
+
+func TestPromotionCategoryScope(t *testing.T) {
+	useTestDB(t)
+
+	// Clothing > Shirts > Formal shirts, Books, and two categories that are each other's parent
+	parent := func(id uint) *uint { return &id }
+	categories := []models.Category{
+		{Id: 1, Name: "Clothing"},
+		{Id: 2, Name: "Shirts", ParentID: parent(1)},
+		{Id: 3, Name: "Formal shirts", ParentID: parent(2)},
+		{Id: 4, Name: "Books"},
+		{Id: 5, Name: "Loop A", ParentID: parent(6)},
+		{Id: 6, Name: "Loop B", ParentID: parent(5)},
+	}
+	if err := database.DB.Create(&categories).Error; err != nil {
+		t.Fatalf("failed to create categories: %v", err)
+	}
+
+	summary := &CartSummary{}
+	for _, categoryID := range []uint{3, 2, 1, 4, 5} {
+		line := CartLine{}
+		line.Product = models.Product{Id: categoryID, CategoryID: categoryID}
+		summary.Items = append(summary.Items, line)
+	}
+	if err := loadLineCategories(summary, &pricingInput{db: database.DB}); err != nil {
+		t.Fatalf("loadLineCategories() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		categoryID uint
+		// Categories of the products in scope
+		want []uint
+	}{
+		{name: "top level category covers every level below", categoryID: 1, want: []uint{3, 2, 1}},
+		{name: "subcategory covers its own subcategories", categoryID: 2, want: []uint{3, 2}},
+		{name: "leaf category", categoryID: 3, want: []uint{3}},
+		{name: "unrelated category", categoryID: 4, want: []uint{4}},
+		{name: "category loop", categoryID: 6, want: []uint{5}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []uint
+			for _, line := range summary.eligibleLines(nil, []models.Category{{Id: test.categoryID}}) {
+				got = append(got, line.Product.CategoryID)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("lines in scope of category %d = %v, want %v", test.categoryID, got, test.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package managers
 
 import (
+	"errors"
 	"fmt"
 	"main/common"
 	"main/database"
@@ -9,11 +10,15 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"gorm.io/gorm"
 )
 
+var ErrInvalidProductQuery = errors.New("invalid product query")
+
 type ProductManager interface {
 	Create(productData *common.ProductCreationInput) (*models.Product, error)
-	List() ([]models.Product, error)
+	List(query *common.ProductListQuery) ([]models.Product, error)
 	Get(id string) (*models.Product, error)
 	Update(productID string, productData *common.ProductUpdationInput) (*models.Product, error)
 	Delete(id string) error
@@ -32,20 +37,51 @@ func NewProductManager() ProductManager {
 	return &productManager{}
 }
 
+// Prices can be zero for free items, never negative
+func validatePrice(price common.Money) error {
+	if price.IsNegative() {
+		return fmt.Errorf("%w: %s", ErrInvalidPrice, price)
+	}
+	return nil
+}
+
 // Create a client
 func (productManager *productManager) Create(productData *common.ProductCreationInput) (*models.Product, error) {
+	if err := validatePrice(*productData.Price); err != nil {
+		return nil, err
+	}
+	if err := validateTaxFields(productData.TaxRateBasisPoints, productData.HSNCode); err != nil {
+		return nil, err
+	}
+
 	newProduct := &models.Product{
 		SKU:         productData.SKU,
 		Name:        productData.Name,
 		Description: productData.Description,
-		Price:       productData.Price,
+		Price:       *productData.Price,
 		Image:       productData.Image,
 		CategoryID:  productData.CategoryID,
+
+		LowStockThreshold:  productData.LowStockThreshold,
+		HSNCode:            productData.HSNCode,
+		TaxRateBasisPoints: productData.TaxRateBasisPoints,
 	}
 
-	result := database.DB.Create(newProduct)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to create new product %w", result.Error)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(newProduct).Error; err != nil {
+			return fmt.Errorf("failed to create new product %w", err)
+		}
+		if productData.Stock == 0 {
+			return nil
+		}
+		_, err := adjustStock(tx, newProduct.Id, nil, productData.Stock, &models.StockMovement{
+			Kind: StockMovementReceipt,
+			Note: "opening stock",
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	database.DB.Preload("Category").First(&newProduct, newProduct.Id)
@@ -53,12 +89,53 @@ func (productManager *productManager) Create(productData *common.ProductCreation
 	return newProduct, nil
 }
 
-func (productManager *productManager) List() ([]models.Product, error) {
+// Sorting options of the product list
+// Prices in different currencies can't be compared, so price sorts group products by currency first
+var productSorts = map[string]string{
+	"":           "id",
+	"price_asc":  "price_currency, price_minor, id",
+	"price_desc": "price_currency, price_minor DESC, id",
+	"newest":     "created_at DESC, id DESC",
+	"name":       "name, id",
+}
+
+func (productManager *productManager) List(query *common.ProductListQuery) ([]models.Product, error) {
+	order, ok := productSorts[query.Sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown sort %q", ErrInvalidProductQuery, query.Sort)
+	}
+	db := database.DB.Preload("Variants").Order(order)
+
+	if query.MinPrice != "" || query.MaxPrice != "" || query.Currency != "" {
+		currency := strings.ToUpper(query.Currency)
+		if currency == "" {
+			currency = common.DefaultCurrency()
+		}
+		db = db.Where("price_currency = ?", currency)
+
+		for _, bound := range []struct {
+			value string
+			where string
+		}{{query.MinPrice, "price_minor >= ?"}, {query.MaxPrice, "price_minor <= ?"}} {
+			if bound.value == "" {
+				continue
+			}
+			price, err := common.ParseMoney(bound.value, currency)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidProductQuery, err)
+			}
+			db = db.Where(bound.where, price.Minor)
+		}
+	}
+
 	var products []models.Product
-	result := database.DB.Find(&products)
+	result := db.Find(&products)
 	if result.Error != nil {
 		return nil, fmt.Errorf("failed to list the products %w", result.Error)
 	}
+	if err := fillAvailability(products); err != nil {
+		return nil, err
+	}
 
 	return products, nil
 }
@@ -74,11 +151,17 @@ func (productManager *productManager) Get(id string) (*models.Product, error) {
 
 	fmt.Printf("Attempting to get product with ID: %d\n", productID)
 
-	result := database.DB.Preload("Category").First(&product, productID)
+	result := database.DB.Preload("Category").Preload("Variants").First(&product, productID)
 	if result.Error != nil {
 		return &models.Product{}, fmt.Errorf("failed to get product %w", result.Error)
 	}
 
+	products := []models.Product{product}
+	if err := fillAvailability(products); err != nil {
+		return &models.Product{}, err
+	}
+	product = products[0]
+
 	return &product, nil
 }
 
@@ -101,8 +184,11 @@ func (productManager *productManager) Update(productID string, productData *comm
 	if productData.Description != "" {
 		product.Description = productData.Description
 	}
-	if productData.Price != "" {
-		product.Price = productData.Price
+	if productData.Price != nil {
+		if err := validatePrice(*productData.Price); err != nil {
+			return nil, err
+		}
+		product.Price = *productData.Price
 	}
 	if productData.Image != "" {
 		product.Image = productData.Image
@@ -110,8 +196,27 @@ func (productManager *productManager) Update(productID string, productData *comm
 	if productData.CategoryID != 0 { //Assuming CategoryID can't be 0 if it is not an empty value.
 		product.CategoryID = productData.CategoryID
 	}
+	if productData.LowStockThreshold != nil {
+		product.LowStockThreshold = productData.LowStockThreshold
+	}
+	if err := validateTaxFields(productData.TaxRateBasisPoints, productData.HSNCode); err != nil {
+		return nil, err
+	}
+	if productData.HSNCode != "" {
+		product.HSNCode = productData.HSNCode
+	}
+	if productData.TaxRateBasisPoints != nil {
+		product.TaxRateBasisPoints = productData.TaxRateBasisPoints
+	}
+	if productData.ClearHSNCode {
+		product.HSNCode = ""
+	}
+	if productData.ClearTaxRate {
+		product.TaxRateBasisPoints = nil
+	}
 
-	result = database.DB.Save(&product)
+	// Stock may have moved since the product was read
+	result = database.DB.Omit("Stock").Save(&product)
 	if result.Error != nil {
 		return nil, fmt.Errorf("failed to update product: %w", result.Error)
 	}
@@ -137,12 +242,15 @@ func (productManager *productManager) Delete(id string) error {
 func (productManager *productManager) SearchProducts(searchTerm string) ([]models.Product, error) {
 	var products []models.Product
 
-	query := database.DB.Preload("Category").Where("name LIKE ? OR description LIKE ?", "%"+searchTerm+"%", "%"+searchTerm+"%")
+	query := database.DB.Preload("Category").Preload("Variants").Where("name LIKE ? OR description LIKE ?", "%"+searchTerm+"%", "%"+searchTerm+"%")
 
 	result := query.Find(&products)
 	if result.Error != nil {
 		return nil, fmt.Errorf("failed to search products: %w", result.Error)
 	}
+	if err := fillAvailability(products); err != nil {
+		return nil, err
+	}
 
 	return products, nil
 }
@@ -187,13 +295,15 @@ func (productManager *productManager) SeedProducts(count int) error {
 		randomCategory := categories[rand.Intn(len(categories))]
 
 		productName, productDescription := generateProductNameAndDescription(randomCategory.Name, nextProductID)
+		price := common.NewMoney(int64(rand.Intn(100000)), common.DefaultCurrency())
 
 		productData := &common.ProductCreationInput{
 			//SKU:         sku,
 			Name:        productName,
 			Description: productDescription,
-			Price:       strconv.FormatFloat(float64(rand.Intn(100000))/100.0, 'f', 2, 64),
+			Price:       &price,
 			CategoryID:  randomCategory.Id,
+			Stock:       rand.Intn(50),
 		}
 
 		var newProduct *models.Product
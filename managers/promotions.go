@@ -0,0 +1,492 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"main/common"
+	"main/database"
+	"main/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCouponNotFound      = errors.New("coupon not found")
+	ErrCouponCodeExists    = errors.New("a coupon with this code already exists")
+	ErrInvalidCoupon       = errors.New("invalid coupon")
+	ErrPromotionNotFound   = errors.New("promotion not found")
+	ErrInvalidPromotion    = errors.New("invalid promotion")
+	ErrUnknownPromoScope   = errors.New("unknown product or category")
+	ErrCouponNotActive     = errors.New("coupon is not valid at this time")
+	ErrCouponUsedUp        = errors.New("coupon has reached its usage limit")
+	ErrCouponUserLimit     = errors.New("you have already used this coupon as often as allowed")
+	ErrCouponNotApplicable = errors.New("coupon does not apply to the items in your cart")
+	ErrCouponMinimum       = errors.New("cart does not reach the coupon's minimum")
+)
+
+// Sources of the discounts allocated to cart and order lines
+const (
+	DiscountSourcePromotion = "promotion"
+	DiscountSourceCoupon    = "coupon"
+)
+
+type PromotionManager interface {
+	CreateCoupon(couponData *common.CouponCreationInput) (*models.Coupon, error)
+	ListCoupons() ([]models.Coupon, error)
+	GetCoupon(couponID uint) (*models.Coupon, error)
+	UpdateCoupon(couponID uint, couponData *common.CouponUpdationInput) (*models.Coupon, error)
+	CreatePromotion(promotionData *common.PromotionCreationInput) (*models.Promotion, error)
+	ListPromotions() ([]models.Promotion, error)
+	GetPromotion(promotionID uint) (*models.Promotion, error)
+	UpdatePromotion(promotionID uint, promotionData *common.PromotionUpdationInput) (*models.Promotion, error)
+}
+
+type promotionManager struct {
+}
+
+func NewPromotionManager() PromotionManager {
+	return &promotionManager{}
+}
+
+// Codes are matched without regard to case or surrounding spaces
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (promotionManager *promotionManager) CreateCoupon(couponData *common.CouponCreationInput) (*models.Coupon, error) {
+	coupon := &models.Coupon{
+		Code:         normalizeCouponCode(couponData.Code),
+		Description:  couponData.Description,
+		Kind:         couponData.Kind,
+		PercentOff:   couponData.PercentOff,
+		StartsAt:     couponData.StartsAt,
+		EndsAt:       couponData.EndsAt,
+		UsageLimit:   couponData.UsageLimit,
+		PerUserLimit: couponData.PerUserLimit,
+		Active:       couponData.Active == nil || *couponData.Active,
+	}
+	if couponData.AmountOff != nil {
+		coupon.AmountOff = *couponData.AmountOff
+	}
+	if couponData.MinSubtotal != nil {
+		coupon.MinSubtotal = *couponData.MinSubtotal
+	}
+	if err := validateCoupon(coupon); err != nil {
+		return nil, err
+	}
+
+	products, categories, err := findPromotionScope(couponData.ProductIDs, couponData.CategoryIDs)
+	if err != nil {
+		return nil, err
+	}
+	coupon.Products = products
+	coupon.Categories = categories
+
+	if err := database.DB.Create(coupon).Error; err != nil {
+		if isDuplicateKeyError(err) {
+			return nil, ErrCouponCodeExists
+		}
+		return nil, fmt.Errorf("failed to create coupon: %w", err)
+	}
+	return coupon, nil
+}
+
+func (promotionManager *promotionManager) ListCoupons() ([]models.Coupon, error) {
+	coupons := []models.Coupon{}
+	err := database.DB.Preload("Products").Preload("Categories").Order("id DESC").Find(&coupons).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list coupons: %w", err)
+	}
+	return coupons, nil
+}
+
+func (promotionManager *promotionManager) GetCoupon(couponID uint) (*models.Coupon, error) {
+	var coupon models.Coupon
+	result := database.DB.Preload("Products").Preload("Categories").First(&coupon, couponID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrCouponNotFound
+		}
+		return nil, fmt.Errorf("failed to get coupon: %w", result.Error)
+	}
+	return &coupon, nil
+}
+
+// Change a coupon's terms. The code and kind stay as created, since orders refer to them.
+func (promotionManager *promotionManager) UpdateCoupon(couponID uint, couponData *common.CouponUpdationInput) (*models.Coupon, error) {
+	coupon, err := promotionManager.GetCoupon(couponID)
+	if err != nil {
+		return nil, err
+	}
+
+	if couponData.Description != nil {
+		coupon.Description = *couponData.Description
+	}
+	if couponData.PercentOff != nil {
+		coupon.PercentOff = *couponData.PercentOff
+	}
+	if couponData.AmountOff != nil {
+		coupon.AmountOff = *couponData.AmountOff
+	}
+	if couponData.MinSubtotal != nil {
+		coupon.MinSubtotal = *couponData.MinSubtotal
+	}
+	if couponData.StartsAt != nil {
+		coupon.StartsAt = couponData.StartsAt
+	}
+	if couponData.EndsAt != nil {
+		coupon.EndsAt = couponData.EndsAt
+	}
+	if couponData.UsageLimit != nil {
+		coupon.UsageLimit = couponData.UsageLimit
+	}
+	if couponData.PerUserLimit != nil {
+		coupon.PerUserLimit = couponData.PerUserLimit
+	}
+	if couponData.Active != nil {
+		coupon.Active = *couponData.Active
+	}
+	if err := validateCoupon(coupon); err != nil {
+		return nil, err
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := replacePromotionScope(tx, coupon, couponData.ProductIDs, couponData.CategoryIDs); err != nil {
+			return err
+		}
+		// TimesUsed is counted by checkouts and must not be written back from this copy
+		if err := tx.Omit("Products", "Categories", "TimesUsed").Save(coupon).Error; err != nil {
+			return fmt.Errorf("failed to update coupon: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return promotionManager.GetCoupon(couponID)
+}
+
+func validateCoupon(coupon *models.Coupon) error {
+	switch coupon.Kind {
+	case common.CouponKindPercentage:
+		if coupon.PercentOff < 1 {
+			return fmt.Errorf("%w: percentage coupons need a percentOff from 1 to 100", ErrInvalidCoupon)
+		}
+	case common.CouponKindFixed:
+		if coupon.AmountOff.Currency == "" || coupon.AmountOff.Minor <= 0 {
+			return fmt.Errorf("%w: fixed coupons need a positive amountOff", ErrInvalidCoupon)
+		}
+	}
+	if coupon.MinSubtotal.IsNegative() {
+		return fmt.Errorf("%w: minSubtotal can't be negative", ErrInvalidCoupon)
+	}
+	if coupon.StartsAt != nil && coupon.EndsAt != nil && !coupon.EndsAt.After(*coupon.StartsAt) {
+		return fmt.Errorf("%w: endsAt must be after startsAt", ErrInvalidCoupon)
+	}
+	return nil
+}
+
+func (promotionManager *promotionManager) CreatePromotion(promotionData *common.PromotionCreationInput) (*models.Promotion, error) {
+	promotion := &models.Promotion{
+		Name:          strings.TrimSpace(promotionData.Name),
+		Kind:          promotionData.Kind,
+		BuyQuantity:   promotionData.BuyQuantity,
+		GetQuantity:   promotionData.GetQuantity,
+		GetPercentOff: 100,
+		StartsAt:      promotionData.StartsAt,
+		EndsAt:        promotionData.EndsAt,
+		Active:        promotionData.Active == nil || *promotionData.Active,
+		Tiers:         promotionTiers(promotionData.Tiers),
+	}
+	if promotionData.GetPercentOff != nil {
+		promotion.GetPercentOff = *promotionData.GetPercentOff
+	}
+	if err := validatePromotion(promotion); err != nil {
+		return nil, err
+	}
+
+	products, categories, err := findPromotionScope(promotionData.ProductIDs, promotionData.CategoryIDs)
+	if err != nil {
+		return nil, err
+	}
+	promotion.Products = products
+	promotion.Categories = categories
+
+	if err := database.DB.Create(promotion).Error; err != nil {
+		return nil, fmt.Errorf("failed to create promotion: %w", err)
+	}
+	return promotion, nil
+}
+
+func (promotionManager *promotionManager) ListPromotions() ([]models.Promotion, error) {
+	promotions := []models.Promotion{}
+	err := database.DB.Preload("Tiers").Preload("Products").Preload("Categories").Order("id DESC").Find(&promotions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list promotions: %w", err)
+	}
+	return promotions, nil
+}
+
+func (promotionManager *promotionManager) GetPromotion(promotionID uint) (*models.Promotion, error) {
+	var promotion models.Promotion
+	result := database.DB.Preload("Tiers").Preload("Products").Preload("Categories").First(&promotion, promotionID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrPromotionNotFound
+		}
+		return nil, fmt.Errorf("failed to get promotion: %w", result.Error)
+	}
+	return &promotion, nil
+}
+
+func (promotionManager *promotionManager) UpdatePromotion(promotionID uint, promotionData *common.PromotionUpdationInput) (*models.Promotion, error) {
+	promotion, err := promotionManager.GetPromotion(promotionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if promotionData.Name != nil {
+		promotion.Name = strings.TrimSpace(*promotionData.Name)
+	}
+	if promotionData.BuyQuantity != nil {
+		promotion.BuyQuantity = *promotionData.BuyQuantity
+	}
+	if promotionData.GetQuantity != nil {
+		promotion.GetQuantity = *promotionData.GetQuantity
+	}
+	if promotionData.GetPercentOff != nil {
+		promotion.GetPercentOff = *promotionData.GetPercentOff
+	}
+	if promotionData.StartsAt != nil {
+		promotion.StartsAt = promotionData.StartsAt
+	}
+	if promotionData.EndsAt != nil {
+		promotion.EndsAt = promotionData.EndsAt
+	}
+	if promotionData.Active != nil {
+		promotion.Active = *promotionData.Active
+	}
+	if promotionData.Tiers != nil {
+		promotion.Tiers = promotionTiers(promotionData.Tiers)
+	}
+	if err := validatePromotion(promotion); err != nil {
+		return nil, err
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if promotionData.Tiers != nil {
+			if err := tx.Where("promotion_id = ?", promotion.Id).Delete(&models.PromotionTier{}).Error; err != nil {
+				return fmt.Errorf("failed to replace promotion tiers: %w", err)
+			}
+			for i := range promotion.Tiers {
+				promotion.Tiers[i].PromotionID = promotion.Id
+			}
+			if len(promotion.Tiers) > 0 {
+				if err := tx.Create(&promotion.Tiers).Error; err != nil {
+					return fmt.Errorf("failed to replace promotion tiers: %w", err)
+				}
+			}
+		}
+		if err := replacePromotionScope(tx, promotion, promotionData.ProductIDs, promotionData.CategoryIDs); err != nil {
+			return err
+		}
+		if err := tx.Omit("Tiers", "Products", "Categories").Save(promotion).Error; err != nil {
+			return fmt.Errorf("failed to update promotion: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return promotionManager.GetPromotion(promotionID)
+}
+
+func promotionTiers(tierData []common.PromotionTierInput) []models.PromotionTier {
+	tiers := make([]models.PromotionTier, 0, len(tierData))
+	for _, tier := range tierData {
+		tiers = append(tiers, models.PromotionTier{MinSubtotal: tier.MinSubtotal, PercentOff: tier.PercentOff})
+	}
+	return tiers
+}
+
+func validatePromotion(promotion *models.Promotion) error {
+	if promotion.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidPromotion)
+	}
+	switch promotion.Kind {
+	case common.PromotionKindBuyXGetY:
+		if promotion.BuyQuantity < 1 || promotion.GetQuantity < 1 {
+			return fmt.Errorf("%w: buy X get Y promotions need a buyQuantity and getQuantity of at least 1", ErrInvalidPromotion)
+		}
+	case common.PromotionKindTiered:
+		if len(promotion.Tiers) == 0 {
+			return fmt.Errorf("%w: tiered promotions need at least one tier", ErrInvalidPromotion)
+		}
+		for _, tier := range promotion.Tiers {
+			if tier.MinSubtotal.Currency == "" || tier.MinSubtotal.IsNegative() {
+				return fmt.Errorf("%w: every tier needs a minSubtotal", ErrInvalidPromotion)
+			}
+		}
+	}
+	if promotion.StartsAt != nil && promotion.EndsAt != nil && !promotion.EndsAt.After(*promotion.StartsAt) {
+		return fmt.Errorf("%w: endsAt must be after startsAt", ErrInvalidPromotion)
+	}
+	return nil
+}
+
+// The products and categories a coupon or promotion is limited to
+func findPromotionScope(productIDs []uint, categoryIDs []uint) ([]models.Product, []models.Category, error) {
+	products := []models.Product{}
+	if len(productIDs) > 0 {
+		if err := database.DB.Find(&products, productIDs).Error; err != nil {
+			return nil, nil, fmt.Errorf("failed to find products: %w", err)
+		}
+		if len(products) != len(uniqueIDs(productIDs)) {
+			return nil, nil, ErrUnknownPromoScope
+		}
+	}
+
+	categories := []models.Category{}
+	if len(categoryIDs) > 0 {
+		if err := database.DB.Find(&categories, categoryIDs).Error; err != nil {
+			return nil, nil, fmt.Errorf("failed to find categories: %w", err)
+		}
+		if len(categories) != len(uniqueIDs(categoryIDs)) {
+			return nil, nil, ErrUnknownPromoScope
+		}
+	}
+	return products, categories, nil
+}
+
+// Replace the scope of a coupon or promotion with the given lists, leaving nil lists unchanged
+func replacePromotionScope(tx *gorm.DB, model interface{}, productIDs []uint, categoryIDs []uint) error {
+	if productIDs == nil && categoryIDs == nil {
+		return nil
+	}
+	products, categories, err := findPromotionScope(productIDs, categoryIDs)
+	if err != nil {
+		return err
+	}
+	if productIDs != nil {
+		if err := tx.Model(model).Association("Products").Replace(products); err != nil {
+			return fmt.Errorf("failed to update products: %w", err)
+		}
+	}
+	if categoryIDs != nil {
+		if err := tx.Model(model).Association("Categories").Replace(categories); err != nil {
+			return fmt.Errorf("failed to update categories: %w", err)
+		}
+	}
+	return nil
+}
+
+func uniqueIDs(ids []uint) map[uint]bool {
+	unique := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		unique[id] = true
+	}
+	return unique
+}
+
+// Whether a line is in the scope of a coupon or promotion. No products or categories means every line,
+// and a category covers its subcategories.
+func inPromotionScope(line *CartLine, products []models.Product, categories []models.Category) bool {
+	if len(products) == 0 && len(categories) == 0 {
+		return true
+	}
+	for _, product := range products {
+		if product.Id == line.ProductID {
+			return true
+		}
+	}
+	for _, category := range categories {
+		if line.inCategory(category.Id) {
+			return true
+		}
+	}
+	return false
+}
+
+func runsAt(active bool, startsAt *time.Time, endsAt *time.Time, now time.Time) bool {
+	if !active {
+		return false
+	}
+	if startsAt != nil && now.Before(*startsAt) {
+		return false
+	}
+	return endsAt == nil || now.Before(*endsAt)
+}
+
+// Why a coupon can't be used by the user on the lines it applies to, nil when it can.
+// eligible is what those lines cost after promotions.
+func checkCoupon(db *gorm.DB, coupon *models.Coupon, userID uint, lines []*CartLine, eligible common.Money, now time.Time) error {
+	if !runsAt(coupon.Active, coupon.StartsAt, coupon.EndsAt, now) {
+		return ErrCouponNotActive
+	}
+	if coupon.UsageLimit != nil && coupon.TimesUsed >= *coupon.UsageLimit {
+		return ErrCouponUsedUp
+	}
+	if coupon.PerUserLimit != nil {
+		var used int64
+		err := db.Model(&models.CouponRedemption{}).Where("coupon_id = ? AND user_id = ?", coupon.Id, userID).Count(&used).Error
+		if err != nil {
+			return fmt.Errorf("failed to count coupon redemptions: %w", err)
+		}
+		if used >= int64(*coupon.PerUserLimit) {
+			return ErrCouponUserLimit
+		}
+	}
+
+	if len(lines) == 0 {
+		return ErrCouponNotApplicable
+	}
+	if coupon.Kind == common.CouponKindFixed && coupon.AmountOff.Currency != eligible.Currency {
+		return fmt.Errorf("%w: it is for carts in %s", ErrCouponNotApplicable, coupon.AmountOff.Currency)
+	}
+	if coupon.MinSubtotal.Currency != "" {
+		if coupon.MinSubtotal.Currency != eligible.Currency {
+			return fmt.Errorf("%w: it is for carts in %s", ErrCouponNotApplicable, coupon.MinSubtotal.Currency)
+		}
+		if eligible.Minor < coupon.MinSubtotal.Minor {
+			return fmt.Errorf("%w of %s", ErrCouponMinimum, coupon.MinSubtotal)
+		}
+	}
+	return nil
+}
+
+// Record the use of the order's coupon, counting it against the coupon's limits.
+// The coupon row must be locked by the caller.
+func redeemCoupon(tx *gorm.DB, coupon *models.Coupon, order *models.Order) error {
+	redemption := &models.CouponRedemption{CouponID: coupon.Id, UserID: order.UserID, OrderID: order.Id}
+	if err := tx.Create(redemption).Error; err != nil {
+		return fmt.Errorf("failed to redeem coupon: %w", err)
+	}
+	if err := tx.Model(coupon).UpdateColumn("times_used", gorm.Expr("times_used + 1")).Error; err != nil {
+		return fmt.Errorf("failed to count coupon use: %w", err)
+	}
+	return nil
+}
+
+// Give back the coupon use of an order that won't be fulfilled
+func releaseCoupon(tx *gorm.DB, order *models.Order) error {
+	var redemption models.CouponRedemption
+	result := tx.Where("order_id = ?", order.Id).Limit(1).Find(&redemption)
+	if result.Error != nil {
+		return fmt.Errorf("failed to find coupon redemption: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	if err := tx.Delete(&redemption).Error; err != nil {
+		return fmt.Errorf("failed to release coupon: %w", err)
+	}
+	err := tx.Model(&models.Coupon{}).Where("id = ? AND times_used > 0", redemption.CouponID).
+		UpdateColumn("times_used", gorm.Expr("times_used - 1")).Error
+	if err != nil {
+		return fmt.Errorf("failed to release coupon: %w", err)
+	}
+	return nil
+}
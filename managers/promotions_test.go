@@ -0,0 +1,134 @@
+package managers
+
+import (
+	"errors"
+	"main/common"
+	"main/database"
+	"main/models"
+	"sync"
+	"testing"
+)
+
+func TestCheckoutCouponLimits(t *testing.T) {
+	one := func() *int { limit := 1; return &limit }
+
+	tests := []struct {
+		name         string
+		usageLimit   *int
+		perUserLimit *int
+		// Who checks out with the coupon, in turn, and how each checkout ends
+		checkouts []string
+		wantErrs  []error
+	}{
+		{name: "no limits", checkouts: []string{"bob", "bob", "eve"}, wantErrs: []error{nil, nil, nil}},
+		{name: "usage limit", usageLimit: one(), checkouts: []string{"bob", "eve"}, wantErrs: []error{nil, ErrCouponUsedUp}},
+		{name: "per user limit", perUserLimit: one(), checkouts: []string{"bob", "bob", "eve"}, wantErrs: []error{nil, ErrCouponUserLimit, nil}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestDB(t)
+			users := map[string]*models.User{
+				"bob": createTestUser(t, "bob@example.com"),
+				"eve": createTestUser(t, "eve@example.com"),
+			}
+			product := createTestProduct(t, 10)
+			coupon := &models.Coupon{Code: "TENOFF", Kind: common.CouponKindPercentage, PercentOff: 10, UsageLimit: test.usageLimit, PerUserLimit: test.perUserLimit, Active: true}
+			if err := database.DB.Create(coupon).Error; err != nil {
+				t.Fatalf("failed to create coupon: %v", err)
+			}
+
+			for i, name := range test.checkouts {
+				user := users[name]
+				database.DB.Where("user_id = ?", user.Id).Delete(&models.Cart{})
+				database.DB.Where("user_id = ?", user.Id).Delete(&models.CartCoupon{})
+				fillCart(t, user, product, 1, coupon)
+
+				order, err := NewOrderManager().Checkout(user.Id)
+				if !errors.Is(err, test.wantErrs[i]) {
+					t.Fatalf("checkout %d by %s: error = %v, want %v", i+1, name, err, test.wantErrs[i])
+				}
+				if err == nil && order.CouponCode != coupon.Code {
+					t.Errorf("checkout %d by %s: coupon code = %q, want %q", i+1, name, order.CouponCode, coupon.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestCouponUseGivenBackOnCancel(t *testing.T) {
+	useTestDB(t)
+	bob := createTestUser(t, "bob@example.com")
+	eve := createTestUser(t, "eve@example.com")
+	product := createTestProduct(t, 10)
+	limit := 1
+	coupon := &models.Coupon{Code: "ONCE", Kind: common.CouponKindPercentage, PercentOff: 10, UsageLimit: &limit, Active: true}
+	if err := database.DB.Create(coupon).Error; err != nil {
+		t.Fatalf("failed to create coupon: %v", err)
+	}
+	orderManager := NewOrderManager()
+
+	fillCart(t, bob, product, 1, coupon)
+	order, err := orderManager.Checkout(bob.Id)
+	if err != nil {
+		t.Fatalf("Checkout() error = %v", err)
+	}
+	fillCart(t, eve, product, 1, coupon)
+	if _, err := orderManager.Checkout(eve.Id); !errors.Is(err, ErrCouponUsedUp) {
+		t.Fatalf("Checkout() with the coupon used up error = %v, want %v", err, ErrCouponUsedUp)
+	}
+
+	if _, err := orderManager.Cancel(order.Id, bob.Id, ""); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	if _, err := orderManager.Checkout(eve.Id); err != nil {
+		t.Errorf("Checkout() after the only use was cancelled error = %v", err)
+	}
+}
+
+func TestConcurrentCheckoutsRespectUsageLimit(t *testing.T) {
+	useTestDB(t)
+	product := createTestProduct(t, 10)
+	limit := 1
+	coupon := &models.Coupon{Code: "ONCE", Kind: common.CouponKindPercentage, PercentOff: 10, UsageLimit: &limit, Active: true}
+	if err := database.DB.Create(coupon).Error; err != nil {
+		t.Fatalf("failed to create coupon: %v", err)
+	}
+
+	var users []*models.User
+	for _, email := range []string{"bob@example.com", "eve@example.com", "ann@example.com"} {
+		user := createTestUser(t, email)
+		fillCart(t, user, product, 1, coupon)
+		users = append(users, user)
+	}
+
+	errs := make(chan error, len(users))
+	var wg sync.WaitGroup
+	for _, user := range users {
+		wg.Add(1)
+		go func(userID uint) {
+			defer wg.Done()
+			_, err := NewOrderManager().Checkout(userID)
+			errs <- err
+		}(user.Id)
+	}
+	wg.Wait()
+	close(errs)
+
+	var placed int
+	for err := range errs {
+		switch {
+		case err == nil:
+			placed++
+		case !errors.Is(err, ErrCouponUsedUp):
+			t.Fatalf("Checkout() error = %v", err)
+		}
+	}
+	if placed != 1 {
+		t.Errorf("%d orders used the coupon, want 1", placed)
+	}
+	database.DB.First(coupon, coupon.Id)
+	if coupon.TimesUsed != 1 {
+		t.Errorf("coupon used %d times, want 1", coupon.TimesUsed)
+	}
+}
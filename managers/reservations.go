@@ -0,0 +1,181 @@
+package managers
+
+import (
+	"context"
+	"fmt"
+	"main/common"
+	"main/database"
+	"main/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// With reservations enabled, adding to the cart holds the quantity for the TTL so other
+// customers can't buy it meanwhile. Any change to the cart extends its holds.
+type reservationConfig struct {
+	enabled       bool
+	ttl           time.Duration
+	sweepInterval time.Duration
+}
+
+func loadReservationConfig() reservationConfig {
+	return reservationConfig{
+		enabled:       common.BoolFromEnv("CART_RESERVATIONS", false),
+		ttl:           common.DurationFromEnv("CART_RESERVATION_TTL", 15*time.Minute),
+		sweepInterval: common.DurationFromEnv("CART_RESERVATION_SWEEP_INTERVAL", time.Minute),
+	}
+}
+
+// When a hold placed now runs out, or nil when reservations are off
+func (config reservationConfig) holdUntil() *time.Time {
+	if !config.enabled {
+		return nil
+	}
+	until := time.Now().Add(config.ttl)
+	return &until
+}
+
+// Register the job that releases expired holds
+func RegisterReservationJobs(scheduler Scheduler) {
+	config := loadReservationConfig()
+	if !config.enabled {
+		return
+	}
+
+	scheduler.Add("cart_reservations", config.sweepInterval, func(ctx context.Context) (int64, error) {
+		result := database.DB.WithContext(ctx).Model(&models.Cart{}).
+			Where("reserved_until <= ?", time.Now()).
+			Update("reserved_until", nil)
+		if result.Error != nil {
+			return 0, fmt.Errorf("failed to release expired reservations: %w", result.Error)
+		}
+		return result.RowsAffected, nil
+	})
+}
+
+// Quantity of a product or variant held by the carts of users other than userID
+func reservedStock(db *gorm.DB, productID uint, variantID *uint, userID uint) (int, error) {
+	if !loadReservationConfig().enabled {
+		return 0, nil
+	}
+
+	query := db.Model(&models.Cart{}).Where("product_id = ? AND user_id <> ? AND reserved_until > ?", productID, userID, time.Now())
+	if variantID != nil {
+		query = query.Where("variant_id = ?", *variantID)
+	} else {
+		query = query.Where("variant_id IS NULL")
+	}
+
+	var reserved int64
+	if err := query.Select("COALESCE(SUM(quantity), 0)").Scan(&reserved).Error; err != nil {
+		return 0, fmt.Errorf("failed to sum reservations: %w", err)
+	}
+	return int(reserved), nil
+}
+
+// Stock of a product or variant that userID can still put in their cart or check out.
+// With lock, the stock row stays locked until db's transaction ends, so concurrent
+// carts and checkouts of the same stock are decided one after the other.
+func availableStock(db *gorm.DB, productID uint, variantID *uint, userID uint, lock bool) (int, error) {
+	level, err := loadStock(db, productID, variantID, lock)
+	if err != nil {
+		return 0, err
+	}
+
+	reserved, err := reservedStock(db, productID, level.variantID, userID)
+	if err != nil {
+		return 0, err
+	}
+	if available := level.stock - reserved; available > 0 {
+		return available, nil
+	}
+	return 0, nil
+}
+
+// Refuse cart quantities beyond the stock available to the user
+func checkCartStock(db *gorm.DB, userID uint, productID uint, variantID *uint, quantity uint) error {
+	available, err := availableStock(db, productID, variantID, userID, loadReservationConfig().enabled)
+	if err != nil {
+		return err
+	}
+	if int(quantity) > available {
+		return &InsufficientStockError{ProductID: productID, VariantID: variantID, Available: available}
+	}
+	return nil
+}
+
+// Push back the expiry of the holds a user's cart still has
+func extendHolds(db *gorm.DB, userID uint) error {
+	config := loadReservationConfig()
+	if !config.enabled {
+		return nil
+	}
+
+	result := db.Model(&models.Cart{}).
+		Where("user_id = ? AND reserved_until > ?", userID, time.Now()).
+		Update("reserved_until", config.holdUntil())
+	if result.Error != nil {
+		return fmt.Errorf("failed to extend reservations: %w", result.Error)
+	}
+	return nil
+}
+
+type heldStock struct {
+	ProductID uint
+	VariantID *uint
+	Quantity  int
+}
+
+// Set the quantity available to new carts on products and their preloaded variants
+func fillAvailability(products []models.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	held := map[[2]uint]int{}
+	if loadReservationConfig().enabled {
+		productIDs := make([]uint, 0, len(products))
+		for _, product := range products {
+			productIDs = append(productIDs, product.Id)
+		}
+
+		var rows []heldStock
+		result := database.DB.Model(&models.Cart{}).
+			Select("product_id, variant_id, SUM(quantity) AS quantity").
+			Where("product_id IN ? AND reserved_until > ?", productIDs, time.Now()).
+			Group("product_id, variant_id").
+			Scan(&rows)
+		if result.Error != nil {
+			return fmt.Errorf("failed to sum reservations: %w", result.Error)
+		}
+		for _, row := range rows {
+			held[[2]uint{row.ProductID, variantSortKey(row.VariantID)}] = row.Quantity
+		}
+	}
+
+	unheld := func(stock int, key [2]uint) *int {
+		available := stock - held[key]
+		if available < 0 {
+			available = 0
+		}
+		return &available
+	}
+
+	for i := range products {
+		product := &products[i]
+		if len(product.Variants) == 0 {
+			product.Available = unheld(product.Stock, [2]uint{product.Id, 0})
+			continue
+		}
+
+		total := 0
+		for j := range product.Variants {
+			variant := &product.Variants[j]
+			variant.Available = unheld(variant.Stock, [2]uint{product.Id, variant.Id})
+			total += *variant.Available
+		}
+		product.Available = &total
+	}
+	return nil
+}
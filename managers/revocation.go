@@ -0,0 +1,145 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/common"
+	"main/database"
+	"main/models"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm/clause"
+)
+
+// RevocationStore remembers access tokens that were revoked before they expired, keyed by their jti
+type RevocationStore interface {
+	Revoke(jti string, expiresAt time.Time) error
+	IsRevoked(jti string) (bool, error)
+	PurgeExpired() (int64, error)
+}
+
+var revocationStore RevocationStore = NewDBRevocationStore()
+
+// The store selected by InitializeRevocationStore, shared by every handler
+func Revocations() RevocationStore {
+	return revocationStore
+}
+
+// Select the revocation store from REVOCATION_STORE ("db" or "redis").
+// The database store keeps working on a single instance, multi-instance deployments should use redis.
+func InitializeRevocationStore() error {
+	switch strings.ToLower(os.Getenv("REVOCATION_STORE")) {
+	case "", "db":
+		revocationStore = NewDBRevocationStore()
+	case "redis":
+		store, err := NewRedisRevocationStore(os.Getenv("REDIS_ADDR"), os.Getenv("REDIS_PASSWORD"), common.IntFromEnv("REDIS_DB", 0))
+		if err != nil {
+			return err
+		}
+		revocationStore = store
+	default:
+		return fmt.Errorf("unknown REVOCATION_STORE %q", os.Getenv("REVOCATION_STORE"))
+	}
+	return nil
+}
+
+type dbRevocationStore struct {
+}
+
+func NewDBRevocationStore() RevocationStore {
+	return &dbRevocationStore{}
+}
+
+func (store *dbRevocationStore) Revoke(jti string, expiresAt time.Time) error {
+	revoked := &models.RevokedToken{
+		Jti:       jti,
+		ExpiresAt: expiresAt,
+	}
+
+	// Revoking twice is not an error
+	result := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(revoked)
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke token: %w", result.Error)
+	}
+	return nil
+}
+
+func (store *dbRevocationStore) IsRevoked(jti string) (bool, error) {
+	var count int64
+	result := database.DB.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to check token revocation: %w", result.Error)
+	}
+	return count > 0, nil
+}
+
+// Remove entries for tokens that have expired anyway
+func (store *dbRevocationStore) PurgeExpired() (int64, error) {
+	result := database.DB.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge revoked tokens: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+type redisRevocationStore struct {
+	client *redis.Client
+}
+
+// Revocation store for any Redis-protocol server (Redis, Valkey, KeyDB, ...)
+func NewRedisRevocationStore(addr, password string, db int) (RevocationStore, error) {
+	if addr == "" {
+		return nil, errors.New("REDIS_ADDR is required for the redis revocation store")
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	return &redisRevocationStore{client: client}, nil
+}
+
+func revocationKey(jti string) string {
+	return "revoked_token:" + jti
+}
+
+func (store *redisRevocationStore) Revoke(jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := store.client.Set(ctx, revocationKey(jti), 1, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+func (store *redisRevocationStore) IsRevoked(jti string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	count, err := store.client.Exists(ctx, revocationKey(jti)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	return count > 0, nil
+}
+
+// Redis expires the keys itself
+func (store *redisRevocationStore) PurgeExpired() (int64, error) {
+	return 0, nil
+}
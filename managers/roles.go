@@ -0,0 +1,313 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"main/common"
+	"main/database"
+	"main/models"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRoleNotFound      = errors.New("role not found")
+	ErrRoleAlreadyExists = errors.New("role already exists")
+	ErrSystemRole        = errors.New("system roles cannot be deleted")
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrUserNotFound      = errors.New("user not found")
+)
+
+type RoleManager interface {
+	Create(roleData *common.RoleCreationInput) (*models.Role, error)
+	List() ([]models.Role, error)
+	Get(id string) (*models.Role, error)
+	Update(roleID string, roleData *common.RoleUpdationInput) (*models.Role, error)
+	Delete(id string) error
+	ListPermissions() ([]models.Permission, error)
+	AssignRole(userID string, roleName string) (*models.User, error)
+	SeedRoles() error
+	EnsureAdmin(email string) error
+}
+
+type roleManager struct {
+}
+
+func NewRoleManager() RoleManager {
+	return &roleManager{}
+}
+
+// Create a custom role
+func (roleManager *roleManager) Create(roleData *common.RoleCreationInput) (*models.Role, error) {
+	name := strings.ToLower(strings.TrimSpace(roleData.Name))
+
+	var existing models.Role
+	result := database.DB.Where("name = ?", name).First(&existing)
+	if result.Error == nil {
+		return nil, ErrRoleAlreadyExists
+	} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to check role existence: %w", result.Error)
+	}
+
+	permissions, err := findPermissions(roleData.Permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	newRole := &models.Role{
+		Name:        name,
+		Description: roleData.Description,
+		Permissions: permissions,
+	}
+
+	result = database.DB.Create(newRole)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to create role: %w", result.Error)
+	}
+
+	return newRole, nil
+}
+
+func (roleManager *roleManager) List() ([]models.Role, error) {
+	var roles []models.Role
+	result := database.DB.Preload("Permissions").Find(&roles)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", result.Error)
+	}
+	return roles, nil
+}
+
+func (roleManager *roleManager) Get(id string) (*models.Role, error) {
+	var role models.Role
+	result := database.DB.Preload("Permissions").First(&role, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, fmt.Errorf("failed to get role: %w", result.Error)
+	}
+	return &role, nil
+}
+
+// Update the description and replace the permission set of a role
+func (roleManager *roleManager) Update(roleID string, roleData *common.RoleUpdationInput) (*models.Role, error) {
+	role, err := roleManager.Get(roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if roleData.Description != "" {
+		role.Description = roleData.Description
+	}
+
+	if roleData.Permissions != nil {
+		permissions, err := findPermissions(roleData.Permissions)
+		if err != nil {
+			return nil, err
+		}
+
+		err = database.DB.Model(role).Association("Permissions").Replace(permissions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update role permissions: %w", err)
+		}
+	}
+
+	result := database.DB.Omit("Permissions").Save(role)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update role: %w", result.Error)
+	}
+
+	return roleManager.Get(roleID)
+}
+
+func (roleManager *roleManager) Delete(id string) error {
+	role, err := roleManager.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if role.IsSystem {
+		return ErrSystemRole
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		// Users holding the role fall back to the customer role
+		customerID := defaultRoleID(tx)
+		if err := tx.Model(&models.User{}).Where("role_id = ?", role.Id).Update("role_id", customerID).Error; err != nil {
+			return fmt.Errorf("failed to reassign users of role: %w", err)
+		}
+
+		if err := tx.Model(role).Association("Permissions").Clear(); err != nil {
+			return fmt.Errorf("failed to clear role permissions: %w", err)
+		}
+
+		if err := tx.Delete(role).Error; err != nil {
+			return fmt.Errorf("failed to delete role: %w", err)
+		}
+		return nil
+	})
+}
+
+func (roleManager *roleManager) ListPermissions() ([]models.Permission, error) {
+	var permissions []models.Permission
+	result := database.DB.Order("name").Find(&permissions)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to list permissions: %w", result.Error)
+	}
+	return permissions, nil
+}
+
+func (roleManager *roleManager) AssignRole(userID string, roleName string) (*models.User, error) {
+	var role models.Role
+	result := database.DB.Where("name = ?", strings.ToLower(roleName)).First(&role)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, fmt.Errorf("failed to find role: %w", result.Error)
+	}
+
+	var user models.User
+	result = database.DB.First(&user, userID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to find user: %w", result.Error)
+	}
+
+	result = database.DB.Model(&user).Update("role_id", role.Id)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to assign role: %w", result.Error)
+	}
+
+	user.Role = &role
+	return &user, nil
+}
+
+// Seeding the permission catalogue and the built-in roles
+func (roleManager *roleManager) SeedRoles() error {
+	for name, description := range common.AllPermissions {
+		permission := models.Permission{Name: name}
+		result := database.DB.Where(permission).Attrs(models.Permission{Description: description}).FirstOrCreate(&permission)
+		if result.Error != nil {
+			return fmt.Errorf("failed to seed permission %s: %w", name, result.Error)
+		}
+	}
+
+	var allPermissions []models.Permission
+	if err := database.DB.Find(&allPermissions).Error; err != nil {
+		return fmt.Errorf("failed to load permissions: %w", err)
+	}
+
+	systemRoles := []models.Role{
+		{Name: common.RoleCustomer, Description: "Shoppers managing their own account, cart and wishlist"},
+		// Staff start without extra permissions, admins grant them through /api/roles
+		{Name: common.RoleStaff, Description: "Store staff"},
+		{Name: common.RoleAdmin, Description: "Full access to every endpoint"},
+	}
+
+	for _, systemRole := range systemRoles {
+		role := models.Role{Name: systemRole.Name}
+		result := database.DB.Where(role).Attrs(models.Role{Description: systemRole.Description, IsSystem: true}).FirstOrCreate(&role)
+		if result.Error != nil {
+			return fmt.Errorf("failed to seed role %s: %w", systemRole.Name, result.Error)
+		}
+
+		// Admins always hold every permission, including ones added after the role was created
+		if role.Name == common.RoleAdmin {
+			if err := database.DB.Model(&role).Association("Permissions").Replace(allPermissions); err != nil {
+				return fmt.Errorf("failed to grant admin permissions: %w", err)
+			}
+		}
+	}
+
+	result := database.DB.Model(&models.User{}).Where("role_id IS NULL").Update("role_id", defaultRoleID(database.DB))
+	if result.Error != nil {
+		return fmt.Errorf("failed to backfill user roles: %w", result.Error)
+	}
+
+	return nil
+}
+
+// Promote the account configured through ADMIN_EMAIL so a fresh install has an administrator
+func (roleManager *roleManager) EnsureAdmin(email string) error {
+	if email == "" {
+		return nil
+	}
+
+	_, err := roleManager.assignRoleByEmail(email, common.RoleAdmin)
+	if errors.Is(err, ErrUserNotFound) {
+		log.Printf("Admin account %s does not exist yet, sign up and restart to promote it", email)
+		return nil
+	}
+	return err
+}
+
+func (roleManager *roleManager) assignRoleByEmail(email string, roleName string) (*models.User, error) {
+	var user models.User
+	result := database.DB.Where("email = ?", email).First(&user)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to find user: %w", result.Error)
+	}
+
+	return roleManager.AssignRole(fmt.Sprint(user.Id), roleName)
+}
+
+// HasPermission reports whether the user's role grants the permission.
+// The user must be loaded with Role.Permissions preloaded.
+func HasPermission(user *models.User, permission string) bool {
+	if user == nil || user.Role == nil {
+		return false
+	}
+
+	for _, granted := range user.Role.Permissions {
+		if granted.Name == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func findPermissions(names []string) ([]models.Permission, error) {
+	permissions := []models.Permission{}
+	if len(names) == 0 {
+		return permissions, nil
+	}
+
+	// Listing a permission twice grants it once
+	unique := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+
+	result := database.DB.Where("name IN ?", unique).Find(&permissions)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to find permissions: %w", result.Error)
+	}
+
+	if len(permissions) != len(unique) {
+		return nil, ErrUnknownPermission
+	}
+
+	return permissions, nil
+}
+
+func defaultRoleID(db *gorm.DB) *uint {
+	var role models.Role
+	result := db.Where("name = ?", common.RoleCustomer).First(&role)
+	if result.Error != nil {
+		log.Printf("Customer role not found: %v", result.Error)
+		return nil
+	}
+	return &role.Id
+}
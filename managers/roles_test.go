@@ -0,0 +1,51 @@
+package managers
+
+import (
+	"errors"
+	"main/common"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFindPermissions(t *testing.T) {
+	useTestDB(t)
+	if err := NewRoleManager().SeedRoles(); err != nil {
+		t.Fatalf("SeedRoles() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		names   []string
+		want    []string
+		wantErr error
+	}{
+		{name: "none", names: nil, want: []string{}},
+		{name: "one", names: []string{common.PermissionCatalogWrite}, want: []string{common.PermissionCatalogWrite}},
+		{name: "several", names: []string{common.PermissionUsersManage, common.PermissionCatalogWrite}, want: []string{common.PermissionCatalogWrite, common.PermissionUsersManage}},
+		{name: "listed twice", names: []string{common.PermissionCatalogWrite, common.PermissionCatalogWrite}, want: []string{common.PermissionCatalogWrite}},
+		{name: "unknown", names: []string{common.PermissionCatalogWrite, "catalog:burn"}, wantErr: ErrUnknownPermission},
+		{name: "unknown listed twice", names: []string{"catalog:burn", "catalog:burn"}, wantErr: ErrUnknownPermission},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			permissions, err := findPermissions(test.names)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("findPermissions(%v) error = %v, want %v", test.names, err, test.wantErr)
+			}
+			if test.wantErr != nil {
+				return
+			}
+
+			got := make([]string, 0, len(permissions))
+			for _, permission := range permissions {
+				got = append(got, permission.Name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("findPermissions(%v) = %v, want %v", test.names, got, test.want)
+			}
+		})
+	}
+}
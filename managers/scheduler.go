@@ -0,0 +1,152 @@
+package managers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"main/common"
+	"sync"
+	"time"
+)
+
+// A background job returns how many rows it removed or, for the outbox, sent
+type JobFunc func(ctx context.Context) (int64, error)
+
+// Scheduler runs jobs at fixed intervals inside the API process
+type Scheduler interface {
+	Add(name string, interval time.Duration, run JobFunc)
+	Start()
+	Stop()
+	Stats() []common.JobStats
+}
+
+type scheduledJob struct {
+	name     string
+	interval time.Duration
+	run      JobFunc
+	stats    common.JobStats
+}
+
+type scheduler struct {
+	mu     sync.Mutex
+	jobs   []*scheduledJob
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewScheduler() Scheduler {
+	return &scheduler{}
+}
+
+// Register a job, jobs added after Start are not run
+func (scheduler *scheduler) Add(name string, interval time.Duration, run JobFunc) {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	scheduler.jobs = append(scheduler.jobs, &scheduledJob{
+		name:     name,
+		interval: interval,
+		run:      run,
+		stats: common.JobStats{
+			Name:     name,
+			Interval: interval.String(),
+		},
+	})
+}
+
+// Run every job once now and then on its interval until Stop
+func (scheduler *scheduler) Start() {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	if scheduler.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduler.cancel = cancel
+
+	for _, job := range scheduler.jobs {
+		scheduler.wg.Add(1)
+		go func(job *scheduledJob) {
+			defer scheduler.wg.Done()
+
+			ticker := time.NewTicker(job.interval)
+			defer ticker.Stop()
+
+			for {
+				scheduler.runJob(ctx, job)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(job)
+	}
+}
+
+// Cancel running jobs and wait for them to return
+func (scheduler *scheduler) Stop() {
+	scheduler.mu.Lock()
+	cancel := scheduler.cancel
+	scheduler.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	scheduler.wg.Wait()
+}
+
+func (scheduler *scheduler) Stats() []common.JobStats {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	stats := make([]common.JobStats, 0, len(scheduler.jobs))
+	for _, job := range scheduler.jobs {
+		stats = append(stats, job.stats)
+	}
+	return stats
+}
+
+func (scheduler *scheduler) runJob(ctx context.Context, job *scheduledJob) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	started := time.Now()
+	removed, err := runRecovered(ctx, job.run)
+	duration := time.Since(started)
+
+	scheduler.mu.Lock()
+	job.stats.Runs++
+	job.stats.LastRunAt = &started
+	job.stats.LastDuration = duration.Round(time.Millisecond).String()
+	job.stats.LastRemoved = removed
+	job.stats.RemovedTotal += removed
+	job.stats.LastError = ""
+	if err != nil {
+		job.stats.Failures++
+		job.stats.LastError = err.Error()
+	}
+	scheduler.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Job %s failed after %s: %v", job.name, duration.Round(time.Millisecond), err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("Job %s affected %d rows in %s", job.name, removed, duration.Round(time.Millisecond))
+	}
+}
+
+// A panicking job is reported as a failure instead of taking the process down
+func runRecovered(ctx context.Context, run JobFunc) (removed int64, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	return run(ctx)
+}
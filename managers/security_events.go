@@ -0,0 +1,38 @@
+package managers
+
+import (
+	"log/slog"
+	"os"
+)
+
+// Security events are written as JSON lines so they can be shipped to a log pipeline and alerted on
+var securityLogger = slog.New(slog.NewJSONHandler(os.Stdout, nil)).With("category", "security")
+
+const (
+	EventLoginSucceeded       = "login_succeeded"
+	EventLoginFailed          = "login_failed"
+	EventLoginThrottled       = "login_throttled"
+	EventAccountLocked        = "account_locked"
+	EventAccountUnlocked      = "account_unlocked"
+	EventRefreshTokenReused   = "refresh_token_reused"
+	EventTwoFactorEnabled     = "two_factor_enabled"
+	EventTwoFactorDisabled    = "two_factor_disabled"
+	EventRecoveryCodeUsed     = "recovery_code_used"
+	EventTwoFactorChallenged  = "two_factor_challenged"
+	EventOtpLoginRequested    = "otp_login_requested"
+	EventOtpRateLimited       = "otp_rate_limited"
+	EventOtpAttemptsExceeded  = "otp_attempts_exceeded"
+	EventPhoneVerified        = "phone_verified"
+	EventEmailChangeRequested = "email_change_requested"
+	EventEmailChanged         = "email_changed"
+)
+
+// Record a security event. Never pass passwords, hashes or tokens as attributes.
+func logSecurityEvent(event string, attrs ...any) {
+	securityLogger.Info(event, attrs...)
+}
+
+// Record a security event that needs attention
+func warnSecurityEvent(event string, attrs ...any) {
+	securityLogger.Warn(event, attrs...)
+}
@@ -0,0 +1,190 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"main/common"
+	"main/database"
+	"main/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionRevoked  = errors.New("session revoked")
+)
+
+// How often last-seen is written back, so every request doesn't cost an UPDATE
+const sessionTouchInterval = time.Minute
+
+type SessionManager interface {
+	List(userID uint) ([]models.Session, error)
+	Touch(sessionID uint, ipAddress string) (*models.Session, error)
+	Revoke(userID uint, sessionID uint) error
+	RevokeOthers(userID uint, currentSessionID uint) (int64, error)
+	RevokeAll(userID uint) error
+}
+
+type sessionManager struct {
+}
+
+func NewSessionManager() SessionManager {
+	return &sessionManager{}
+}
+
+// Active sessions of a user, most recently used first
+func (sessionManager *sessionManager) List(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	result := database.DB.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", result.Error)
+	}
+	return sessions, nil
+}
+
+// Check that the session is still active and record that it was just used
+func (sessionManager *sessionManager) Touch(sessionID uint, ipAddress string) (*models.Session, error) {
+	var session models.Session
+	result := database.DB.First(&session, sessionID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to find session: %w", result.Error)
+	}
+
+	if session.RevokedAt != nil || session.ExpiresAt.Before(time.Now()) {
+		return nil, ErrSessionRevoked
+	}
+
+	if time.Since(session.LastSeenAt) >= sessionTouchInterval || session.IPAddress != ipAddress {
+		session.LastSeenAt = time.Now()
+		session.IPAddress = ipAddress
+		result = database.DB.Model(&session).Select("last_seen_at", "ip_address").Updates(&session)
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed to update session: %w", result.Error)
+		}
+	}
+
+	return &session, nil
+}
+
+func (sessionManager *sessionManager) Revoke(userID uint, sessionID uint) error {
+	var session models.Session
+	result := database.DB.Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).First(&session)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return fmt.Errorf("failed to find session: %w", result.Error)
+	}
+
+	return revokeSessions(database.DB.Where("id = ?", session.Id))
+}
+
+// Log out everywhere except the current session
+func (sessionManager *sessionManager) RevokeOthers(userID uint, currentSessionID uint) (int64, error) {
+	var count int64
+	scope := database.DB.Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, currentSessionID)
+	if err := scope.Model(&models.Session{}).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count sessions: %w", err)
+	}
+
+	if err := revokeSessions(database.DB.Where("user_id = ? AND id <> ?", userID, currentSessionID)); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (sessionManager *sessionManager) RevokeAll(userID uint) error {
+	return revokeSessions(database.DB.Where("user_id = ?", userID))
+}
+
+// Start a session for a successful login
+func startSession(db *gorm.DB, user *models.User, client common.ClientInfo) (*models.Session, error) {
+	deviceLabel := client.DeviceLabel
+	if deviceLabel == "" {
+		deviceLabel = common.DeviceLabelFromUserAgent(client.UserAgent)
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		UserID:      user.Id,
+		LastSeenAt:  now,
+		IPAddress:   client.IPAddress,
+		UserAgent:   truncate(client.UserAgent, 512),
+		DeviceLabel: truncate(deviceLabel, 191),
+		ExpiresAt:   now.Add(refreshTokenTTL()),
+	}
+
+	if err := db.Create(session).Error; err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return session, nil
+}
+
+// Revoke the active sessions matched by scope together with their refresh tokens and current access tokens
+func revokeSessions(scope *gorm.DB) error {
+	var sessions []models.Session
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		sessions, err = revokeSessionRecords(tx, scope)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return revokeSessionAccessTokens(sessions)
+}
+
+// Mark the active sessions matched by scope and their refresh tokens revoked within tx, returning
+// the sessions so their access tokens can be revoked once tx commits
+func revokeSessionRecords(tx *gorm.DB, scope *gorm.DB) ([]models.Session, error) {
+	var sessions []models.Session
+	if err := scope.Where("revoked_at IS NULL").Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("failed to find sessions: %w", err)
+	}
+	if len(sessions) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]uint, 0, len(sessions))
+	for _, session := range sessions {
+		ids = append(ids, session.Id)
+	}
+
+	now := time.Now()
+	if err := tx.Model(&models.Session{}).Where("id IN ?", ids).Update("revoked_at", now).Error; err != nil {
+		return nil, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	if err := tx.Model(&models.RefreshToken{}).Where("session_id IN ? AND revoked_at IS NULL", ids).Update("revoked_at", now).Error; err != nil {
+		return nil, fmt.Errorf("failed to revoke session refresh tokens: %w", err)
+	}
+	return sessions, nil
+}
+
+// AuthMiddleware rejects these through the session check, revoking the jti keeps the revocation store complete too
+func revokeSessionAccessTokens(sessions []models.Session) error {
+	expiresAt := time.Now().Add(common.AccessTokenTTL())
+	for _, session := range sessions {
+		if session.Jti == "" {
+			continue
+		}
+		if err := Revocations().Revoke(session.Jti, expiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func truncate(value string, length int) string {
+	if len(value) <= length {
+		return value
+	}
+	return value[:length]
+}
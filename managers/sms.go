@@ -0,0 +1,180 @@
+package managers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"main/common"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	twilio "github.com/twilio/twilio-go"
+	openapi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+// SMSSender delivers text messages, so OTP flows do not depend on one gateway
+type SMSSender interface {
+	Send(to string, body string) error
+}
+
+// Select the SMS provider from SMS_PROVIDER ("twilio", "http", "outbox" or "console").
+// The outbox and console deliver nothing, so they are refused in release mode.
+func NewSMSSenderFromEnv() (SMSSender, error) {
+	provider := strings.ToLower(os.Getenv("SMS_PROVIDER"))
+	if (provider == "outbox" || provider == "console") && common.IsReleaseMode() {
+		return nil, fmt.Errorf("the %s SMS provider can't be used in release mode", provider)
+	}
+
+	switch provider {
+	case "":
+		return nil, errors.New("SMS_PROVIDER is required")
+	case "twilio":
+		return NewTwilioSMSSender(os.Getenv("TWILIO_ACCOUNT_SID"), os.Getenv("TWILIO_AUTH_TOKEN"), os.Getenv("TWILIO_PHONE_NUMBER"))
+	case "http":
+		return NewHTTPSMSSender(os.Getenv("SMS_HTTP_URL"), os.Getenv("SMS_HTTP_TOKEN"), os.Getenv("SMS_FROM"))
+	case "outbox":
+		path := os.Getenv("SMS_OUTBOX_PATH")
+		if path == "" {
+			path = "sms_outbox.jsonl"
+		}
+		return NewOutboxSMSSender(path), nil
+	case "console":
+		return NewOutboxSMSSender(""), nil
+	default:
+		return nil, fmt.Errorf("unknown SMS_PROVIDER %q", os.Getenv("SMS_PROVIDER"))
+	}
+}
+
+type twilioSMSSender struct {
+	client *twilio.RestClient
+	from   string
+}
+
+func NewTwilioSMSSender(accountSid, authToken, from string) (SMSSender, error) {
+	if accountSid == "" || authToken == "" || from == "" {
+		return nil, errors.New("TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_PHONE_NUMBER are required for the twilio SMS provider")
+	}
+
+	client := twilio.NewRestClientWithParams(twilio.ClientParams{
+		Username: accountSid,
+		Password: authToken,
+	})
+
+	return &twilioSMSSender{client: client, from: from}, nil
+}
+
+func (sender *twilioSMSSender) Send(to string, body string) error {
+	messageInput := &openapi.CreateMessageParams{}
+	messageInput.SetTo(to)
+	messageInput.SetFrom(sender.from)
+	messageInput.SetBody(body)
+
+	_, err := sender.client.Api.CreateMessage(messageInput)
+	if err != nil {
+		return fmt.Errorf("twilio error: %w", err)
+	}
+	return nil
+}
+
+type httpSMSSender struct {
+	url    string
+	token  string
+	from   string
+	client *http.Client
+}
+
+// Send through any gateway that accepts a JSON POST of {"to", "from", "body"}.
+// The token, when set, is sent as a bearer token.
+func NewHTTPSMSSender(url, token, from string) (SMSSender, error) {
+	if url == "" {
+		return nil, errors.New("SMS_HTTP_URL is required for the http SMS provider")
+	}
+
+	return &httpSMSSender{
+		url:    url,
+		token:  token,
+		from:   from,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (sender *httpSMSSender) Send(to string, body string) error {
+	payload, err := json.Marshal(map[string]string{
+		"to":   to,
+		"from": sender.from,
+		"body": body,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode SMS: %w", err)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, sender.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build SMS request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if sender.token != "" {
+		request.Header.Set("Authorization", "Bearer "+sender.token)
+	}
+
+	response, err := sender.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to reach SMS gateway: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("SMS gateway responded %d: %s", response.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+type outboxSMSSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Write messages to a local JSON lines file instead of sending them, for development and tests.
+// An empty path only logs that a message was sent, never its body.
+func NewOutboxSMSSender(path string) SMSSender {
+	return &outboxSMSSender{path: path}
+}
+
+type outboxMessage struct {
+	To     string    `json:"to"`
+	Body   string    `json:"body"`
+	SentAt time.Time `json:"sentAt"`
+}
+
+func (sender *outboxSMSSender) Send(to string, body string) error {
+	if sender.path == "" {
+		log.Printf("SMS to %s not sent, %d characters", to, len(body))
+		return nil
+	}
+
+	line, err := json.Marshal(outboxMessage{To: to, Body: body, SentAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to encode SMS: %w", err)
+	}
+
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	file, err := os.OpenFile(sender.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open SMS outbox: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write SMS outbox: %w", err)
+	}
+	return nil
+}
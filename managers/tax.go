@@ -0,0 +1,299 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"main/common"
+	"main/database"
+	"main/models"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidTaxRate = errors.New("invalid tax rate")
+	ErrInvalidHSNCode = errors.New("HSN code must be 4, 6 or 8 digits")
+)
+
+// A tax charged on a line, such as CGST at 9%
+type TaxComponent struct {
+	Name string `json:"name"`
+	// Percentage, such as "9" or "0.125"
+	Rate   string       `json:"rate"`
+	Amount common.Money `json:"amount"`
+}
+
+// A cart or order line as tax calculators see it
+type TaxableLine struct {
+	// What is charged for the line after discounts, tax included when Inclusive
+	Amount          common.Money
+	Inclusive       bool
+	RateBasisPoints int
+	HSNCode         string
+	ShipTo          models.Address
+}
+
+// TaxCalculator applies one jurisdiction's rules to cart lines, so pricing does not depend
+// on a single tax regime. The components of an inclusive line add up to the tax in its amount.
+type TaxCalculator interface {
+	Name() string
+	// Whether products and categories may be given this rate
+	ValidRate(rateBasisPoints int) bool
+	Calculate(line *TaxableLine) ([]TaxComponent, error)
+}
+
+var taxCalculator TaxCalculator = NewFlatTaxCalculator()
+
+// Select the tax rules from TAX_REGIME ("flat" or "gst"), flat when unset
+func InitializeTaxCalculator() error {
+	switch strings.ToLower(os.Getenv("TAX_REGIME")) {
+	case "", "flat":
+		taxCalculator = NewFlatTaxCalculator()
+	case "gst":
+		calculator, err := NewGSTTaxCalculator(os.Getenv("SELLER_STATE"))
+		if err != nil {
+			return err
+		}
+		taxCalculator = calculator
+	default:
+		return fmt.Errorf("unknown TAX_REGIME %q", os.Getenv("TAX_REGIME"))
+	}
+	if err := checkTaxRates(taxCalculator); err != nil {
+		return err
+	}
+	log.Printf("Taxes are calculated with the %s rules", taxCalculator.Name())
+	return nil
+}
+
+// Refuse rules that the fallback TAX_RATE, or a rate stored on a product or category, is not valid under,
+// such as 10% under GST after switching regimes
+func checkTaxRates(calculator TaxCalculator) error {
+	if rate := loadPricingConfig().taxRateBasisPoints; !calculator.ValidRate(rate) {
+		return fmt.Errorf("%w: TAX_RATE %s%% is not a %s rate", ErrInvalidTaxRate, formatTaxRate(rate, 1), calculator.Name())
+	}
+
+	for _, table := range []struct {
+		model interface{}
+		name  string
+	}{{&models.Product{}, "products"}, {&models.Category{}, "categories"}} {
+		var rated []struct {
+			Id                 uint
+			TaxRateBasisPoints int
+		}
+		result := database.DB.Model(table.model).Where("tax_rate_basis_points IS NOT NULL").Select("id", "tax_rate_basis_points").Find(&rated)
+		if result.Error != nil {
+			return fmt.Errorf("failed to check the tax rates of %s: %w", table.name, result.Error)
+		}
+
+		var invalid []string
+		for _, row := range rated {
+			if !calculator.ValidRate(row.TaxRateBasisPoints) {
+				invalid = append(invalid, strconv.FormatUint(uint64(row.Id), 10))
+			}
+		}
+		if len(invalid) > 0 {
+			return fmt.Errorf("%w: %s %s have rates that are not %s rates", ErrInvalidTaxRate, table.name, strings.Join(invalid, ", "), calculator.Name())
+		}
+	}
+	return nil
+}
+
+// Use the rules of a jurisdiction this package doesn't ship
+func SetTaxCalculator(calculator TaxCalculator) {
+	taxCalculator = calculator
+}
+
+// Tax at a rate on an amount in minor units, rounded half up. For an inclusive amount
+// the tax is the part of it above the taxable value.
+func taxOn(amount int64, rateBasisPoints int, inclusive bool) int64 {
+	rate := int64(rateBasisPoints)
+	if inclusive {
+		taxable := (amount*10000 + (10000+rate)/2) / (10000 + rate)
+		return amount - taxable
+	}
+	return (amount*rate + 5000) / 10000
+}
+
+// A rate in basis points as a percentage, divided between parts taxes
+func formatTaxRate(rateBasisPoints int, parts int) string {
+	return strconv.FormatFloat(float64(rateBasisPoints)/100/float64(parts), 'f', -1, 64)
+}
+
+func validHSNCode(code string) bool {
+	if len(code) != 4 && len(code) != 6 && len(code) != 8 {
+		return false
+	}
+	for _, digit := range code {
+		if digit < '0' || digit > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Check a tax rate and HSN code given for a product or category against the current rules
+func validateTaxFields(rateBasisPoints *int, hsnCode string) error {
+	if rateBasisPoints != nil && !taxCalculator.ValidRate(*rateBasisPoints) {
+		return fmt.Errorf("%w: %s%% is not a %s rate", ErrInvalidTaxRate, formatTaxRate(*rateBasisPoints, 1), taxCalculator.Name())
+	}
+	if hsnCode != "" && !validHSNCode(hsnCode) {
+		return ErrInvalidHSNCode
+	}
+	return nil
+}
+
+// A single tax at the line's rate, wherever the goods go
+type flatTaxCalculator struct {
+}
+
+func NewFlatTaxCalculator() TaxCalculator {
+	return &flatTaxCalculator{}
+}
+
+func (calculator *flatTaxCalculator) Name() string {
+	return "flat"
+}
+
+func (calculator *flatTaxCalculator) ValidRate(rateBasisPoints int) bool {
+	return rateBasisPoints >= 0 && rateBasisPoints <= 10000
+}
+
+func (calculator *flatTaxCalculator) Calculate(line *TaxableLine) ([]TaxComponent, error) {
+	if line.RateBasisPoints == 0 {
+		return nil, nil
+	}
+	tax := taxOn(line.Amount.Minor, line.RateBasisPoints, line.Inclusive)
+	return []TaxComponent{{
+		Name:   "Tax",
+		Rate:   formatTaxRate(line.RateBasisPoints, 1),
+		Amount: common.NewMoney(tax, line.Amount.Currency),
+	}}, nil
+}
+
+// GST rate slabs in basis points
+var gstSlabs = map[int]bool{0: true, 10: true, 25: true, 300: true, 500: true, 1200: true, 1800: true, 2800: true}
+
+// GST state codes, by state and union territory name
+var gstStateCodes = map[string]string{
+	"jammu and kashmir": "01",
+	"himachal pradesh":  "02",
+	"punjab":            "03",
+	"chandigarh":        "04",
+	"uttarakhand":       "05",
+	"uttaranchal":       "05",
+	"haryana":           "06",
+	"delhi":             "07",
+	"new delhi":         "07",
+	"nct of delhi":      "07",
+	"rajasthan":         "08",
+	"uttar pradesh":     "09",
+	"bihar":             "10",
+	"sikkim":            "11",
+	"arunachal pradesh": "12",
+	"nagaland":          "13",
+	"manipur":           "14",
+	"mizoram":           "15",
+	"tripura":           "16",
+	"meghalaya":         "17",
+	"assam":             "18",
+	"west bengal":       "19",
+	"jharkhand":         "20",
+	"odisha":            "21",
+	"orissa":            "21",
+	"chhattisgarh":      "22",
+	"madhya pradesh":    "23",
+	"gujarat":           "24",
+	"dadra and nagar haveli and daman and diu": "26",
+	"dadra and nagar haveli":                   "26",
+	"daman and diu":                            "26",
+	"maharashtra":                              "27",
+	"karnataka":                                "29",
+	"goa":                                      "30",
+	"lakshadweep":                              "31",
+	"kerala":                                   "32",
+	"tamil nadu":                               "33",
+	"puducherry":                               "34",
+	"pondicherry":                              "34",
+	"andaman and nicobar islands":              "35",
+	"andaman and nicobar":                      "35",
+	"telangana":                                "36",
+	"andhra pradesh":                           "37",
+	"ladakh":                                   "38",
+}
+
+// Union territories without a legislature charge UTGST in place of SGST
+var gstUnionTerritories = map[string]bool{"04": true, "26": true, "31": true, "35": true, "38": true}
+
+// The GST state code of a state name or code, empty when it isn't one
+func gstStateCode(state string) string {
+	state = strings.ToLower(strings.ReplaceAll(state, "&", " and "))
+	state = strings.Join(strings.Fields(state), " ")
+	if code, ok := gstStateCodes[state]; ok {
+		return code
+	}
+	for _, code := range gstStateCodes {
+		if code == state {
+			return code
+		}
+	}
+	return ""
+}
+
+// Indian GST. Goods shipped within the seller's state pay CGST and SGST (UTGST in union
+// territories without a legislature) at half the rate each, goods shipped to another
+// state pay IGST at the full rate. When the destination state is missing or not
+// recognised, the place of supply is taken to be the seller's state. Exports are zero-rated.
+type gstTaxCalculator struct {
+	sellerState string
+}
+
+func NewGSTTaxCalculator(sellerState string) (TaxCalculator, error) {
+	code := gstStateCode(sellerState)
+	if code == "" {
+		return nil, fmt.Errorf("SELLER_STATE %q is not an Indian state or union territory", sellerState)
+	}
+	return &gstTaxCalculator{sellerState: code}, nil
+}
+
+func (calculator *gstTaxCalculator) Name() string {
+	return "GST"
+}
+
+func (calculator *gstTaxCalculator) ValidRate(rateBasisPoints int) bool {
+	return gstSlabs[rateBasisPoints]
+}
+
+func (calculator *gstTaxCalculator) Calculate(line *TaxableLine) ([]TaxComponent, error) {
+	if line.RateBasisPoints == 0 || isExport(line.ShipTo.Country) {
+		return nil, nil
+	}
+
+	currency := line.Amount.Currency
+	tax := taxOn(line.Amount.Minor, line.RateBasisPoints, line.Inclusive)
+	destination := gstStateCode(line.ShipTo.State)
+	if destination != "" && destination != calculator.sellerState {
+		return []TaxComponent{{Name: "IGST", Rate: formatTaxRate(line.RateBasisPoints, 1), Amount: common.NewMoney(tax, currency)}}, nil
+	}
+
+	stateTax := "SGST"
+	if gstUnionTerritories[calculator.sellerState] {
+		stateTax = "UTGST"
+	}
+	// An odd paisa goes to the central tax
+	central := tax - tax/2
+	half := formatTaxRate(line.RateBasisPoints, 2)
+	return []TaxComponent{
+		{Name: "CGST", Rate: half, Amount: common.NewMoney(central, currency)},
+		{Name: stateTax, Rate: half, Amount: common.NewMoney(tax-central, currency)},
+	}, nil
+}
+
+func isExport(country string) bool {
+	switch strings.ToLower(strings.TrimSpace(country)) {
+	case "", "india", "in", "ind", "bharat":
+		return false
+	}
+	return true
+}
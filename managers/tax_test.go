@@ -0,0 +1,153 @@
+package managers
+
+import (
+	"main/common"
+	"main/models"
+	"reflect"
+	"testing"
+)
+
+func TestTaxOn(t *testing.T) {
+	tests := []struct {
+		name      string
+		amount    int64
+		rate      int
+		inclusive bool
+		want      int64
+	}{
+		{name: "exclusive", amount: 10000, rate: 1800, want: 1800},
+		{name: "exclusive rounds down below half", amount: 333, rate: 1800, want: 60},
+		{name: "exclusive rounds half up", amount: 25, rate: 1800, want: 5},
+		{name: "exclusive fractional rate", amount: 100000, rate: 25, want: 250},
+		{name: "exclusive zero rate", amount: 10000, rate: 0, want: 0},
+		{name: "inclusive", amount: 11800, rate: 1800, inclusive: true, want: 1800},
+		{name: "inclusive rounded taxable value", amount: 10000, rate: 1800, inclusive: true, want: 1525},
+		{name: "inclusive small amount", amount: 100, rate: 500, inclusive: true, want: 5},
+		{name: "inclusive zero rate", amount: 10000, rate: 0, inclusive: true, want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := taxOn(test.amount, test.rate, test.inclusive); got != test.want {
+				t.Errorf("taxOn(%d, %d, %t) = %d, want %d", test.amount, test.rate, test.inclusive, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGSTTaxCalculator(t *testing.T) {
+	inr := func(minor int64) common.Money { return common.NewMoney(minor, "INR") }
+	india := func(state string) models.Address { return models.Address{State: state, Country: "India"} }
+
+	tests := []struct {
+		name        string
+		sellerState string
+		line        TaxableLine
+		want        []TaxComponent
+	}{
+		{
+			name:        "within the state",
+			sellerState: "Karnataka",
+			line:        TaxableLine{Amount: inr(10000), RateBasisPoints: 1800, ShipTo: india("Karnataka")},
+			want:        []TaxComponent{{Name: "CGST", Rate: "9", Amount: inr(900)}, {Name: "SGST", Rate: "9", Amount: inr(900)}},
+		},
+		{
+			name:        "odd paisa to the central tax",
+			sellerState: "Karnataka",
+			line:        TaxableLine{Amount: inr(10050), RateBasisPoints: 1800, ShipTo: india("karnataka")},
+			want:        []TaxComponent{{Name: "CGST", Rate: "9", Amount: inr(905)}, {Name: "SGST", Rate: "9", Amount: inr(904)}},
+		},
+		{
+			name:        "within the state, prices including tax",
+			sellerState: "Karnataka",
+			line:        TaxableLine{Amount: inr(10000), Inclusive: true, RateBasisPoints: 1800, ShipTo: india("Karnataka")},
+			want:        []TaxComponent{{Name: "CGST", Rate: "9", Amount: inr(763)}, {Name: "SGST", Rate: "9", Amount: inr(762)}},
+		},
+		{
+			name:        "fractional slab split in half",
+			sellerState: "Karnataka",
+			line:        TaxableLine{Amount: inr(100000), RateBasisPoints: 25, ShipTo: india("Karnataka")},
+			want:        []TaxComponent{{Name: "CGST", Rate: "0.125", Amount: inr(125)}, {Name: "SGST", Rate: "0.125", Amount: inr(125)}},
+		},
+		{
+			name:        "to another state",
+			sellerState: "Karnataka",
+			line:        TaxableLine{Amount: inr(10000), RateBasisPoints: 1800, ShipTo: india("Maharashtra")},
+			want:        []TaxComponent{{Name: "IGST", Rate: "18", Amount: inr(1800)}},
+		},
+		{
+			name:        "to another state by code",
+			sellerState: "29",
+			line:        TaxableLine{Amount: inr(10050), RateBasisPoints: 1800, ShipTo: india("27")},
+			want:        []TaxComponent{{Name: "IGST", Rate: "18", Amount: inr(1809)}},
+		},
+		{
+			name:        "unknown destination taxed as within the state",
+			sellerState: "Karnataka",
+			line:        TaxableLine{Amount: inr(10000), RateBasisPoints: 500, ShipTo: models.Address{}},
+			want:        []TaxComponent{{Name: "CGST", Rate: "2.5", Amount: inr(250)}, {Name: "SGST", Rate: "2.5", Amount: inr(250)}},
+		},
+		{
+			name:        "union territory seller",
+			sellerState: "Chandigarh",
+			line:        TaxableLine{Amount: inr(10000), RateBasisPoints: 1200, ShipTo: india("Chandigarh")},
+			want:        []TaxComponent{{Name: "CGST", Rate: "6", Amount: inr(600)}, {Name: "UTGST", Rate: "6", Amount: inr(600)}},
+		},
+		{
+			name:        "union territory seller to a state",
+			sellerState: "Andaman & Nicobar Islands",
+			line:        TaxableLine{Amount: inr(10000), RateBasisPoints: 1200, ShipTo: india("Tamil Nadu")},
+			want:        []TaxComponent{{Name: "IGST", Rate: "12", Amount: inr(1200)}},
+		},
+		{
+			name:        "union territory with a legislature pays SGST",
+			sellerState: "Delhi",
+			line:        TaxableLine{Amount: inr(10000), RateBasisPoints: 1800, ShipTo: india("New Delhi")},
+			want:        []TaxComponent{{Name: "CGST", Rate: "9", Amount: inr(900)}, {Name: "SGST", Rate: "9", Amount: inr(900)}},
+		},
+		{
+			name:        "export",
+			sellerState: "Karnataka",
+			line:        TaxableLine{Amount: inr(10000), RateBasisPoints: 1800, ShipTo: models.Address{State: "Ontario", Country: "Canada"}},
+			want:        nil,
+		},
+		{
+			name:        "zero rated",
+			sellerState: "Karnataka",
+			line:        TaxableLine{Amount: inr(10000), RateBasisPoints: 0, ShipTo: india("Karnataka")},
+			want:        nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calculator, err := NewGSTTaxCalculator(test.sellerState)
+			if err != nil {
+				t.Fatalf("NewGSTTaxCalculator(%q) error = %v", test.sellerState, err)
+			}
+			got, err := calculator.Calculate(&test.line)
+			if err != nil {
+				t.Fatalf("Calculate() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Calculate() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGSTTaxCalculatorRates(t *testing.T) {
+	if _, err := NewGSTTaxCalculator("Atlantis"); err == nil {
+		t.Error("NewGSTTaxCalculator(\"Atlantis\") succeeded, want an error")
+	}
+
+	calculator, err := NewGSTTaxCalculator("Karnataka")
+	if err != nil {
+		t.Fatalf("NewGSTTaxCalculator() error = %v", err)
+	}
+	for rate, want := range map[int]bool{0: true, 10: true, 25: true, 300: true, 500: true, 1200: true, 1800: true, 2800: true, 1000: false, 1700: false, -500: false} {
+		if got := calculator.ValidRate(rate); got != want {
+			t.Errorf("ValidRate(%d) = %t, want %t", rate, got, want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"main/common"
+	"main/database"
+	"main/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+type TokenManager interface {
+	IssueTokens(user *models.User, client common.ClientInfo) (*common.TokenPair, error)
+	Refresh(refreshToken string, client common.ClientInfo) (*models.User, *common.TokenPair, error)
+	RevokeRefreshToken(refreshToken string) error
+	RevokeAllForUser(userID uint) error
+}
+
+type tokenManager struct {
+}
+
+func NewTokenManager() TokenManager {
+	return &tokenManager{}
+}
+
+func refreshTokenTTL() time.Duration {
+	return common.DurationFromEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+// Start a session and issue its access token and the first refresh token of a new token family
+func (tokenManager *tokenManager) IssueTokens(user *models.User, client common.ClientInfo) (*common.TokenPair, error) {
+	familyID, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token family: %w", err)
+	}
+
+	var tokens *common.TokenPair
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		session, err := startSession(tx, user, client)
+		if err != nil {
+			return err
+		}
+
+		tokens, err = issueTokenPair(tx, user, session, familyID.String())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Exchange a refresh token for a new pair. Presenting a token that was already rotated revokes
+// its whole family and logs its session out, since either the client or an attacker holds a stolen copy.
+func (tokenManager *tokenManager) Refresh(refreshToken string, client common.ClientInfo) (*models.User, *common.TokenPair, error) {
+	var user models.User
+	var tokens *common.TokenPair
+	var reusedBy uint
+	var revokedSessions []models.Session
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var stored models.RefreshToken
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", common.HashToken(refreshToken)).First(&stored)
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return fmt.Errorf("failed to find refresh token: %w", result.Error)
+		}
+
+		if stored.RotatedAt != nil {
+			reusedBy = stored.UserID
+			if err := revokeFamily(tx, stored.FamilyID); err != nil {
+				return err
+			}
+			var err error
+			revokedSessions, err = revokeSessionRecords(tx, tx.Where("id = ?", stored.SessionID))
+			return err
+		}
+
+		if stored.RevokedAt != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		if stored.ExpiresAt.Before(time.Now()) {
+			return ErrRefreshTokenExpired
+		}
+
+		now := time.Now()
+		stored.RotatedAt = &now
+		if err := tx.Save(&stored).Error; err != nil {
+			return fmt.Errorf("failed to rotate refresh token: %w", err)
+		}
+
+		if err := tx.First(&user, stored.UserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return fmt.Errorf("failed to find user: %w", err)
+		}
+
+		var session models.Session
+		if err := tx.First(&session, stored.SessionID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return fmt.Errorf("failed to find session: %w", err)
+		}
+		session.IPAddress = client.IPAddress
+
+		var err error
+		tokens, err = issueTokenPair(tx, &user, &session, stored.FamilyID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if reusedBy != 0 {
+		if err := revokeSessionAccessTokens(revokedSessions); err != nil {
+			return nil, nil, err
+		}
+		warnSecurityEvent(EventRefreshTokenReused, "user_id", reusedBy, "ip", client.IPAddress)
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	return &user, tokens, nil
+}
+
+// Revoke the family of a refresh token, used on logout
+func (tokenManager *tokenManager) RevokeRefreshToken(refreshToken string) error {
+	var stored models.RefreshToken
+	result := database.DB.Where("token_hash = ?", common.HashToken(refreshToken)).First(&stored)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return ErrInvalidRefreshToken
+		}
+		return fmt.Errorf("failed to find refresh token: %w", result.Error)
+	}
+
+	return revokeFamily(database.DB, stored.FamilyID)
+}
+
+func (tokenManager *tokenManager) RevokeAllForUser(userID uint) error {
+	result := database.DB.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", result.Error)
+	}
+	return nil
+}
+
+// Issue the next token pair of a session and move the session onto the new access token
+func issueTokenPair(db *gorm.DB, user *models.User, session *models.Session, familyID string) (*common.TokenPair, error) {
+	accessToken, jti, err := common.GenerateJWT(user.Email, session.Id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	refreshToken, err := common.GenerateOpaqueToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	record := &models.RefreshToken{
+		UserID:    user.Id,
+		SessionID: session.Id,
+		FamilyID:  familyID,
+		TokenHash: common.HashToken(refreshToken),
+		ExpiresAt: time.Now().Add(refreshTokenTTL()),
+	}
+	if err := db.Create(record).Error; err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	session.Jti = jti
+	session.LastSeenAt = time.Now()
+	session.ExpiresAt = record.ExpiresAt
+	if err := db.Save(session).Error; err != nil {
+		return nil, fmt.Errorf("failed to update session: %w", err)
+	}
+
+	return &common.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(common.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+func revokeFamily(db *gorm.DB, familyID string) error {
+	result := db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke token family: %w", result.Error)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package managers
+
+import (
+	"errors"
+	"main/common"
+	"main/database"
+	"main/models"
+	"testing"
+)
+
+func TestRefreshReuseRevokesSession(t *testing.T) {
+	useTestDB(t)
+	t.Setenv("JWT_SECRET_KEY", "test-secret")
+
+	user := &models.User{Email: "bob@example.com", Password: "hash", IsVerified: true}
+	if err := database.DB.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	tokenManager := NewTokenManager()
+	client := common.ClientInfo{IPAddress: "203.0.113.7", UserAgent: "test"}
+	first, err := tokenManager.IssueTokens(user, client)
+	if err != nil {
+		t.Fatalf("IssueTokens() error = %v", err)
+	}
+	if _, _, err := tokenManager.Refresh(first.RefreshToken, client); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	var session models.Session
+	if err := database.DB.Where("user_id = ?", user.Id).First(&session).Error; err != nil {
+		t.Fatalf("failed to find session: %v", err)
+	}
+
+	if _, _, err := tokenManager.Refresh(first.RefreshToken, client); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("replayed Refresh() error = %v, want %v", err, ErrRefreshTokenReused)
+	}
+
+	if _, err := NewSessionManager().Touch(session.Id, client.IPAddress); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Touch() after replay error = %v, want %v", err, ErrSessionRevoked)
+	}
+	revoked, err := Revocations().IsRevoked(session.Jti)
+	if err != nil {
+		t.Fatalf("IsRevoked() error = %v", err)
+	}
+	if !revoked {
+		t.Error("access token of the last rotation is not revoked")
+	}
+
+	var active int64
+	database.DB.Model(&models.RefreshToken{}).Where("session_id = ? AND revoked_at IS NULL", session.Id).Count(&active)
+	if active != 0 {
+		t.Errorf("%d refresh tokens of the session are still active", active)
+	}
+}
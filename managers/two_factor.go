@@ -0,0 +1,443 @@
+package managers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image/png"
+	"main/common"
+	"main/database"
+	"main/models"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrTwoFactorRequired       = errors.New("two-factor authentication required")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication not enabled")
+	ErrTwoFactorNotEnrolled    = errors.New("two-factor enrollment not started")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+	ErrInvalidChallenge        = errors.New("invalid or expired two-factor challenge")
+)
+
+const (
+	twoFactorChallengeExpiration = 5 * time.Minute
+	twoFactorChallengeAttempts   = 5
+	recoveryCodeCount            = 10
+	totpPeriod                   = 30
+	qrCodeSize                   = 256
+)
+
+// Login stops here when the account has 2FA enabled, the challenge token replaces the access token
+type TwoFactorChallengeError struct {
+	ChallengeToken string
+	ExpiresIn      int64
+}
+
+func (err *TwoFactorChallengeError) Error() string {
+	return ErrTwoFactorRequired.Error()
+}
+
+func (err *TwoFactorChallengeError) Unwrap() error {
+	return ErrTwoFactorRequired
+}
+
+type TwoFactorManager interface {
+	Enroll(user *models.User) (*common.TwoFactorEnrollment, error)
+	Confirm(user *models.User, code string) ([]string, error)
+	Disable(user *models.User, password string, code string) error
+	RegenerateRecoveryCodes(user *models.User, code string) ([]string, error)
+	CompleteLogin(challengeToken string, code string, client common.ClientInfo) (*models.User, *common.TokenPair, error)
+}
+
+type twoFactorManager struct {
+	tokenManager TokenManager
+}
+
+func NewTwoFactorManager() TwoFactorManager {
+	return &twoFactorManager{
+		tokenManager: NewTokenManager(),
+	}
+}
+
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Go E-Commerce"
+}
+
+func totpOptions() totp.ValidateOpts {
+	return totp.ValidateOpts{
+		Period:    totpPeriod,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	}
+}
+
+// Generate a new secret and keep it pending until the user proves their app works with Confirm
+func (twoFactorManager *twoFactorManager) Enroll(user *models.User) (*common.TwoFactorEnrollment, error) {
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer(),
+		AccountName: user.Email,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+
+	image, err := key.Image(qrCodeSize, qrCodeSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render QR code: %w", err)
+	}
+	var qrCode bytes.Buffer
+	if err := png.Encode(&qrCode, image); err != nil {
+		return nil, fmt.Errorf("failed to encode QR code: %w", err)
+	}
+
+	result := database.DB.Model(user).Updates(map[string]interface{}{
+		"two_factor_secret":       key.Secret(),
+		"two_factor_last_counter": 0,
+	})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to store TOTP secret: %w", result.Error)
+	}
+
+	return &common.TwoFactorEnrollment{
+		Secret:     key.Secret(),
+		OtpAuthURL: key.URL(),
+		QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(qrCode.Bytes()),
+	}, nil
+}
+
+// Turn 2FA on once the user enters a code from the pending secret, returning the recovery codes
+func (twoFactorManager *twoFactorManager) Confirm(user *models.User, code string) ([]string, error) {
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if user.TwoFactorSecret == "" {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	var codes []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := useTOTPCode(tx, user.Id, code); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.User{}).Where("id = ?", user.Id).Update("two_factor_enabled", true).Error; err != nil {
+			return fmt.Errorf("failed to enable two-factor authentication: %w", err)
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.Id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logSecurityEvent(EventTwoFactorEnabled, "user_id", user.Id)
+	return codes, nil
+}
+
+// Turn 2FA off, requiring both the password and a current code or recovery code
+func (twoFactorManager *twoFactorManager) Disable(user *models.User, password string, code string) error {
+	if !user.TwoFactorEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return ErrInvalidCredentials
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := useSecondFactor(tx, user.Id, code); err != nil {
+			return err
+		}
+
+		result := tx.Model(&models.User{}).Where("id = ?", user.Id).Updates(map[string]interface{}{
+			"two_factor_enabled":      false,
+			"two_factor_secret":       "",
+			"two_factor_last_counter": 0,
+		})
+		if result.Error != nil {
+			return fmt.Errorf("failed to disable two-factor authentication: %w", result.Error)
+		}
+
+		if err := tx.Where("user_id = ?", user.Id).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	warnSecurityEvent(EventTwoFactorDisabled, "user_id", user.Id)
+	return nil
+}
+
+// Replace every recovery code, for when the old ones were used up or exposed
+func (twoFactorManager *twoFactorManager) RegenerateRecoveryCodes(user *models.User, code string) ([]string, error) {
+	if !user.TwoFactorEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+
+	var codes []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := useTOTPCode(tx, user.Id, code); err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.Id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Exchange a login challenge and a TOTP or recovery code for the session tokens
+func (twoFactorManager *twoFactorManager) CompleteLogin(challengeToken string, code string, client common.ClientInfo) (*models.User, *common.TokenPair, error) {
+	throttle := loadLoginThrottleConfig()
+
+	var user models.User
+	var codeErr error
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var challenge models.TwoFactorChallenge
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", common.HashToken(challengeToken)).First(&challenge)
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return ErrInvalidChallenge
+			}
+			return fmt.Errorf("failed to find two-factor challenge: %w", result.Error)
+		}
+
+		if challenge.UsedAt != nil || challenge.ExpiresAt.Before(time.Now()) || challenge.Attempts >= twoFactorChallengeAttempts {
+			return ErrInvalidChallenge
+		}
+
+		if err := tx.First(&user, challenge.UserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidChallenge
+			}
+			return fmt.Errorf("failed to find user: %w", err)
+		}
+
+		if err := throttle.checkAccount(&user); err != nil {
+			return err
+		}
+
+		codeErr = useSecondFactor(tx, user.Id, code)
+		if errors.Is(codeErr, ErrInvalidTwoFactorCode) {
+			// Commit the attempt count, the wrong code is reported after the transaction
+			if err := tx.Model(&challenge).Update("attempts", challenge.Attempts+1).Error; err != nil {
+				return fmt.Errorf("failed to record two-factor attempt: %w", err)
+			}
+			return nil
+		}
+		if codeErr != nil {
+			return codeErr
+		}
+
+		now := time.Now()
+		challenge.UsedAt = &now
+		if err := tx.Save(&challenge).Error; err != nil {
+			return fmt.Errorf("failed to use two-factor challenge: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrLoginThrottled) || errors.Is(err, ErrAccountLocked) {
+			logSecurityEvent(EventLoginThrottled, "user_id", user.Id, "ip", client.IPAddress, "reason", "two_factor")
+		}
+		return nil, nil, err
+	}
+
+	if codeErr != nil {
+		recordLoginAttempt(user.Email, client.IPAddress, false)
+		logSecurityEvent(EventLoginFailed, "user_id", user.Id, "ip", client.IPAddress, "reason", "wrong_two_factor_code")
+
+		// Every wrong code counts towards the lockout, otherwise fresh challenges would allow guessing codes forever
+		locked, err := throttle.recordAccountFailure(&user)
+		if err != nil {
+			return nil, nil, err
+		}
+		if locked {
+			warnSecurityEvent(EventAccountLocked, "user_id", user.Id, "ip", client.IPAddress, "until", user.LockedUntil)
+		}
+		return nil, nil, codeErr
+	}
+
+	if err := resetAccountFailures(&user); err != nil {
+		return nil, nil, err
+	}
+
+	tokens, err := twoFactorManager.tokenManager.IssueTokens(&user, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recordLoginAttempt(user.Email, client.IPAddress, true)
+	logSecurityEvent(EventLoginSucceeded, "user_id", user.Id, "ip", client.IPAddress, "two_factor", true)
+	return &user, tokens, nil
+}
+
+// Issue the challenge a password login returns when the account has 2FA enabled
+func startTwoFactorChallenge(user *models.User) error {
+	token, err := common.GenerateOpaqueToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate two-factor challenge: %w", err)
+	}
+
+	challenge := &models.TwoFactorChallenge{
+		UserID:    user.Id,
+		TokenHash: common.HashToken(token),
+		ExpiresAt: time.Now().Add(twoFactorChallengeExpiration),
+	}
+	if err := database.DB.Create(challenge).Error; err != nil {
+		return fmt.Errorf("failed to store two-factor challenge: %w", err)
+	}
+
+	return &TwoFactorChallengeError{
+		ChallengeToken: token,
+		ExpiresIn:      int64(twoFactorChallengeExpiration.Seconds()),
+	}
+}
+
+// Accept a TOTP code, or a recovery code when the input is not six digits
+func useSecondFactor(tx *gorm.DB, userID uint, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == int(otp.DigitsSix) && strings.Trim(code, "0123456789") == "" {
+		return useTOTPCode(tx, userID, code)
+	}
+	return useRecoveryCode(tx, userID, code)
+}
+
+// Check a TOTP code against the user's secret, allowing one period of clock drift.
+// A code is accepted only once, so an observed code cannot be replayed.
+func useTOTPCode(tx *gorm.DB, userID uint, code string) error {
+	var user models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+	if user.TwoFactorSecret == "" {
+		return ErrTwoFactorNotEnrolled
+	}
+
+	now := time.Now()
+	currentCounter := now.Unix() / totpPeriod
+	for _, skew := range []int64{0, -1, 1} {
+		counter := currentCounter + skew
+		expected, err := totp.GenerateCodeCustom(user.TwoFactorSecret, time.Unix(counter*totpPeriod, 0), totpOptions())
+		if err != nil {
+			return fmt.Errorf("failed to generate TOTP code: %w", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+			continue
+		}
+
+		if counter <= user.TwoFactorLastCounter {
+			return ErrInvalidTwoFactorCode
+		}
+		if err := tx.Model(&user).Update("two_factor_last_counter", counter).Error; err != nil {
+			return fmt.Errorf("failed to record TOTP code use: %w", err)
+		}
+		return nil
+	}
+
+	return ErrInvalidTwoFactorCode
+}
+
+func useRecoveryCode(tx *gorm.DB, userID uint, code string) error {
+	var recoveryCode models.RecoveryCode
+	result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, common.HashToken(normalizeRecoveryCode(code))).
+		First(&recoveryCode)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return ErrInvalidTwoFactorCode
+		}
+		return fmt.Errorf("failed to find recovery code: %w", result.Error)
+	}
+
+	now := time.Now()
+	recoveryCode.UsedAt = &now
+	if err := tx.Save(&recoveryCode).Error; err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	logSecurityEvent(EventRecoveryCodeUsed, "user_id", userID)
+	return nil
+}
+
+// Delete the user's recovery codes and generate a new set, only their hashes are stored
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		records = append(records, models.RecoveryCode{
+			UserID:   userID,
+			CodeHash: common.HashToken(normalizeRecoveryCode(code)),
+		})
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to store recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// Recovery codes are ten base32 characters written as two groups of five
+func generateRecoveryCode() (string, error) {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+	random := make([]byte, 10)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+
+	code := make([]byte, 0, 11)
+	for i, b := range random {
+		if i == 5 {
+			code = append(code, '-')
+		}
+		code = append(code, alphabet[int(b)%len(alphabet)])
+	}
+	return string(code), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
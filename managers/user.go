@@ -1,49 +1,58 @@
 package managers
 
 import (
-	"embed"
 	"errors"
 	"fmt"
 	"log"
 	"main/common"
 	"main/database"
 	"main/models"
-	"os"
-	"strconv"
+	"time"
 
-	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
-	"gopkg.in/gomail.v2"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
 	ErrEmailAlreadyExists = errors.New("email already exists")
 	ErrInvalidToken       = errors.New("invalid token")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrEmailNotVerified   = errors.New("email is not verified")
+	ErrInvalidResetToken  = errors.New("invalid or expired password reset token")
 )
 
-//go:embed message.html
-var htmlFile embed.FS
+const passwordResetExpiration = 30 * time.Minute
 
 type UserManager interface {
 	Create(userData *common.UserCreationInput) (*models.User, string, error)
 	List() ([]models.User, error)
 	Get(id string) (models.User, error)
+	GetByEmail(email string) (*models.User, error)
 	Update(userId string, userData *common.UserUpdationInput) (*models.User, error)
+	UpdateProfile(userId string, userData *common.UserUpdationInput) (*models.User, error)
 	Delete(id string) (*models.User, error)
-	Login(email, password string) (*models.User, string, error)
+	Login(email, password string, client common.ClientInfo) (*models.User, *common.TokenPair, error)
 	Logout(token string) error
 	ViewProfile(email string) (*common.ProfileResponse, error)
 	SenderVerificationEmail(email string, token string) error
 	VerifyEmail(token string) error
+	ResendVerification(email string) error
+	ConfirmEmailChange(token string) error
+	ForgotPassword(email string) error
+	ResetPassword(token string, newPassword string) error
+	Unlock(userId string) (*models.User, error)
 }
 
 type userManager struct {
 	//dbClient
+	tokenManager TokenManager
 }
 
 func NewUserManager() UserManager {
-	return &userManager{}
+	return &userManager{
+		tokenManager: NewTokenManager(),
+	}
 }
 
 // Create New User
@@ -58,23 +67,30 @@ func (userManager *userManager) Create(userData *common.UserCreationInput) (*mod
 		return nil, "", fmt.Errorf("failed to check email existence %w", value.Error)
 	}
 
-	// Generate a UUID for the token
-	uuidToken, err := uuid.NewUUID()
+	if userData.Phone != "" {
+		phone, err := common.NormalizePhoneNumber(userData.Phone)
+		if err != nil {
+			return nil, "", err
+		}
+		userData.Phone = phone
+	}
+
+	verificationToken, verificationExpiresAt, err := loadEmailVerificationConfig().newVerificationToken()
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to generate UUID: %w", err)
+		return nil, "", err
 	}
-	verificationToken := uuidToken.String()
 
 	newUser := &models.User{
-		FirstName:         userData.FirstName,
-		LastName:          userData.LastName,
-		Email:             userData.Email,
-		Password:          userData.Password,
-		Phone:             userData.Phone,
-		VerificationToken: verificationToken,
-		Token:             uuidToken.String(),
-		Address:           models.Address(userData.Address),
-		Image:             userData.Image,
+		FirstName:                  userData.FirstName,
+		LastName:                   userData.LastName,
+		Email:                      userData.Email,
+		Password:                   userData.Password,
+		Phone:                      userData.Phone,
+		VerificationToken:          verificationToken,
+		VerificationTokenExpiresAt: &verificationExpiresAt,
+		Address:                    models.Address(userData.Address),
+		Image:                      userData.Image,
+		RoleID:                     defaultRoleID(database.DB),
 	}
 
 	//Hash the password
@@ -85,14 +101,21 @@ func (userManager *userManager) Create(userData *common.UserCreationInput) (*mod
 
 	newUser.Password = string(hashedPassword)
 
-	result := database.DB.Create(newUser)
-	if result.Error != nil {
-		return nil, "", fmt.Errorf("failed to create a user: %w", result.Error)
-	}
+	// The verification email is queued with the user, so a mail server outage can't leave an account without one
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(newUser).Error; err != nil {
+			return fmt.Errorf("failed to create a user: %w", err)
+		}
 
-	if newUser.Id == 0 {
+		if newUser.Id == 0 {
 
-		return nil, "", errors.New("failed to create a new user")
+			return errors.New("failed to create a new user")
+		}
+
+		return queueVerificationEmail(tx, newUser, verificationToken)
+	})
+	if err != nil {
+		return nil, "", err
 	}
 
 	return newUser, verificationToken, nil
@@ -116,8 +139,33 @@ func (userManager *userManager) Get(id string) (models.User, error) {
 	return user, nil
 }
 
-// Update the User
+// Get a user by email together with the role and its permissions
+func (userManager *userManager) GetByEmail(email string) (*models.User, error) {
+
+	user := models.User{}
+	result := database.DB.Preload("Role.Permissions").Where("email = ?", email).First(&user)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to find user: %w", result.Error)
+	}
+
+	return &user, nil
+}
+
+// Update the User, replacing the name, phone, address and image with what is given so empty values
+// clear them. The password and email are only changed when given.
 func (userManager *userManager) Update(userId string, userData *common.UserUpdationInput) (*models.User, error) {
+	return updateUser(userId, userData, true)
+}
+
+// Update a user's own profile, changing only the fields that are given
+func (userManager *userManager) UpdateProfile(userId string, userData *common.UserUpdationInput) (*models.User, error) {
+	return updateUser(userId, userData, false)
+}
+
+func updateUser(userId string, userData *common.UserUpdationInput, replace bool) (*models.User, error) {
 
 	user := models.User{}
 	result := database.DB.First(&user, userId)
@@ -128,13 +176,52 @@ func (userManager *userManager) Update(userId string, userData *common.UserUpdat
 		return nil, fmt.Errorf("failed to find user: %w", result.Error)
 	}
 
-	// Update fields
-	user.FirstName = userData.FirstName
-	user.LastName = userData.LastName
-	user.Email = userData.Email
-	user.Phone = userData.Phone
-	user.Address = models.Address(userData.Address)
-	user.Image = userData.Image
+	if replace || userData.FirstName != "" {
+		user.FirstName = userData.FirstName
+	}
+	if replace || userData.LastName != "" {
+		user.LastName = userData.LastName
+	}
+	// A new email address only replaces the current one once it is confirmed, see requestEmailChange
+	newEmail := ""
+	if userData.Email != "" && userData.Email != user.Email {
+		var existing int64
+		if err := database.DB.Model(&models.User{}).Where("email = ?", userData.Email).Count(&existing).Error; err != nil {
+			return nil, fmt.Errorf("failed to check email existence %w", err)
+		}
+		if existing > 0 {
+			return nil, ErrEmailAlreadyExists
+		}
+		if err := loadEmailVerificationConfig().checkQuota(user.Id, emailKindEmailChange); err != nil {
+			return nil, err
+		}
+		newEmail = userData.Email
+	}
+	// A new number has to be verified again, and codes sent to the old one no longer count
+	phoneChanged := false
+	if userData.Phone != "" {
+		phone, err := common.NormalizePhoneNumber(userData.Phone)
+		if err != nil {
+			return nil, err
+		}
+		if phone != user.Phone {
+			user.Phone = phone
+			user.PhoneVerified = false
+			user.PhoneVerifiedAt = nil
+			phoneChanged = true
+		}
+	} else if replace && user.Phone != "" {
+		user.Phone = ""
+		user.PhoneVerified = false
+		user.PhoneVerifiedAt = nil
+		phoneChanged = true
+	}
+	if replace || userData.Address != (common.Address{}) {
+		user.Address = models.Address(userData.Address)
+	}
+	if replace || userData.Image != "" {
+		user.Image = userData.Image
+	}
 
 	// Hash password if it's being updated
 	if userData.Password != "" {
@@ -145,9 +232,25 @@ func (userManager *userManager) Update(userId string, userData *common.UserUpdat
 		user.Password = string(hashedPassword)
 	}
 
-	result = database.DB.Save(&user)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to update user: %w", result.Error)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		if phoneChanged {
+			if err := tx.Where("user_id = ? AND purpose = ?", user.Id, otpPurposeVerify).Delete(&models.Otp{}).Error; err != nil {
+				return fmt.Errorf("failed to invalidate phone verification codes: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if newEmail != "" {
+		if err := requestEmailChange(&user, newEmail); err != nil {
+			return nil, err
+		}
 	}
 
 	return &user, nil
@@ -163,69 +266,118 @@ func (userManager *userManager) Delete(id string) (*models.User, error) {
 }
 
 // Login user Function
-func (userManager *userManager) Login(email, password string) (*models.User, string, error) {
+func (userManager *userManager) Login(email, password string, client common.ClientInfo) (*models.User, *common.TokenPair, error) {
+	throttle := loadLoginThrottleConfig()
+
+	if err := throttle.checkIP(client.IPAddress); err != nil {
+		logSecurityEvent(EventLoginThrottled, "email", email, "ip", client.IPAddress, "reason", "ip")
+		return nil, nil, err
+	}
 
 	user := models.User{}
 	result := database.DB.Where("email = ?", email).First(&user)
 
 	if result.Error != nil {
-		if errors.Is(result.Error, database.DB.Error) {
-			fmt.Println("Login: User not found for email:", email) // Debug log
-			return nil, "", errors.New("invalid credentials")
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			recordLoginAttempt(email, client.IPAddress, false)
+			logSecurityEvent(EventLoginFailed, "email", email, "ip", client.IPAddress, "reason", "unknown_email")
+			return nil, nil, ErrInvalidCredentials
 		}
-		fmt.Println("Login: Database error:", result.Error) // Debug log
-		return nil, "", fmt.Errorf("failed to find user: %w", result.Error)
+		return nil, nil, fmt.Errorf("failed to find user: %w", result.Error)
 	}
 
-	fmt.Println("Login: Email:", email)                                           // Debug
-	fmt.Println("Login: Stored Hashed Password:", user.Password)                  // Debug
-	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) // Debug
-	if err != nil {
-		fmt.Println("Login: Password comparison error:", err) // Debug Log
-		return nil, "", errors.New("invalid credentials")
+	if err := throttle.checkAccount(&user); err != nil {
+		logSecurityEvent(EventLoginThrottled, "user_id", user.Id, "ip", client.IPAddress, "reason", "account")
+		return nil, nil, err
 	}
 
-	token, err := common.GenerateJWT(user.Email)
+	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to generate token: %w", err)
+		recordLoginAttempt(email, client.IPAddress, false)
+		logSecurityEvent(EventLoginFailed, "user_id", user.Id, "ip", client.IPAddress, "reason", "wrong_password", "failures", user.FailedLoginAttempts+1)
+
+		locked, err := throttle.recordAccountFailure(&user)
+		if err != nil {
+			return nil, nil, err
+		}
+		if locked {
+			warnSecurityEvent(EventAccountLocked, "user_id", user.Id, "ip", client.IPAddress, "until", user.LockedUntil)
+			go func(user models.User) {
+				if err := sendAccountLockedEmail(&user, client.IPAddress); err != nil {
+					log.Printf("Failed to send account locked email to user %d: %v", user.Id, err)
+				}
+			}(user)
+		}
+		return nil, nil, ErrInvalidCredentials
 	}
 
-	user.Token = token
-	result = database.DB.Save(&user)
-	if result.Error != nil {
-		fmt.Println("Login:Failed to save token to database:", result.Error)
-		return nil, "", fmt.Errorf("failed to save token: %w", result.Error)
+	if !user.IsVerified {
+		return &user, nil, ErrEmailNotVerified
+	}
+
+	if err := resetAccountFailures(&user); err != nil {
+		return nil, nil, err
 	}
 
-	return &user, token, nil
+	// The password alone is not enough, the caller continues with CompleteLogin
+	if user.TwoFactorEnabled {
+		logSecurityEvent(EventTwoFactorChallenged, "user_id", user.Id, "ip", client.IPAddress)
+		return &user, nil, startTwoFactorChallenge(&user)
+	}
+
+	tokens, err := userManager.tokenManager.IssueTokens(&user, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recordLoginAttempt(email, client.IPAddress, true)
+	logSecurityEvent(EventLoginSucceeded, "user_id", user.Id, "ip", client.IPAddress)
+	return &user, tokens, nil
 }
 
-// Logout User Function
-func (userManager *userManager) Logout(token string) error {
+// Lift a lockout before it expires
+func (userManager *userManager) Unlock(userId string) (*models.User, error) {
 	var user models.User
-
-	result := database.DB.Where("token = ?", token).First(&user)
+	result := database.DB.First(&user, userId)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return errors.New("invalid token") // Token Not Found
+			return nil, ErrUserNotFound
 		}
+		return nil, fmt.Errorf("failed to find user: %w", result.Error)
+	}
 
-		return fmt.Errorf("failed to find user by token : %w", result.Error)
+	if err := resetAccountFailures(&user); err != nil {
+		return nil, err
 	}
 
-	// Generate a unique invalidation token
-	invalidatedToken, err := uuid.NewUUID()
+	logSecurityEvent(EventAccountUnlocked, "user_id", user.Id)
+	return &user, nil
+}
+
+// Tell the owner that their account was locked, in case someone else is guessing their password
+func sendAccountLockedEmail(user *models.User, ipAddress string) error {
+	return enqueueEmail(database.DB, user.Email, "account_locked", accountLockedData{
+		Name:      user.FirstName,
+		IPAddress: ipAddress,
+		Until:     user.LockedUntil.Format(time.RFC1123),
+		ResetLink: publicLink("/forgot-password", ""),
+	})
+}
+
+// Logout User Function
+func (userManager *userManager) Logout(token string) error {
+	claims, err := common.ParseJWT(token)
 	if err != nil {
-		return fmt.Errorf("failed to generate invalidation token: %w", err)
+		return ErrInvalidToken
 	}
-	user.Token = invalidatedToken.String() // Set the token to the new, unique value
 
-	result = database.DB.Save(&user)
-	if result.Error != nil {
-		return fmt.Errorf("failed to invalidate token: %w", result.Error)
+	err = Revocations().Revoke(claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	// End the session, which also revokes its refresh tokens
+	return revokeSessions(database.DB.Where("id = ?", claims.SessionID))
 }
 
 // View Profile
@@ -241,30 +393,27 @@ func (userManager *userManager) ViewProfile(email string) (*common.ProfileRespon
 	}
 
 	profile := &common.ProfileResponse{
-		Id:        user.Id,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-		Email:     user.Email,
-		Phone:     user.Phone,
-		Address:   common.Address(user.Address),
-		Image:     user.Image,
+		Id:            user.Id,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		Email:         user.Email,
+		Phone:         user.Phone,
+		PhoneVerified: user.PhoneVerified,
+		Address:       common.Address(user.Address),
+		Image:         user.Image,
+	}
+
+	pendingEmail, err := pendingEmailChange(user.Id)
+	if err != nil {
+		return nil, err
 	}
+	profile.PendingEmail = pendingEmail
 
 	return profile, nil
 }
 
+// Queue a verification email for the account, delivered by the outbox worker
 func (userManager *userManager) SenderVerificationEmail(email string, token string) error {
-	smtpServer := os.Getenv("SMTP_SERVER")
-	smtpPort := os.Getenv("SMTP_PORT")
-	smtpUsername := os.Getenv("SMTP_USERNAME")
-	smtpPassword := os.Getenv("SMTP_PASSWORD")
-	fromEmail := os.Getenv("FROM_EMAIL")
-
-	m := gomail.NewMessage()
-	m.SetHeader("From", fromEmail)
-	m.SetHeader("To", email)
-	m.SetHeader("Subject", "Verify your Email address")
-
 	user := models.User{}
 
 	result := database.DB.Where("email = ?", email).First(&user)
@@ -272,38 +421,7 @@ func (userManager *userManager) SenderVerificationEmail(email string, token stri
 		return fmt.Errorf("failed to find user: %w", result.Error)
 	}
 
-
-	htmlBody, err := readMessageFromFile("message.html")
-	if err != nil {
-		log.Printf("Error reading message.html: %v", err)
-		return fmt.Errorf("failed to read the message.html: %w", err)
-	}
-
-	htmlBody = fmt.Sprintf(htmlBody, user.FirstName, token)
-
-	m.SetBody("text/html", htmlBody)
-
-	port, err := strconv.Atoi(smtpPort)
-	if err != nil {
-		log.Printf("SMTP Conversion error: %v", err)
-		return fmt.Errorf("invalid SMTP_PORT: %w", err)
-	}
-
-	d := gomail.NewDialer(smtpServer, port, smtpUsername, smtpPassword)
-
-	smtpClient, err := d.Dial()
-	if err != nil {
-		log.Printf("SMTP Connection Error: %v", err) 
-		return fmt.Errorf("failed to connect to SMTP server: %w", err)
-	}
-	smtpClient.Close()
-
-	if err := d.DialAndSend(m); err != nil {
-		log.Printf("Email Sending error: %v", err)
-		return fmt.Errorf("failed to send email: %w", err)
-	}
-
-	return nil
+	return queueVerificationEmail(database.DB, &user, token)
 }
 
 func (userManager *userManager) VerifyEmail(token string) error {
@@ -322,8 +440,13 @@ func (userManager *userManager) VerifyEmail(token string) error {
 		return nil
 	}
 
+	if loadEmailVerificationConfig().verificationExpired(&user) {
+		return ErrVerificationTokenExpired
+	}
+
 	user.IsVerified = true
 	user.VerificationToken = ""
+	user.VerificationTokenExpiresAt = nil
 	result = database.DB.Save(&user)
 	if result.Error != nil {
 		return fmt.Errorf("failed to update user for verification: %w", result.Error)
@@ -332,10 +455,90 @@ func (userManager *userManager) VerifyEmail(token string) error {
 	return nil
 }
 
-func readMessageFromFile(filename string) (string, error) {
-	content, err := htmlFile.ReadFile(filename)
+// Email a single-use password reset link. Unknown emails are ignored so callers can't probe for accounts.
+func (userManager *userManager) ForgotPassword(email string) error {
+	var user models.User
+	result := database.DB.Where("email = ?", email).First(&user)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to find user: %w", result.Error)
+	}
+
+	token, err := common.GenerateOpaqueToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate reset token: %w", err)
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		// Only the latest link works
+		if err := tx.Where("user_id = ? AND used_at IS NULL", user.Id).Delete(&models.PasswordResetToken{}).Error; err != nil {
+			return fmt.Errorf("failed to clear previous reset tokens: %w", err)
+		}
+
+		resetToken := &models.PasswordResetToken{
+			UserID:    user.Id,
+			TokenHash: common.HashToken(token),
+			ExpiresAt: time.Now().Add(passwordResetExpiration),
+		}
+		if err := tx.Create(resetToken).Error; err != nil {
+			return fmt.Errorf("failed to store reset token: %w", err)
+		}
+
+		return enqueueEmail(tx, user.Email, "reset_password", resetPasswordData{
+			Name:             user.FirstName,
+			Link:             publicLink("/reset-password", token),
+			ExpiresInMinutes: int(passwordResetExpiration.Minutes()),
+		})
+	})
+}
+
+// Set a new password from a reset token and log the user out everywhere
+func (userManager *userManager) ResetPassword(token string, newPassword string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("failed to hash password: %w", err)
 	}
-	return string(content), nil
+
+	var userID uint
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var resetToken models.PasswordResetToken
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", common.HashToken(token)).First(&resetToken)
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return ErrInvalidResetToken
+			}
+			return fmt.Errorf("failed to find reset token: %w", result.Error)
+		}
+
+		if resetToken.UsedAt != nil || resetToken.ExpiresAt.Before(time.Now()) {
+			return ErrInvalidResetToken
+		}
+
+		now := time.Now()
+		resetToken.UsedAt = &now
+		if err := tx.Save(&resetToken).Error; err != nil {
+			return fmt.Errorf("failed to use reset token: %w", err)
+		}
+
+		// Proving ownership of the mailbox also lifts a lockout
+		result = tx.Model(&models.User{}).Where("id = ?", resetToken.UserID).Updates(map[string]interface{}{
+			"password":              string(hashedPassword),
+			"failed_login_attempts": 0,
+			"last_failed_login_at":  nil,
+			"locked_until":          nil,
+		})
+		if result.Error != nil {
+			return fmt.Errorf("failed to update password: %w", result.Error)
+		}
+
+		userID = resetToken.UserID
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return revokeSessions(database.DB.Where("user_id = ?", userID))
 }
@@ -1,15 +1,23 @@
 package managers
 
 import (
+	"errors"
 	"fmt"
 	"main/common"
 	"main/database"
 	"main/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrWishlistItemNotFound = errors.New("wishlist item not found")
 )
 
 type WishlistManager interface {
-	Add(wishlistData *common.WishlistCreationInput) (*models.Wishlist, error)
+	Add(userID uint, wishlistData *common.WishlistCreationInput) (*models.Wishlist, error)
 	View(userID uint) ([]models.Wishlist, error)
+	Get(wishlistID uint) (*models.Wishlist, error)
 	ViewAll() ([]models.Wishlist, error)
 	Delete(wishlistID uint) error
 }
@@ -21,9 +29,9 @@ func NewWishlistManager() WishlistManager {
 	return &wishlistManager{}
 }
 
-func (wishlistmanager *wishlistManager) Add(wishlistData *common.WishlistCreationInput) (*models.Wishlist, error) {
+func (wishlistmanager *wishlistManager) Add(userID uint, wishlistData *common.WishlistCreationInput) (*models.Wishlist, error) {
 	newWishlist := &models.Wishlist{
-		UserID:    wishlistData.UserID,
+		UserID:    userID,
 		ProductID: wishlistData.ProductID,
 	}
 
@@ -52,6 +60,18 @@ func (wishlistmanager *wishlistManager) View(userID uint) ([]models.Wishlist, er
 	return wishlistitems, nil
 }
 
+func (wishlistmanager *wishlistManager) Get(wishlistID uint) (*models.Wishlist, error) {
+	var wishlist models.Wishlist
+	result := database.DB.First(&wishlist, wishlistID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrWishlistItemNotFound
+		}
+		return nil, fmt.Errorf("failed to get wishlist item: %w", result.Error)
+	}
+	return &wishlist, nil
+}
+
 func (wishlistmanager *wishlistManager) Delete(wishlistID uint) error {
 	var wishlist models.Wishlist
 	result := database.DB.Delete(&wishlist, wishlistID)
@@ -1,8 +1,10 @@
 package models
 
 import (
-	"gorm.io/gorm"
+	"main/common"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type Address struct {
@@ -17,20 +19,160 @@ type Address struct {
 
 type User struct {
 	//gorm.Model
-	Id                uint           `gorm:"primarykey" json:"id"`
-	CreatedAt         time.Time      `json:"createdAt"`
-	UpdatedAt         time.Time      `json:"updatedAt"`
-	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
-	FirstName         string         `json:"firstName"`
-	LastName          string         `json:"lastName"`
-	Email             string         `json:"email"`
-	Password          string         `json:"password"`
-	Phone             string         `json:"phone"`
-	VerificationToken string         `gorm:"column:verification_token" json:"-"`
-	IsVerified        bool           `gorm:"column:is_verified;default:false" json:"isVerified"`
-	Token             string         `gorm:"uniqueIndex:idx_users_token,length:191" json:"-"`
-	Address           Address        `json:"address" gorm:"embedded"`
-	Image             string         `json:"image,omitempty"`
+	Id                         uint           `gorm:"primarykey" json:"id"`
+	CreatedAt                  time.Time      `json:"createdAt"`
+	UpdatedAt                  time.Time      `json:"updatedAt"`
+	DeletedAt                  gorm.DeletedAt `gorm:"index" json:"-"`
+	FirstName                  string         `json:"firstName"`
+	LastName                   string         `json:"lastName"`
+	Email                      string         `json:"email"`
+	Password                   string         `json:"-"`
+	Phone                      string         `json:"phone"`
+	PhoneVerified              bool           `gorm:"default:false" json:"phoneVerified"`
+	PhoneVerifiedAt            *time.Time     `json:"phoneVerifiedAt,omitempty"`
+	VerificationToken          string         `gorm:"column:verification_token" json:"-"`
+	VerificationTokenExpiresAt *time.Time     `json:"-"`
+	IsVerified                 bool           `gorm:"column:is_verified;default:false" json:"isVerified"`
+	Address                    Address        `json:"address" gorm:"embedded"`
+	Image                      string         `json:"image,omitempty"`
+	RoleID                     *uint          `gorm:"index" json:"roleID"`
+	Role                       *Role          `json:"role,omitempty" gorm:"foreignKey:RoleID"`
+	FailedLoginAttempts        int            `gorm:"default:0" json:"-"`
+	LastFailedLoginAt          *time.Time     `json:"-"`
+	LockedUntil                *time.Time     `json:"lockedUntil,omitempty"`
+	TwoFactorEnabled           bool           `gorm:"default:false" json:"twoFactorEnabled"`
+	TwoFactorSecret            string         `gorm:"size:64" json:"-"`
+	TwoFactorLastCounter       int64          `gorm:"default:0" json:"-"`
+}
+
+// Every login attempt, used to throttle by IP address
+type LoginAttempt struct {
+	Id        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"createdAt"`
+	Email     string    `gorm:"size:191" json:"email"`
+	IPAddress string    `gorm:"size:45;index" json:"ipAddress"`
+	Success   bool      `json:"success"`
+}
+
+// Single-use codes that replace a TOTP code when the authenticator is lost
+type RecoveryCode struct {
+	Id        uint       `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UserID    uint       `gorm:"index" json:"userID"`
+	User      User       `json:"-" gorm:"foreignKey:UserID"`
+	CodeHash  string     `gorm:"size:64;index" json:"-"`
+	UsedAt    *time.Time `json:"usedAt"`
+}
+
+// Issued by a password login on an account with 2FA, exchanged for tokens with a TOTP code
+type TwoFactorChallenge struct {
+	Id        uint       `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UserID    uint       `gorm:"index" json:"userID"`
+	User      User       `json:"-" gorm:"foreignKey:UserID"`
+	TokenHash string     `gorm:"uniqueIndex;size:64" json:"-"`
+	Attempts  int        `gorm:"default:0" json:"attempts"`
+	ExpiresAt time.Time  `gorm:"index" json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+}
+
+type Session struct {
+	Id          uint       `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	UserID      uint       `gorm:"index" json:"userID"`
+	User        User       `json:"-" gorm:"foreignKey:UserID"`
+	Jti         string     `gorm:"index;size:36" json:"-"`
+	LastSeenAt  time.Time  `json:"lastSeenAt"`
+	IPAddress   string     `gorm:"size:45" json:"ipAddress"`
+	UserAgent   string     `gorm:"size:512" json:"userAgent"`
+	DeviceLabel string     `json:"deviceLabel"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	RevokedAt   *time.Time `json:"revokedAt"`
+}
+
+type RefreshToken struct {
+	Id        uint       `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	UserID    uint       `gorm:"index" json:"userID"`
+	User      User       `json:"-" gorm:"foreignKey:UserID"`
+	SessionID uint       `gorm:"index" json:"sessionID"`
+	Session   Session    `json:"-" gorm:"foreignKey:SessionID"`
+	FamilyID  string     `gorm:"index;size:36" json:"familyID"`
+	TokenHash string     `gorm:"uniqueIndex;size:64" json:"-"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RotatedAt *time.Time `json:"rotatedAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+}
+
+type PasswordResetToken struct {
+	Id        uint       `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UserID    uint       `gorm:"index" json:"userID"`
+	User      User       `json:"-" gorm:"foreignKey:UserID"`
+	TokenHash string     `gorm:"uniqueIndex;size:64" json:"-"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+}
+
+// A requested change of email address, applied once the link sent to the new address is opened
+type EmailChange struct {
+	Id          uint       `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UserID      uint       `gorm:"index" json:"userID"`
+	User        User       `json:"-" gorm:"foreignKey:UserID"`
+	NewEmail    string     `gorm:"size:191" json:"newEmail"`
+	TokenHash   string     `gorm:"uniqueIndex;size:64" json:"-"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	ConfirmedAt *time.Time `json:"confirmedAt"`
+}
+
+// Every verification or email change link sent, used to rate limit them
+type EmailSend struct {
+	Id        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"createdAt"`
+	UserID    uint      `gorm:"index" json:"userID"`
+	Kind      string    `gorm:"size:20" json:"kind"`
+}
+
+// An email waiting to be delivered by the outbox worker. The bodies are cleared once it is sent.
+type OutboxEmail struct {
+	Id            uint       `gorm:"primaryKey" json:"id"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+	Recipient     string     `gorm:"size:191" json:"recipient"`
+	Subject       string     `gorm:"size:255" json:"subject"`
+	Template      string     `gorm:"size:50" json:"template"`
+	HTMLBody      string     `gorm:"type:text" json:"-"`
+	TextBody      string     `gorm:"type:text" json:"-"`
+	Status        string     `gorm:"size:20;index;default:pending" json:"status"`
+	Attempts      int        `gorm:"default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"index" json:"nextAttemptAt"`
+	LastError     string     `gorm:"size:1000" json:"lastError,omitempty"`
+	SentAt        *time.Time `json:"sentAt,omitempty"`
+}
+
+type RevokedToken struct {
+	Jti       string    `gorm:"primaryKey;size:36" json:"jti"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `gorm:"index" json:"expiresAt"`
+}
+
+type Role struct {
+	Id          uint         `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	UpdatedAt   time.Time    `json:"updatedAt"`
+	Name        string       `gorm:"uniqueIndex:idx_roles_name,length:191" json:"name"`
+	Description string       `json:"description"`
+	IsSystem    bool         `gorm:"default:false" json:"isSystem"`
+	Permissions []Permission `json:"permissions" gorm:"many2many:role_permissions"`
+}
+
+type Permission struct {
+	Id          uint   `gorm:"primaryKey" json:"id"`
+	Name        string `gorm:"uniqueIndex:idx_permissions_name,length:191" json:"name"`
+	Description string `json:"description"`
 }
 
 type Product struct {
@@ -42,10 +184,46 @@ type Product struct {
 	SKU         string         `json:"sku" gorm:"uniqueIndex:idx_products_sku,length:191"`
 	Name        string         `json:"name"`
 	Description string         `json:"description"`
-	Price       string         `json:"price"`
+	Price       common.Money   `json:"price" gorm:"embedded;embeddedPrefix:price_"`
 	Image       string         `json:"image,omitempty"`
 	CategoryID  uint           `json:"categoryID"`
 	Category    Category       `json:"category" gorm:"foreignKey:CategoryID"`
+	// Stock of a product without variants. Products with variants track it per variant.
+	Stock int `gorm:"default:0" json:"stock"`
+	// Stock not held by carts, summed over the variants for products with variants
+	Available         *int             `gorm:"-" json:"available,omitempty"`
+	LowStockThreshold *int             `json:"lowStockThreshold,omitempty"`
+	Variants          []ProductVariant `json:"variants,omitempty" gorm:"foreignKey:ProductID"`
+	// Taken from the category, or its parents, when not set on the product
+	HSNCode            string `gorm:"size:8" json:"hsnCode,omitempty"`
+	TaxRateBasisPoints *int   `json:"taxRateBasisPoints,omitempty"`
+}
+
+// A purchasable version of a product, such as a size or colour, with its own stock
+type ProductVariant struct {
+	Id                uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt         time.Time `json:"createdAt"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+	ProductID         uint      `gorm:"index" json:"productID"`
+	SKU               string    `gorm:"size:191;uniqueIndex" json:"sku"`
+	Name              string    `json:"name"`
+	Stock             int       `gorm:"default:0" json:"stock"`
+	Available         *int      `gorm:"-" json:"available,omitempty"`
+	LowStockThreshold *int      `json:"lowStockThreshold,omitempty"`
+}
+
+// Ledger of every stock change. Quantity is positive for stock coming in and negative for stock going out.
+type StockMovement struct {
+	Id         uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt  time.Time `gorm:"index" json:"createdAt"`
+	ProductID  uint      `gorm:"index" json:"productID"`
+	VariantID  *uint     `gorm:"index" json:"variantID,omitempty"`
+	Kind       string    `gorm:"size:20" json:"kind"`
+	Quantity   int       `json:"quantity"`
+	StockAfter int       `json:"stockAfter"`
+	OrderID    *uint     `gorm:"index" json:"orderID,omitempty"`
+	ActorID    *uint     `json:"actorID,omitempty"`
+	Note       string    `gorm:"size:255" json:"note,omitempty"`
 }
 
 type Category struct {
@@ -58,6 +236,9 @@ type Category struct {
 	ParentID    *uint          `json:"parentID"`
 	Children    []Category     `json:"children" gorm:"foreignKey:ParentID"`
 	Products    []Product      `json:"products" gorm:"foreignKey:CategoryID"`
+	// Defaults for the products in the category and its subcategories
+	HSNCode            string `gorm:"size:8" json:"hsnCode,omitempty"`
+	TaxRateBasisPoints *int   `json:"taxRateBasisPoints,omitempty"`
 }
 
 type Wishlist struct {
@@ -76,16 +257,206 @@ type Cart struct {
 	UpdatedAt time.Time `json:"updatedAt"`
 	UserID    uint      `json:"userID"`
 	ProductID uint      `json:"productID"`
+	VariantID *uint     `json:"variantID,omitempty"`
 	Quantity  uint      `json:"quantity"`
-	User      User      `json:"user" gorm:"foreignKey:UserID"`
-	Product   Product   `json:"product" gorm:"foreignKey:ProductID"`
+	// While in the future, Quantity is held for this cart and not available to others
+	ReservedUntil *time.Time `gorm:"index" json:"reservedUntil,omitempty"`
+	// The product's price when the line was added or last updated, to flag price changes
+	AddedPrice common.Money    `json:"-" gorm:"embedded;embeddedPrefix:added_price_"`
+	User       User            `json:"user" gorm:"foreignKey:UserID"`
+	Product    Product         `json:"product" gorm:"foreignKey:ProductID"`
+	Variant    *ProductVariant `json:"variant,omitempty" gorm:"foreignKey:VariantID"`
+}
+
+// A placed order. Items and the shipping address are copied at checkout, so later
+// catalogue or profile changes don't alter it.
+type Order struct {
+	Id              uint              `gorm:"primaryKey" json:"id"`
+	CreatedAt       time.Time         `gorm:"index" json:"createdAt"`
+	UpdatedAt       time.Time         `json:"updatedAt"`
+	UserID          uint              `gorm:"index" json:"userID"`
+	Status          string            `gorm:"size:20;index" json:"status"`
+	Subtotal        common.Money      `json:"subtotal" gorm:"embedded;embeddedPrefix:subtotal_"`
+	Discount        common.Money      `json:"discount" gorm:"embedded;embeddedPrefix:discount_"`
+	Tax             common.Money      `json:"tax" gorm:"embedded;embeddedPrefix:tax_"`
+	Shipping        common.Money      `json:"shipping" gorm:"embedded;embeddedPrefix:shipping_fee_"`
+	Total           common.Money      `json:"total" gorm:"embedded;embeddedPrefix:total_"`
+	CouponCode      string            `gorm:"size:64" json:"couponCode,omitempty"`
+	ShippingAddress Address           `json:"shippingAddress" gorm:"embedded;embeddedPrefix:shipping_"`
+	Items           []OrderItem       `json:"items" gorm:"foreignKey:OrderID"`
+	Transitions     []OrderTransition `json:"transitions,omitempty" gorm:"foreignKey:OrderID"`
+	Payments        []Payment         `json:"payments,omitempty" gorm:"foreignKey:OrderID"`
+	// Prices included tax, so Total does not add Tax on top
+	TaxInclusive bool `json:"taxInclusive"`
+}
+
+type OrderItem struct {
+	Id          uint         `gorm:"primaryKey" json:"id"`
+	OrderID     uint         `gorm:"index" json:"orderID"`
+	ProductID   uint         `gorm:"index" json:"productID"`
+	VariantID   *uint        `json:"variantID,omitempty"`
+	ProductName string       `json:"productName"`
+	VariantName string       `json:"variantName,omitempty"`
+	SKU         string       `gorm:"size:191" json:"sku"`
+	UnitPrice   common.Money `json:"unitPrice" gorm:"embedded;embeddedPrefix:unit_price_"`
+	Quantity    uint         `json:"quantity"`
+	LineTotal   common.Money `json:"lineTotal" gorm:"embedded;embeddedPrefix:line_total_"`
+	Discount    common.Money `json:"discount" gorm:"embedded;embeddedPrefix:discount_"`
+	Tax         common.Money `json:"tax" gorm:"embedded;embeddedPrefix:tax_"`
+	// How Discount is made up, one row per coupon or promotion
+	Discounts []OrderItemDiscount `json:"discounts,omitempty" gorm:"foreignKey:OrderItemID"`
+	// The value Tax is charged on, the line total after discounts without tax
+	TaxableValue common.Money   `json:"taxableValue" gorm:"embedded;embeddedPrefix:taxable_value_"`
+	HSNCode      string         `gorm:"size:8" json:"hsnCode,omitempty"`
+	Taxes        []OrderItemTax `json:"taxes,omitempty" gorm:"foreignKey:OrderItemID"`
+}
+
+// A tax charged on an order line, such as CGST at 9%
+type OrderItemTax struct {
+	Id          uint         `gorm:"primaryKey" json:"id"`
+	OrderItemID uint         `gorm:"index" json:"orderItemID"`
+	Name        string       `gorm:"size:10" json:"name"`
+	Rate        string       `gorm:"size:10" json:"rate"`
+	Amount      common.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+}
+
+// The share of a coupon or promotion allocated to an order line
+type OrderItemDiscount struct {
+	Id          uint         `gorm:"primaryKey" json:"id"`
+	OrderItemID uint         `gorm:"index" json:"orderItemID"`
+	Source      string       `gorm:"size:20" json:"source"`
+	SourceID    uint         `json:"sourceID"`
+	Label       string       `json:"label"`
+	Amount      common.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+}
+
+// Audit record of an order status change. FromStatus is empty for the checkout that created the order.
+type OrderTransition struct {
+	Id         uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt  time.Time `json:"createdAt"`
+	OrderID    uint      `gorm:"index" json:"orderID"`
+	FromStatus string    `gorm:"size:20" json:"fromStatus"`
+	ToStatus   string    `gorm:"size:20" json:"toStatus"`
+	Actor      string    `gorm:"size:20" json:"actor"`
+	ActorID    *uint     `json:"actorID,omitempty"`
+	Reason     string    `gorm:"size:255" json:"reason,omitempty"`
+}
+
+// One attempt to pay for an order. ProviderRef is the provider's ID for the payment.
+type Payment struct {
+	Id            uint         `gorm:"primaryKey" json:"id"`
+	CreatedAt     time.Time    `json:"createdAt"`
+	UpdatedAt     time.Time    `json:"updatedAt"`
+	OrderID       uint         `gorm:"index" json:"orderID"`
+	Provider      string       `gorm:"size:20" json:"provider"`
+	ProviderRef   string       `gorm:"size:191;index" json:"providerRef,omitempty"`
+	Method        string       `gorm:"size:50" json:"method,omitempty"`
+	Status        string       `gorm:"size:20;index" json:"status"`
+	Amount        common.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	FailureReason string       `gorm:"size:255" json:"failureReason,omitempty"`
+	// Captured for an order that was cancelled, and not refunded yet
+	RefundDue    bool   `gorm:"index" json:"refundDue,omitempty"`
+	ClientSecret string `gorm:"-" json:"clientSecret,omitempty"`
+}
+
+// A processed payment webhook, so redelivered events are ignored
+type PaymentEvent struct {
+	Id        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Provider  string    `gorm:"size:20;uniqueIndex:idx_payment_events_event" json:"provider"`
+	EventID   string    `gorm:"size:191;uniqueIndex:idx_payment_events_event" json:"eventID"`
+	Type      string    `gorm:"size:100" json:"type"`
+	PaymentID *uint     `gorm:"index" json:"paymentID,omitempty"`
+}
+
+// Only the HMAC of the code is stored, see managers.hashOTP
+type Otp struct {
+	ID          uint      `gorm:"primaryKey"`
+	UserID      uint      `gorm:"index" json:"userId"`
+	User        User      `gorm:"foreignKey:UserID"`
+	CodeHash    string    `gorm:"size:64" json:"-"`
+	Channel     string    `gorm:"size:10;default:sms" json:"channel"`
+	Purpose     string    `gorm:"size:20;default:verify;index" json:"purpose"`
+	Destination string    `gorm:"size:191" json:"destination"`
+	Attempts    int       `gorm:"default:0" json:"attempts"`
+	CreatedAt   time.Time `json:"createdAt"`
+	ExpiresAt   time.Time `gorm:"index" json:"expiresAt"`
+}
+
+// One row per OTP sent, used for the send quotas
+type OtpSend struct {
+	Id          uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time `gorm:"index" json:"createdAt"`
+	UserID      uint      `gorm:"index" json:"userID"`
+	Destination string    `gorm:"size:191;index" json:"destination"`
 }
 
-type Otp struct{
-	ID uint `gorm:"primaryKey"`
-	UserID uint `json:"userId"`
-	User User `gorm:"foreignKey:UserID"`
-	OTP string 	`json:"otp"`
+// A discount code customers apply to their cart. Percentage coupons take PercentOff and fixed
+// coupons AmountOff off the items they apply to, free shipping coupons waive the shipping fee.
+// A coupon scoped to products or categories applies to those items only, otherwise to all of them.
+type Coupon struct {
+	Id          uint         `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	UpdatedAt   time.Time    `json:"updatedAt"`
+	Code        string       `gorm:"size:64;uniqueIndex" json:"code"`
+	Description string       `json:"description,omitempty"`
+	Kind        string       `gorm:"size:20" json:"kind"`
+	PercentOff  int          `json:"percentOff,omitempty"`
+	AmountOff   common.Money `json:"amountOff" gorm:"embedded;embeddedPrefix:amount_off_"`
+	// Subtotal of the items the coupon applies to below which it is refused. No minimum without a currency.
+	MinSubtotal  common.Money `json:"minSubtotal" gorm:"embedded;embeddedPrefix:min_subtotal_"`
+	StartsAt     *time.Time   `json:"startsAt,omitempty"`
+	EndsAt       *time.Time   `json:"endsAt,omitempty"`
+	UsageLimit   *int         `json:"usageLimit,omitempty"`
+	PerUserLimit *int         `json:"perUserLimit,omitempty"`
+	TimesUsed    int          `gorm:"default:0" json:"timesUsed"`
+	Active       bool         `json:"active"`
+	Products     []Product    `json:"products,omitempty" gorm:"many2many:coupon_products"`
+	Categories   []Category   `json:"categories,omitempty" gorm:"many2many:coupon_categories"`
+}
+
+// A use of a coupon by an order, counted against its per-user limit
+type CouponRedemption struct {
+	Id        uint      `gorm:"primaryKey" json:"id"`
 	CreatedAt time.Time `json:"createdAt"`
-	ExpiresAt time.Time `json:"expiresAt"`
-}
\ No newline at end of file
+	CouponID  uint      `gorm:"index" json:"couponID"`
+	UserID    uint      `gorm:"index" json:"userID"`
+	OrderID   uint      `gorm:"uniqueIndex" json:"orderID"`
+}
+
+// The coupon applied to a user's cart
+type CartCoupon struct {
+	Id        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UserID    uint      `gorm:"uniqueIndex" json:"userID"`
+	CouponID  uint      `json:"couponID"`
+	Coupon    Coupon    `json:"coupon" gorm:"foreignKey:CouponID"`
+}
+
+// A discount applied to qualifying carts without a code. Buy X get Y gives GetQuantity units
+// at GetPercentOff for every BuyQuantity + GetQuantity units bought, the cheapest ones. Tiered
+// takes the PercentOff of the highest tier the subtotal reaches. Both count only the items the
+// promotion is scoped to, or all items when it has no products or categories.
+type Promotion struct {
+	Id            uint            `gorm:"primaryKey" json:"id"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	UpdatedAt     time.Time       `json:"updatedAt"`
+	Name          string          `json:"name"`
+	Kind          string          `gorm:"size:20" json:"kind"`
+	BuyQuantity   int             `json:"buyQuantity,omitempty"`
+	GetQuantity   int             `json:"getQuantity,omitempty"`
+	GetPercentOff int             `json:"getPercentOff,omitempty"`
+	StartsAt      *time.Time      `json:"startsAt,omitempty"`
+	EndsAt        *time.Time      `json:"endsAt,omitempty"`
+	Active        bool            `json:"active"`
+	Tiers         []PromotionTier `json:"tiers,omitempty" gorm:"foreignKey:PromotionID"`
+	Products      []Product       `json:"products,omitempty" gorm:"many2many:promotion_products"`
+	Categories    []Category      `json:"categories,omitempty" gorm:"many2many:promotion_categories"`
+}
+
+type PromotionTier struct {
+	Id          uint         `gorm:"primaryKey" json:"id"`
+	PromotionID uint         `gorm:"index" json:"promotionID"`
+	MinSubtotal common.Money `json:"minSubtotal" gorm:"embedded;embeddedPrefix:min_subtotal_"`
+	PercentOff  int          `json:"percentOff"`
+}